@@ -0,0 +1,137 @@
+package pages
+
+type (
+	// Allocator selects the free page that is reused when a page is
+	// allocated. If it doesn't select a page, a new page is appended to the
+	// end of the file.
+	Allocator interface {
+		// Allocate returns the offset of the free page that should be used
+		// for a new page and true or false if a new page should be appended
+		// instead. prev is the offset of the page that precedes the new page
+		// within its entry or -1 if there is none. It is only called if there
+		// are free pages.
+		Allocate(free FreeSpace, prev int64) (int64, bool)
+	}
+
+	// FreeSpace is a read-only view of the free pages of a PageManager. Its
+	// methods don't modify the free space, so an Allocator may query it as
+	// often as it likes and in any order. Only the page that the Allocator
+	// returns is allocated afterwards.
+	FreeSpace interface {
+		// Len returns the number of free pages
+		Len() int
+
+		// Last returns the offset of the page that was freed last
+		Last() int64
+
+		// Next returns the lowest offset of a free page that is at least off
+		Next(off int64) (int64, bool)
+
+		// NextRun returns the lowest offset of n contiguous free pages that
+		// start at off or later
+		NextRun(off int64, n int) (int64, bool)
+	}
+
+	// RunAllocator is an Allocator that can select runs of contiguous free
+	// pages. It is used when multiple pages are allocated at once. If it
+	// doesn't select a run, the pages are allocated one by one.
+	RunAllocator interface {
+		Allocator
+
+		// AllocateRun returns the offset of the first page of n contiguous
+		// free pages and true or false if no run should be used. prev is
+		// the offset of the page that precedes the run within its entry or
+		// -1 if there is none.
+		AllocateRun(free FreeSpace, prev int64, n int) (int64, bool)
+	}
+
+	// lifoAllocator reuses the page that was freed last and the first run of
+	// free pages
+	lifoAllocator struct{}
+
+	// nearPreviousAllocator reuses the page that follows the previous page
+	// of the entry most closely
+	nearPreviousAllocator struct{}
+
+	// lowestOffsetAllocator reuses the page with the lowest offset
+	lowestOffsetAllocator struct{}
+
+	// appendOnlyAllocator never reuses pages
+	appendOnlyAllocator struct{}
+)
+
+var (
+	// AllocateLIFO reuses the page that was freed last. Runs of pages are
+	// allocated from the first run of free pages that is large enough. It is
+	// the default Allocator.
+	AllocateLIFO Allocator = lifoAllocator{}
+
+	// AllocateNearPrevious reuses the free page that follows the previous
+	// page of an entry most closely which improves the locality of
+	// sequential reads. Pages that don't follow another page are allocated
+	// like AllocateLIFO does.
+	AllocateNearPrevious Allocator = nearPreviousAllocator{}
+
+	// AllocateLowestOffset reuses the free page with the lowest offset. This
+	// keeps the data at the beginning of the file which helps shrinking it.
+	AllocateLowestOffset Allocator = lowestOffsetAllocator{}
+
+	// AllocateAppendOnly never reuses free pages. New pages are always
+	// appended to the end of the file.
+	AllocateAppendOnly Allocator = appendOnlyAllocator{}
+)
+
+// Allocate returns the page that was freed last
+func (lifoAllocator) Allocate(free FreeSpace, prev int64) (int64, bool) {
+	return free.Last(), true
+}
+
+// AllocateRun returns the first run of free pages
+func (lifoAllocator) AllocateRun(free FreeSpace, prev int64, n int) (int64, bool) {
+	return free.NextRun(0, n)
+}
+
+// Allocate returns the first free page after prev or the page that was freed
+// last if there is none
+func (nearPreviousAllocator) Allocate(free FreeSpace, prev int64) (int64, bool) {
+	if prev >= 0 {
+		if off, ok := free.Next(prev + 1); ok {
+			return off, true
+		}
+	}
+	return free.Last(), true
+}
+
+// AllocateRun returns the first run of free pages after prev or the first run
+// of free pages if there is none
+func (nearPreviousAllocator) AllocateRun(free FreeSpace, prev int64, n int) (int64, bool) {
+	if prev >= 0 {
+		if off, ok := free.NextRun(prev+1, n); ok {
+			return off, true
+		}
+	}
+	return free.NextRun(0, n)
+}
+
+// Allocate returns the free page with the lowest offset
+func (lowestOffsetAllocator) Allocate(free FreeSpace, prev int64) (int64, bool) {
+	return free.Next(0)
+}
+
+// AllocateRun returns the run of free pages with the lowest offset
+func (lowestOffsetAllocator) AllocateRun(free FreeSpace, prev int64, n int) (int64, bool) {
+	return free.NextRun(0, n)
+}
+
+// Allocate never returns a free page
+func (appendOnlyAllocator) Allocate(free FreeSpace, prev int64) (int64, bool) {
+	return 0, false
+}
+
+// reusesFreePages returns false if an Allocator never reuses free pages. The
+// free pages of a PageManager only count towards the space that is available
+// within its MaxFileSize if its Allocator reuses them.
+func reusesFreePages(a Allocator) bool {
+	_, appendOnly := a.(appendOnlyAllocator)
+	return !appendOnly
+}
@@ -0,0 +1,163 @@
+package pages
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// newAllocatorTester is a helper function that creates a PageManager using an
+// Allocator and frees the pages of a deleted entry. The free pages are
+// interleaved with the pages of another entry.
+func newAllocatorTester(name string, allocator Allocator) (*PageManager, error) {
+	path, err := newTestDataFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := NewWithOptions(path, Options{Allocator: allocator})
+	if err != nil {
+		return nil, err
+	}
+	entry, id, err := pm.Create()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFragmented(pm, entry, fastrand.Bytes(20*pageSize)); err != nil {
+		return nil, err
+	}
+	if err := entry.Close(); err != nil {
+		return nil, err
+	}
+	return pm, pm.Delete(id)
+}
+
+// freeOffsets is a helper function that returns the sorted offsets of the
+// free pages
+func freeOffsets(b *bitmap) []int64 {
+	var offsets []int64
+	for i := int64(0); i < b.numPages; i++ {
+		if !b.isSet(i) {
+			offsets = append(offsets, b.offset(i))
+		}
+	}
+	return offsets
+}
+
+// TestAllocateLowestOffset tests if AllocateLowestOffset reuses the free page
+// with the lowest offset
+func TestAllocateLowestOffset(t *testing.T) {
+	pm, err := newAllocatorTester(t.Name(), AllocateLowestOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	for pm.bitmap.Len() > 0 {
+		expected := freeOffsets(pm.bitmap)[0]
+		page, err := pm.managedAllocatePage(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.fileOff != expected {
+			t.Fatalf("page should have offset %v but had %v", expected, page.fileOff)
+		}
+	}
+}
+
+// TestAllocateNearPrevious tests if AllocateNearPrevious reuses the free
+// pages following the previous page of an entry
+func TestAllocateNearPrevious(t *testing.T) {
+	pm, err := newAllocatorTester(t.Name(), AllocateNearPrevious)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Every page should be followed by the next free page
+	offsets := freeOffsets(pm.bitmap)
+	prev := offsets[0] - 1
+	for _, expected := range offsets {
+		page, err := pm.managedAllocatePage(prev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.fileOff != expected {
+			t.Fatalf("page should have offset %v but had %v", expected, page.fileOff)
+		}
+		prev = page.fileOff
+	}
+}
+
+// TestAllocateAppendOnly tests if AllocateAppendOnly never reuses pages
+func TestAllocateAppendOnly(t *testing.T) {
+	pm, err := newAllocatorTester(t.Name(), AllocateAppendOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	freePages := pm.bitmap.Len()
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if pm.bitmap.Len() != freePages {
+		t.Errorf("there should be %v free pages but there were %v", freePages, pm.bitmap.Len())
+	}
+	last := freeOffsets(pm.bitmap)[freePages-1]
+	if entry.ep.pp.fileOff < last || entry.ep.pages[0].fileOff < last {
+		t.Error("pages should be appended to the end of the file")
+	}
+}
+
+// TestAllocateRun tests if the pages of a write are allocated as a run of
+// contiguous free pages
+func TestAllocateRun(t *testing.T) {
+	pm, err := newAllocatorTester(t.Name(), AllocateLIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Free a run of pages after the fragmented free pages
+	pm.opts.Allocator = AllocateAppendOnly
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	pm.opts.Allocator = AllocateLIFO
+	if _, ok := pm.bitmap.NextRun(0, 10); !ok {
+		t.Fatal("there should be a run of free pages")
+	}
+
+	// Write to a new entry. Its pages should be contiguous.
+	entry, _, err = pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	for i, page := range entry.ep.pages {
+		if expected := entry.ep.pages[0].fileOff + int64(i)*pageSize; page.fileOff != expected {
+			t.Fatalf("page %v should have offset %v but had %v", i, expected, page.fileOff)
+		}
+	}
+	if len(entry.ep.root.extents) != 1 {
+		t.Errorf("the entry should have a single extent but had %v", len(entry.ep.root.extents))
+	}
+}
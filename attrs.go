@@ -0,0 +1,274 @@
+package pages
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// reservedAttrPrefix is the prefix of the attributes that are used by the
+// package itself. They can't be accessed using the attributes API.
+const reservedAttrPrefix = "pages."
+
+var (
+	// ErrAttrNotFound is returned if an entry doesn't have the requested
+	// attribute
+	ErrAttrNotFound = errors.New("attribute not found")
+
+	// ErrAttrsTooLarge is returned if the attributes of an entry would
+	// exceed MaxAttrsSize
+	ErrAttrsTooLarge = errors.New("attributes exceed MaxAttrsSize")
+
+	// errEmptyAttrKey is returned when trying to set an attribute without a
+	// key
+	errEmptyAttrKey = errors.New("attribute key can't be empty")
+
+	// errReservedAttrKey is returned when trying to change an attribute
+	// whose key starts with reservedAttrPrefix
+	errReservedAttrKey = errors.New("attribute key is reserved")
+)
+
+// checkAttrKey returns an error if key can't be used for a user-defined
+// attribute
+func checkAttrKey(key string) error {
+	if key == "" {
+		return errEmptyAttrKey
+	}
+	if strings.HasPrefix(key, reservedAttrPrefix) {
+		return errReservedAttrKey
+	}
+	return nil
+}
+
+// marshalAttrs encodes attributes sorted by their keys. Every attribute is
+// encoded as the uvarint length of its key followed by the key, the uvarint
+// length of the value and the value.
+func marshalAttrs(attrs map[string][]byte) ([]byte, error) {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := make([]byte, attrsLenSize)
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, key := range keys {
+		data = append(data, buf[:binary.PutUvarint(buf, uint64(len(key)))]...)
+		data = append(data, key...)
+		data = append(data, buf[:binary.PutUvarint(buf, uint64(len(attrs[key])))]...)
+		data = append(data, attrs[key]...)
+		if len(data)-attrsLenSize > MaxAttrsSize {
+			return nil, ErrAttrsTooLarge
+		}
+	}
+	binary.LittleEndian.PutUint16(data, uint16(len(data)-attrsLenSize))
+	return data, nil
+}
+
+// unmarshalAttrs decodes attributes encoded by marshalAttrs
+func unmarshalAttrs(data []byte) (map[string][]byte, error) {
+	attrs := make(map[string][]byte)
+	length := int(binary.LittleEndian.Uint16(data))
+	if length > MaxAttrsSize || length > len(data)-attrsLenSize {
+		return nil, errors.New("invalid length of attributes")
+	}
+	data = data[attrsLenSize : attrsLenSize+length]
+
+	// readField reads a length-prefixed field from data
+	readField := func() ([]byte, bool) {
+		n, bytesRead := binary.Uvarint(data)
+		if bytesRead <= 0 || n > uint64(len(data)-bytesRead) {
+			return nil, false
+		}
+		field := data[bytesRead : bytesRead+int(n)]
+		data = data[bytesRead+int(n):]
+		return field, true
+	}
+	for len(data) > 0 {
+		key, ok := readField()
+		if !ok || len(key) == 0 {
+			return nil, errors.New("failed to unmarshal attribute key")
+		}
+		value, ok := readField()
+		if !ok {
+			return nil, errors.New("failed to unmarshal attribute value")
+		}
+		attrs[string(key)] = append([]byte{}, value...)
+	}
+	return attrs, nil
+}
+
+// readAttrs reads the attributes of an entry from its entryPage
+func readAttrs(pp *physicalPage) (map[string][]byte, error) {
+	data := make([]byte, pageSize-attrsOff)
+	if _, err := pp.readAt(data, attrsOff); err != nil {
+		return nil, err
+	}
+	attrs, err := unmarshalAttrs(data)
+	if err != nil {
+		return nil, pageError(ErrCorrupted, pp.fileOff, "failed to read attributes: %v", err)
+	}
+	return attrs, nil
+}
+
+// writeAttrs writes the attributes of an entry to its entryPage
+func writeAttrs(pp *physicalPage, attrs map[string][]byte) error {
+	data, err := marshalAttrs(attrs)
+	if err != nil {
+		return err
+	}
+	_, err = pp.writeAt(data, attrsOff)
+	return err
+}
+
+// updateAttrs applies changes to the attributes of the entry and writes them
+// to disk. A nil value deletes an attribute. If the attributes would become
+// too large, they are left unchanged. The ep.mu write lock needs to be
+// acquired.
+func (ep *entryPage) updateAttrs(changes map[string][]byte) error {
+	attrs := make(map[string][]byte, len(ep.attrs)+len(changes))
+	for key, value := range ep.attrs {
+		attrs[key] = value
+	}
+	for key, value := range changes {
+		if key == "" {
+			return errEmptyAttrKey
+		}
+		if value == nil {
+			delete(attrs, key)
+			continue
+		}
+		attrs[key] = append([]byte{}, value...)
+	}
+	if err := writeAttrs(ep.pp, attrs); err != nil {
+		return err
+	}
+	ep.attrs = attrs
+	return nil
+}
+
+// SetAttr sets the attribute key of the entry to value. The attributes of an
+// entry are stored in its entryPage and their encoded size is limited to
+// MaxAttrsSize. Keys starting with "pages." are reserved.
+func (e *Entry) SetAttr(key string, value []byte) error {
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.pm.exit()
+	if err := checkAttrKey(key); err != nil {
+		return err
+	}
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+	if value == nil {
+		value = []byte{}
+	}
+	if err := e.ep.updateAttrs(map[string][]byte{key: value}); err != nil {
+		return withIdentifier(err, e.identifier())
+	}
+	return e.pm.syncer.afterWrite()
+}
+
+// GetAttr returns the value of the attribute key. If the entry doesn't have
+// the attribute, ErrAttrNotFound is returned.
+func (e *Entry) GetAttr(key string) ([]byte, error) {
+	if err := e.enter(); err != nil {
+		return nil, err
+	}
+	defer e.pm.exit()
+	e.ep.rLock()
+	defer e.ep.mu.RUnlock()
+	value, exists := e.ep.attrs[key]
+	if !exists || strings.HasPrefix(key, reservedAttrPrefix) {
+		return nil, ErrAttrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// ListAttrs returns the sorted keys of the entry's attributes. Reserved
+// attributes aren't listed. Like Size it remains valid after the PageManager
+// was closed.
+func (e *Entry) ListAttrs() []string {
+	e.ep.rLock()
+	defer e.ep.mu.RUnlock()
+	keys := make([]string, 0, len(e.ep.attrs))
+	for key := range e.ep.attrs {
+		if !strings.HasPrefix(key, reservedAttrPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DeleteAttr removes the attribute key from the entry. Deleting an attribute
+// that doesn't exist is a no-op.
+func (e *Entry) DeleteAttr(key string) error {
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.pm.exit()
+	if err := checkAttrKey(key); err != nil {
+		return err
+	}
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+	if _, exists := e.ep.attrs[key]; !exists {
+		return nil
+	}
+	if err := e.ep.updateAttrs(map[string][]byte{key: nil}); err != nil {
+		return withIdentifier(err, e.identifier())
+	}
+	return e.pm.syncer.afterWrite()
+}
+
+// WriteAtAttrs writes p at off and applies changes to the entry's attributes.
+// A nil value in changes deletes the attribute. Concurrent readers either see
+// both the data and the attributes or neither of them. If the attributes would
+// exceed MaxAttrsSize, ErrAttrsTooLarge is returned before any data is
+// written.
+func (e *Entry) WriteAtAttrs(p []byte, off int64, changes map[string][]byte) (int, error) {
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+
+	// Make sure the attributes fit before writing the data
+	attrs := make(map[string][]byte, len(e.ep.attrs)+len(changes))
+	for key, value := range e.ep.attrs {
+		attrs[key] = value
+	}
+	for key, value := range changes {
+		if err := checkAttrKey(key); err != nil {
+			return 0, err
+		}
+		if value == nil {
+			delete(attrs, key)
+		} else {
+			attrs[key] = value
+		}
+	}
+	if _, err := marshalAttrs(attrs); err != nil {
+		return 0, err
+	}
+
+	// Write the data
+	cursorPage := int64(0)
+	cursorOff := int64(0)
+	if err := e.seek(off, &cursorPage, &cursorOff); err != nil {
+		return 0, err
+	}
+	var events []Event
+	n, err := e.write(context.Background(), p, &cursorPage, &cursorOff, true, &events)
+	if err != nil {
+		return n, withIdentifier(err, e.identifier())
+	}
+	if err := e.ep.updateAttrs(changes); err != nil {
+		return n, withIdentifier(err, e.identifier())
+	}
+	return n, e.commit(events)
+}
@@ -0,0 +1,134 @@
+package pages
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestMarshalAttrs tests if marshaling and unmarshaling attributes works as
+// expected
+func TestMarshalAttrs(t *testing.T) {
+	attrs := map[string][]byte{
+		"content-type": []byte("application/json"),
+		"owner":        []byte("alice"),
+		"checksum":     fastrand.Bytes(32),
+		"empty":        {},
+	}
+	data, err := marshalAttrs(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmarshaled, err := unmarshalAttrs(append(data, make([]byte, 100)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, unmarshaled) {
+		t.Errorf("Unmarshaled attributes don't match: %v != %v", attrs, unmarshaled)
+	}
+
+	// Attributes that don't fit into the entryPage can't be marshaled
+	attrs["large"] = make([]byte, MaxAttrsSize)
+	if _, err := marshalAttrs(attrs); err != ErrAttrsTooLarge {
+		t.Errorf("err should be %v but was %v", ErrAttrsTooLarge, err)
+	}
+
+	// Invalid data shouldn't be unmarshaled
+	data[len(data)-1]++
+	data[attrsLenSize] = 100
+	if _, err := unmarshalAttrs(data); err == nil {
+		t.Error("Unmarshaling invalid data should fail")
+	}
+}
+
+// TestEntryAttrs tests if setting, getting, listing and deleting attributes of
+// an entry works and if they are persisted
+func TestEntryAttrs(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new entry has no attributes
+	if keys := entry.ListAttrs(); len(keys) != 0 {
+		t.Errorf("New entry shouldn't have attributes but had %v", keys)
+	}
+	if _, err := entry.GetAttr("owner"); err != ErrAttrNotFound {
+		t.Errorf("err should be %v but was %v", ErrAttrNotFound, err)
+	}
+
+	// Set some attributes and delete one of them
+	checksum := fastrand.Bytes(32)
+	if err := entry.SetAttr("owner", []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.SetAttr("checksum", checksum); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.SetAttr("content-type", []byte("text/plain")); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.DeleteAttr("owner"); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.SetAttr("", []byte("value")); err != errEmptyAttrKey {
+		t.Errorf("err should be %v but was %v", errEmptyAttrKey, err)
+	}
+
+	// Attributes exceeding the limit shouldn't change anything
+	if err := entry.SetAttr("large", make([]byte, MaxAttrsSize)); err != ErrAttrsTooLarge {
+		t.Errorf("err should be %v but was %v", ErrAttrsTooLarge, err)
+	}
+
+	// Write data and update the attributes at the same time
+	data := fastrand.Bytes(3 * pageSize)
+	newChecksum := fastrand.Bytes(32)
+	changes := map[string][]byte{"checksum": newChecksum, "content-type": nil}
+	if n, err := entry.WriteAtAttrs(data, 0, changes); err != nil || n != len(data) {
+		t.Fatalf("Failed to write data and attributes: %v %v", n, err)
+	}
+	if _, err := entry.WriteAtAttrs(data, 0, map[string][]byte{"large": make([]byte, MaxAttrsSize)}); err != ErrAttrsTooLarge {
+		t.Errorf("err should be %v but was %v", ErrAttrsTooLarge, err)
+	}
+
+	// Recover the PageManager and check the attributes and the data
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(pt.pm.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := entry.ListAttrs(); !reflect.DeepEqual(keys, []string{"checksum"}) {
+		t.Errorf("Entry should only have a checksum attribute but had %v", keys)
+	}
+	value, err := entry.GetAttr("checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(value, newChecksum) != 0 {
+		t.Error("checksum doesn't match")
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
@@ -0,0 +1,393 @@
+package pages
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+type (
+	// bitmap keeps track of the allocated pages of the data area. Every page
+	// is represented by a bit which is set while the page is allocated. The
+	// bitmap is stored in the pages of a tieredPage and cached in memory.
+	// The PageManager's mu needs to be acquired to access the bitmap.
+	bitmap struct {
+		// bitmap is a tieredPage
+		*tieredPage
+
+		// words contains the bits of all the pages of the bitmap
+		words []uint64
+
+		// numPages is the number of pages of the data area
+		numPages int64
+
+		// numFree is the number of free pages
+		numFree int
+
+		// hint is a lower bound for the index of the first free page
+		hint int64
+
+		// freed contains the indices of the pages in the order they were
+		// freed. Pages that were allocated again in the meantime are skipped
+		// by Last.
+		freed []int64
+
+		// written is the number of pages of the bitmap that were written to
+		// disk
+		written int
+
+		// growing indicates that pages are being added to the bitmap. Bits
+		// that are not covered by the written pages are written after all the
+		// pages were added.
+		growing bool
+	}
+)
+
+// newBitmap creates the bitmap of a new PageManager
+func newBitmap(pm *PageManager) (*bitmap, error) {
+	b := &bitmap{
+		tieredPage: &tieredPage{
+			pm: pm,
+			mu: new(sync.RWMutex),
+			pp: &physicalPage{
+				file:     pm.file,
+				fileOff:  freeOff,
+				usedSize: pageSize,
+			},
+		},
+	}
+	return b, b.create()
+}
+
+// loadBitmap loads the bitmap of an existing PageManager from disk
+func loadBitmap(pm *PageManager) (*bitmap, error) {
+	pp := &physicalPage{
+		file:     pm.file,
+		fileOff:  freeOff,
+		usedSize: pageSize,
+	}
+	usedSize, rootOff, height, err := readTieredPageRoot(pp)
+	if err != nil {
+		return nil, extendErr("Failed to read bitmap entry", err)
+	}
+	b := &bitmap{
+		tieredPage: &tieredPage{
+			pp:       pp,
+			usedSize: usedSize,
+			pm:       pm,
+			mu:       new(sync.RWMutex),
+		},
+	}
+	if err := b.recoverTree(context.Background(), rootOff, height); err != nil {
+		return nil, extendErr("Failed to recover bitmap", err)
+	}
+	b.written = len(b.pages)
+
+	// Read the bits of all the pages
+	data := make([]byte, pageSize)
+	for _, page := range b.pages {
+		if _, err := page.readAt(data, 0); err != nil {
+			return nil, extendErr("Failed to read bitmap page", err)
+		}
+		for i := 0; i < wordsPerPage; i++ {
+			b.words = append(b.words, binary.LittleEndian.Uint64(data[i*8:]))
+		}
+	}
+
+	// Count the free pages of the data area
+	size, err := pm.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, ioError(err, 0)
+	}
+	if size > pm.file.dataOff() {
+		b.numPages = b.index(size + pm.file.slotSize() - 1)
+	}
+	for i := int64(0); i < b.numPages; i++ {
+		if !b.isSet(i) {
+			b.numFree++
+			b.freed = append(b.freed, i)
+		}
+	}
+
+	// Pages that were appended while the bitmap was growing might not be
+	// covered yet
+	if int64(b.written)*bitsPerPage < b.numPages {
+		if err := b.grow(); err != nil {
+			return nil, extendErr("Failed to extend bitmap", err)
+		}
+	}
+	return b, nil
+}
+
+// create creates the pageTable tree of the bitmap and adds the pages that are
+// needed to cover the data area. The bitmap becomes the PageManager's bitmap.
+func (b *bitmap) create() error {
+	b.pm.bitmap = b
+	b.growing = true
+	root, err := newPageTable(0, nil, b.pm)
+	b.growing = false
+	if err != nil {
+		return extendErr("Failed to create pageTable for bitmap", err)
+	}
+	b.root = root
+	if err := writeTieredPageRoot(b.pp, 0, 0, root.pp.fileOff); err != nil {
+		return extendErr("Failed to initialize bitmap", err)
+	}
+	return b.grow()
+}
+
+// index returns the index of the bit of the page at fileOff
+func (b *bitmap) index(fileOff int64) int64 {
+	return (fileOff - b.pm.file.dataOff()) / b.pm.file.slotSize()
+}
+
+// offset returns the offset of the page with the given index
+func (b *bitmap) offset(index int64) int64 {
+	return b.pm.file.dataOff() + index*b.pm.file.slotSize()
+}
+
+// isSet returns true if the page with the given index is allocated
+func (b *bitmap) isSet(index int64) bool {
+	w := index / 64
+	return w < int64(len(b.words)) && b.words[w]&(1<<uint(index%64)) != 0
+}
+
+// extend adds words until the bit of the page with the given index is
+// covered. Words are added for whole pages of the bitmap.
+func (b *bitmap) extend(index int64) {
+	for int64(len(b.words)) <= index/64 {
+		b.words = append(b.words, make([]uint64, wordsPerPage)...)
+	}
+}
+
+// setBit sets or clears the bit of the page with the given index in memory
+func (b *bitmap) setBit(index int64, allocated bool) {
+	b.extend(index)
+	if allocated {
+		b.words[index/64] |= 1 << uint(index%64)
+	} else {
+		b.words[index/64] &^= 1 << uint(index%64)
+	}
+}
+
+// writeWord writes a word of the bitmap to disk. If the word isn't covered
+// by the bitmap's pages yet, pages are added to the bitmap.
+func (b *bitmap) writeWord(w int64) error {
+	if w/wordsPerPage >= int64(b.written) {
+		if b.growing {
+			return nil
+		}
+		return b.grow()
+	}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, b.words[w])
+	_, err := b.pages[w/wordsPerPage].writeAt(data, w%wordsPerPage*8)
+	return err
+}
+
+// grow adds pages to the bitmap until it covers all the pages of the data
+// area. Adding pages might allocate more pages which is why the new pages are
+// only written after all of them were added.
+func (b *bitmap) grow() error {
+	b.growing = true
+	defer func() {
+		b.growing = false
+	}()
+
+	// Add the pages to the tree
+	height := b.root.height
+	for int64(len(b.pages))*bitsPerPage < b.numPages {
+		page, err := b.pm.allocatePage()
+		if err != nil {
+			return extendErr("failed to allocate bitmap page", err)
+		}
+		if err := b.insertPage(b.nextIndex(), page); err != nil {
+			return extendErr("failed to insert page", err)
+		}
+		b.pages = append(b.pages, page)
+		b.usedSize += pageSize
+	}
+
+	// Write the new pages
+	b.extend(int64(len(b.pages))*bitsPerPage - 1)
+	data := make([]byte, pageSize)
+	for ; b.written < len(b.pages); b.written++ {
+		for i, word := range b.words[b.written*wordsPerPage : (b.written+1)*wordsPerPage] {
+			binary.LittleEndian.PutUint64(data[i*8:], word)
+		}
+		if _, err := b.pages[b.written].writeAt(data, 0); err != nil {
+			return err
+		}
+	}
+	return b.writeRoot(height)
+}
+
+// allocate marks the page at fileOff as allocated. Pages that were appended
+// to the data area are added to the bitmap.
+func (b *bitmap) allocate(fileOff int64) error {
+	index := b.index(fileOff)
+	if index >= b.numPages {
+		// Pages between the previous end of the data area and the new page
+		// are free
+		if b.hint > b.numPages {
+			b.hint = b.numPages
+		}
+		b.numFree += int(index - b.numPages + 1)
+		b.numPages = index + 1
+	} else if index < 0 || b.isSet(index) {
+		return critical("page %v is already allocated", fileOff)
+	}
+	b.setBit(index, true)
+	b.numFree--
+	if index == b.hint {
+		if next, found := b.nextFree(index + 1); found {
+			b.hint = next
+		} else {
+			b.hint = b.numPages
+		}
+	}
+
+	// Drop reused pages from the end of freed so Last doesn't need to skip
+	// them
+	for len(b.freed) > 0 && b.isSet(b.freed[len(b.freed)-1]) {
+		b.freed = b.freed[:len(b.freed)-1]
+	}
+	return b.writeWord(index / 64)
+}
+
+// take allocates the free page at fileOff and returns it
+func (b *bitmap) take(fileOff int64) (*physicalPage, error) {
+	if index := b.index(fileOff); index >= b.numPages {
+		return nil, critical("page %v is not free", fileOff)
+	}
+	if err := b.allocate(fileOff); err != nil {
+		return nil, err
+	}
+	return &physicalPage{
+		file:    b.pm.file,
+		fileOff: fileOff,
+	}, nil
+}
+
+// free marks pages as free. The changed words are written before it returns,
+// so freed pages, including the pageTables of truncated trees, can't get lost
+// if the process exits before they are reused. Every changed word is only
+// written once.
+func (b *bitmap) free(pages []*physicalPage) error {
+	var words []int64
+	for _, page := range pages {
+		index := b.index(page.fileOff)
+		if index < 0 || !b.isSet(index) {
+			return critical("page %v is not allocated", page.fileOff)
+		}
+		b.setBit(index, false)
+		b.numFree++
+		if index < b.hint {
+			b.hint = index
+		}
+		b.freed = append(b.freed, index)
+		words = append(words, index/64)
+	}
+	b.pm.metrics.add(MetricPagesFreed, int64(len(pages)))
+
+	// Drop the pages that were reused from freed once it gets too large
+	if len(b.freed) > 2*b.numFree+wordsPerPage {
+		freed := b.freed[:0]
+		for _, index := range b.freed {
+			if !b.isSet(index) {
+				freed = append(freed, index)
+			}
+		}
+		b.freed = freed
+	}
+
+	// Write the words
+	sort.Slice(words, func(i, j int) bool { return words[i] < words[j] })
+	for i, w := range words {
+		if i > 0 && words[i-1] == w {
+			continue
+		}
+		if err := b.writeWord(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextFree returns the lowest index of a free page that is at least index
+func (b *bitmap) nextFree(index int64) (int64, bool) {
+	for index < b.numPages {
+		w := index / 64
+		if w >= int64(len(b.words)) {
+			return index, true
+		}
+		free := ^b.words[w] >> uint(index%64)
+		if free == 0 {
+			index = (w + 1) * 64
+			continue
+		}
+		index += int64(bits.TrailingZeros64(free))
+		return index, index < b.numPages
+	}
+	return 0, false
+}
+
+// firstIndex returns the index of the first page at or after off that might
+// be free
+func (b *bitmap) firstIndex(off int64) int64 {
+	index := int64(0)
+	if off > b.pm.file.dataOff() {
+		index = b.index(off + b.pm.file.slotSize() - 1)
+	}
+	if index < b.hint {
+		index = b.hint
+	}
+	return index
+}
+
+// Len returns the number of free pages
+func (b *bitmap) Len() int {
+	return b.numFree
+}
+
+// Last returns the offset of the page that was freed last. Pages that were
+// free when the bitmap was loaded are considered to be freed in the order of
+// their offsets.
+func (b *bitmap) Last() int64 {
+	for i := len(b.freed) - 1; i >= 0; i-- {
+		if index := b.freed[i]; !b.isSet(index) {
+			return b.offset(index)
+		}
+	}
+	off, _ := b.Next(0)
+	return off
+}
+
+// Next returns the lowest offset of a free page that is at least off
+func (b *bitmap) Next(off int64) (int64, bool) {
+	index, found := b.nextFree(b.firstIndex(off))
+	return b.offset(index), found
+}
+
+// NextRun returns the lowest offset of n contiguous free pages that start at
+// off or later
+func (b *bitmap) NextRun(off int64, n int) (int64, bool) {
+	index := b.firstIndex(off)
+	for {
+		start, found := b.nextFree(index)
+		if !found {
+			return 0, false
+		}
+		end := start + 1
+		for end < start+int64(n) && end < b.numPages && !b.isSet(end) {
+			end++
+		}
+		if end == start+int64(n) {
+			return b.offset(start), true
+		}
+		index = end
+	}
+}
@@ -0,0 +1,307 @@
+package pages
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestLoadBitmap tests if the bitmap is loaded correctly after allocating and
+// freeing pages
+func TestLoadBitmap(t *testing.T) {
+	pm, err := newAllocatorTester(t.Name(), AllocateLowestOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := pm.managedAllocatePage(-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := freeOffsets(pm.bitmap)
+	numFree := pm.bitmap.Len()
+	if numFree != len(expected) {
+		t.Fatalf("there should be %v free pages but there were %v", len(expected), numFree)
+	}
+	if pm.bitmap, err = loadBitmap(pm); err != nil {
+		t.Fatal(err)
+	}
+	if pm.bitmap.Len() != numFree {
+		t.Fatalf("there should be %v free pages but there were %v", numFree, pm.bitmap.Len())
+	}
+	if offsets := freeOffsets(pm.bitmap); !reflect.DeepEqual(offsets, expected) {
+		t.Fatalf("free pages should be %v but were %v", expected, offsets)
+	}
+}
+
+// TestBitmapLIFO tests if the pages that were freed last are reused first
+func TestBitmapLIFO(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	pages, err := pt.pm.managedAllocatePages(10, -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shuffled := make([]*physicalPage, len(pages))
+	for i, j := range fastrand.Perm(len(pages)) {
+		shuffled[i] = pages[j]
+	}
+	pages = shuffled
+	if err := pt.pm.managedFreePages(pages); err != nil {
+		t.Fatal(err)
+	}
+	for i := len(pages) - 1; i >= 0; i-- {
+		page, err := pt.pm.managedAllocatePage(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.fileOff != pages[i].fileOff {
+			t.Fatalf("page should have offset %v but had %v", pages[i].fileOff, page.fileOff)
+		}
+	}
+	if pt.pm.bitmap.Len() != 0 {
+		t.Errorf("there should be no free pages but there were %v", pt.pm.bitmap.Len())
+	}
+}
+
+// TestBitmapGrow tests if pages are added to the bitmap once the data area
+// exceeds the pages covered by it
+func TestBitmapGrow(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Extend the file without writing to it and append a page afterwards.
+	// The pages in between are free and the new pages of the bitmap are
+	// taken from them.
+	b := pt.pm.bitmap
+	if b.Len() != 0 || b.numPages != 3 {
+		t.Fatalf("the bitmap of a new file should cover 3 allocated pages: %v %v", b.numPages, b.Len())
+	}
+	end := b.offset(3 * bitsPerPage)
+	if err := pt.pm.file.Truncate(end); err != nil {
+		t.Fatal(err)
+	}
+	page, err := pt.pm.managedAllocatePage(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.fileOff != end {
+		t.Fatalf("page should have offset %v but had %v", end, page.fileOff)
+	}
+	if len(b.pages) != 4 || b.written != 4 {
+		t.Fatalf("bitmap should have 4 pages but had %v", len(b.pages))
+	}
+	if b.numPages != 3*bitsPerPage+1 {
+		t.Fatalf("numPages should be %v but was %v", 3*bitsPerPage+1, b.numPages)
+	}
+	if b.Len() != 3*bitsPerPage-6 {
+		t.Fatalf("there should be %v free pages but there were %v", 3*bitsPerPage-6, b.Len())
+	}
+
+	// Reload the bitmap
+	if pt.pm.bitmap, err = loadBitmap(pt.pm); err != nil {
+		t.Fatal(err)
+	}
+	if pt.pm.bitmap.Len() != b.Len() {
+		t.Fatalf("there should be %v free pages but there were %v", b.Len(), pt.pm.bitmap.Len())
+	}
+	if !pt.pm.bitmap.isSet(pt.pm.bitmap.index(end)) {
+		t.Fatal("appended page should be allocated")
+	}
+}
+
+// TestNextRun tests if NextRun finds the first run of free pages
+func TestNextRun(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Allocate 10 pages and free every page but the 3rd and 6th
+	pages, err := pt.pm.managedAllocatePages(10, -1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var free []*physicalPage
+	for i, page := range pages {
+		if i != 2 && i != 5 {
+			free = append(free, page)
+		}
+	}
+	if err := pt.pm.managedFreePages(free); err != nil {
+		t.Fatal(err)
+	}
+
+	b := pt.pm.bitmap
+	hint, freed := b.hint, len(b.freed)
+	tests := []struct {
+		off      int64
+		n        int
+		expected int64
+		found    bool
+	}{
+		{0, 1, pages[0].fileOff, true},
+		{0, 2, pages[0].fileOff, true},
+		{0, 3, pages[6].fileOff, true},
+		{pages[1].fileOff, 2, pages[3].fileOff, true},
+		{pages[7].fileOff, 3, pages[7].fileOff, true},
+		{0, 5, 0, false},
+	}
+	for _, test := range tests {
+		off, found := b.NextRun(test.off, test.n)
+		if found != test.found || (found && off != test.expected) {
+			t.Errorf("NextRun(%v, %v) should be %v %v but was %v %v",
+				test.off, test.n, test.expected, test.found, off, found)
+		}
+	}
+
+	// Queries don't modify the bitmap
+	b.Next(pages[3].fileOff)
+	b.Last()
+	if b.hint != hint || len(b.freed) != freed {
+		t.Errorf("queries modified the bitmap: hint %v freed %v", b.hint, len(b.freed))
+	}
+}
+
+// checkPageAccounting is a helper function that makes sure that every page
+// after dataOff is either used by exactly one tree or free
+func checkPageAccounting(t *testing.T, pm *PageManager, ids map[Identifier]struct{}) {
+	t.Helper()
+	owners := make(map[int64]string)
+	use := func(owner string, pages []*physicalPage) {
+		for _, pp := range pages {
+			if prev, exists := owners[pp.fileOff]; exists {
+				t.Fatalf("page %v is used by %v and %v", pp.fileOff, prev, owner)
+			}
+			owners[pp.fileOff] = owner
+		}
+	}
+	use("bitmap", append(tablePages(pm.bitmap.root), pm.bitmap.pages...))
+	use("idTable", append(tablePages(pm.ids.root), pm.ids.pages...))
+	if pm.ids.pp.fileOff >= pm.file.dataOff() {
+		use("idTable", []*physicalPage{pm.ids.pp})
+	}
+	for id := range ids {
+		entry, err := pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner := id.String()
+		use(owner, []*physicalPage{entry.ep.pp})
+		use(owner, append(tablePages(entry.ep.root), entry.ep.pages...))
+		if entry.ep.merkleLeaves != nil {
+			use(owner, entry.ep.merkleLeaves.allPages())
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Compare the used pages to the bitmap
+	for i := int64(0); i < pm.bitmap.numPages; i++ {
+		owner, used := owners[pm.bitmap.offset(i)]
+		if !used && pm.bitmap.isSet(i) {
+			t.Fatalf("page %v was leaked", pm.bitmap.offset(i))
+		}
+		if used && !pm.bitmap.isSet(i) {
+			t.Fatalf("page %v is used by %v but free", pm.bitmap.offset(i), owner)
+		}
+		delete(owners, pm.bitmap.offset(i))
+	}
+	for off, owner := range owners {
+		t.Fatalf("page %v of %v is not part of the bitmap", off, owner)
+	}
+}
+
+// TestNoLeakedPages tests if every page is accounted for after random
+// allocations, truncations and deletions followed by restarts
+func TestNoLeakedPages(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pm.Close()
+	}()
+
+	ids := make(map[Identifier]struct{})
+	for cycle := 0; cycle < 10; cycle++ {
+		for op := 0; op < 10; op++ {
+			switch fastrand.Intn(4) {
+			case 0:
+				// Create two entries that might need multiple pageTables.
+				// Their pages are interleaved on disk. The first one also
+				// stores the leaves of its Merkle tree.
+				var entries []*Entry
+				for i := 0; i < 2; i++ {
+					entry, id, err := pm.Create()
+					if err != nil {
+						t.Fatal(err)
+					}
+					entries = append(entries, entry)
+					ids[id] = struct{}{}
+				}
+				for i := fastrand.Intn(4 * numPageEntries); i >= 0; i-- {
+					if _, err := entries[i%2].Write(fastrand.Bytes(pageSize)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := entries[0].MerkleRoot(); err != nil {
+					t.Fatal(err)
+				}
+				for _, entry := range entries {
+					entry.Close()
+				}
+			case 1, 2:
+				// Truncate a random entry which also frees its pageTables
+				for id := range ids {
+					entry, err := pm.Open(id)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := entry.Truncate(int64(fastrand.Intn(int(entry.Size()) + 1))); err != nil {
+						t.Fatal(err)
+					}
+					entry.Close()
+					break
+				}
+			case 3:
+				// Delete a random entry
+				for id := range ids {
+					if err := pm.Delete(id); err != nil {
+						t.Fatal(err)
+					}
+					delete(ids, id)
+					break
+				}
+			}
+		}
+
+		// Restart the PageManager and check the pages
+		if err := pm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if pm, err = New(path); err != nil {
+			t.Fatal(err)
+		}
+		checkPageAccounting(t, pm, ids)
+	}
+}
@@ -0,0 +1,69 @@
+package pages
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+var (
+	// flateWriters is a pool of flate writers. Allocating a new writer for
+	// every page is expensive so we reuse them.
+	flateWriters = sync.Pool{
+		New: func() interface{} {
+			w, err := flate.NewWriter(nil, flate.BestSpeed)
+			if err != nil {
+				panic(err)
+			}
+			return w
+		},
+	}
+
+	// flateReaders is a pool of flate readers.
+	flateReaders = sync.Pool{
+		New: func() interface{} {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+// compressPage compresses the contents of a page. If the compressed data
+// wouldn't save any space or is too large to be recorded in a pageTable slot,
+// ok will be false and the page should be stored uncompressed.
+func compressPage(data []byte) (compressed []byte, ok bool) {
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+
+	// Only use the compressed data if it is smaller than the original and if
+	// its size can be stored in a slot.
+	if buf.Len() >= len(data) || buf.Len() > maxStoredSize {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressPage decompresses the contents of a page that were compressed
+// using compressPage. usedSize is the length of the uncompressed data.
+func decompressPage(compressed []byte, usedSize int64) ([]byte, error) {
+	r := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(r)
+
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(compressed), nil); err != nil {
+		return nil, extendErr("failed to reset flate reader", err)
+	}
+	data := make([]byte, usedSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, extendErr("failed to decompress page", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,148 @@
+package pages
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// compressibleData is a helper function that creates length bytes of data
+// which compress well
+func compressibleData(length int) []byte {
+	var buf bytes.Buffer
+	for buf.Len() < length {
+		fmt.Fprintf(&buf, "{\"id\": %v, \"value\": \"%v\"}\n", fastrand.Intn(1000), fastrand.Intn(10))
+	}
+	return buf.Bytes()[:length]
+}
+
+// TestCompressPage tests if compressing and decompressing the data of a page
+// works as expected
+func TestCompressPage(t *testing.T) {
+	// Compressible data should be compressed
+	data := compressibleData(pageSize)
+	compressed, ok := compressPage(data)
+	if !ok {
+		t.Fatal("Failed to compress compressible data")
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("Compressed data should be smaller than %v but was %v", len(data), len(compressed))
+	}
+
+	// Decompress it and compare
+	decompressed, err := decompressPage(compressed, int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to decompress data: %v", err)
+	}
+	if bytes.Compare(data, decompressed) != 0 {
+		t.Error("Decompressed data doesn't match the original data")
+	}
+
+	// Random data shouldn't be compressed
+	if _, ok := compressPage(fastrand.Bytes(pageSize)); ok {
+		t.Error("Random data shouldn't be compressed")
+	}
+}
+
+// TestCompressedEntry tests if data written to an entry with compression
+// enabled can be read again after recovering the PageManager
+func TestCompressedEntry(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a PageManager with compression enabled
+	pm, err := NewWithOptions(dataFilePath, Options{Compression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, identifier, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write numPages pages worth of compressible data to the entry
+	numPages := 100
+	data := compressibleData(numPages * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+
+	// All the pages should be compressed
+	for i, page := range entry.ep.pages {
+		if page.storedSize == 0 {
+			t.Fatalf("Page %v wasn't compressed", i)
+		}
+	}
+
+	// Overwrite parts of the data
+	offset := int64(5000)
+	newData := compressibleData(100)
+	if _, err := entry.WriteAt(newData, offset); err != nil {
+		t.Fatalf("Failed to overwrite data: %v", err)
+	}
+	copy(data[offset:], newData)
+
+	// Read the data from the middle of a page
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData[100:], 100); err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if bytes.Compare(data[100:], readData[100:]) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Recover the PageManager without compression. The data should still be
+	// readable
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = New(dataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data after recovery")
+	}
+
+	// Overwriting a compressed page without compression should store it
+	// uncompressed
+	newData = fastrand.Bytes(pageSize)
+	if _, err := entry.WriteAt(newData, pageSize); err != nil {
+		t.Fatalf("Failed to overwrite data: %v", err)
+	}
+	copy(data[pageSize:], newData)
+	if entry.ep.pages[1].storedSize != 0 {
+		t.Errorf("storedSize should be 0 but was %v", entry.ep.pages[1].storedSize)
+	}
+
+	// Recover again and compare the data
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = New(dataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data after recovery")
+	}
+}
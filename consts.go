@@ -1,26 +1,144 @@
 package pages
 
 const (
+	// PageSize is the number of bytes of an entry's data that are stored in a
+	// single page. Merkle proofs are created for PageSize chunks of an entry.
+	PageSize = pageSize
+
 	// pageSize is the size in bytes of a physical page on disk
 	pageSize = 4096
 
 	// tieredPageEntrySize is the size of an entry in the entryPage
 	tieredPageEntrySize = 16
 
+	// maxTieredEntries is the maximum number of entries in a tieredPage. The
+	// remaining space of an entryPage is used for the entry's metadata
+	maxTieredEntries = 64
+
+	// merkleRootOff is the offset of an entry's Merkle root within the
+	// entryPage
+	merkleRootOff = maxTieredEntries * tieredPageEntrySize
+
+	// merkleRootSize is the size of the Merkle root on disk. 8 bytes indicate
+	// if the root is valid followed by the 32 byte hash
+	merkleRootSize = 8 + 32
+
+	// merkleLeavesOff is the offset within the entryPage at which the offset
+	// of the page with the tiered entries of the entry's merkleLeaves is
+	// stored. An offset of 0 means that the leaves weren't stored yet.
+	merkleLeavesOff = merkleRootOff + merkleRootSize
+
+	// merkleLeavesSize is the size of the merkleLeaves' offset on disk
+	merkleLeavesSize = 8
+
+	// entryHeaderOff is the offset of the header within the entryPage that
+	// identifies the page as an entryPage
+	entryHeaderOff = merkleLeavesOff + merkleLeavesSize
+
+	// entryHeaderSize is the size of the entryPage's header. It consists of
+	// the 8 byte entryPageTag followed by the 8 byte nonce of the entry.
+	entryHeaderSize = 8 + 8
+
+	// entryPageTag marks a page as an entryPage
+	entryPageTag = 0x6567617079727465
+
+	// attrsOff is the offset of the entry's attributes within the entryPage.
+	// They use the remaining space of the page.
+	attrsOff = entryHeaderOff + entryHeaderSize
+
+	// attrsLenSize is the size of the length prefix of the attributes
+	attrsLenSize = 2
+
+	// MaxAttrsSize is the maximum size of the encoded attributes of an
+	// entry. Every attribute needs len(key)+len(value) bytes plus the size of
+	// two varints.
+	MaxAttrsSize = pageSize - attrsOff - attrsLenSize
+
 	// numPageEntries is the number of entries that a marshalled pageTable can
 	// point to. 8 bytes for the number of entries and 8 for each entry
 	numPageEntries = (pageSize - 8) / 8.0
 
-	// freeOff is the offset of the freePages entryPage relative to the start
+	// readFromBatchSize is the number of bytes Entry.ReadFrom reads from a
+	// reader before writing them to the entry
+	readFromBatchSize = 64 * pageSize
+
+	// freeOff is the offset of the bitmap's entryPage relative to the start
 	// of the file
 	freeOff = 0
 
+	// idTableOff is the offset of the idTable's entryPage relative to the
+	// start of the file
+	idTableOff = 1 * pageSize
+
 	// dataOff is the offset of the data relative to the start of the file.
-	dataOff = 1 * pageSize
+	dataOff = 2 * pageSize
+
+	// idsPerPage is the number of entryPage offsets stored in a single page
+	// of the idTable
+	idsPerPage = pageSize / 8
+
+	// bitsPerPage is the number of pages a single page of the bitmap keeps
+	// track of
+	bitsPerPage = pageSize * 8
+
+	// wordsPerPage is the number of 64 bit words in a page of the bitmap
+	wordsPerPage = pageSize / 8
+
+	// storedSizeShift is the bit position within a pageTable slot at which
+	// the on-disk size of a compressed page is stored. The bits below it
+	// contain the offset of the page.
+	storedSizeShift = 43
+
+	// maxStoredSize is the largest on-disk size of a compressed page that can
+	// be recorded in a pageTable slot. Pages that don't compress below that
+	// size are stored uncompressed.
+	maxStoredSize = pageSize - 1
+
+	// extentCountShift is the bit position within the slot of an extent at
+	// which the number of pages of the extent minus one is stored
+	extentCountShift = 55
+
+	// maxExtentPages is the maximum number of pages in a single extent
+	maxExtentPages = 1 << (64 - extentCountShift)
+
+	// extentTableFlag marks pageTables that store extents instead of
+	// individual pages. It is set in the number of entries of the table.
+	extentTableFlag = 1 << 63
+
+	// pageTableFlag marks the number of entries of pageTables using the
+	// fixed-width format
+	pageTableFlag = 1 << 62
+
+	// slotValidFlag marks the slots of pageTables that point to other
+	// pageTables
+	slotValidFlag = 1 << 63
+
+	// entryValidFlag marks the entries of a tieredPage that are set. It is
+	// set in the offset of the pageTable.
+	entryValidFlag = 1 << 63
+
+	// entryRootFlag marks the entry of a tieredPage that points to the
+	// current root of the pageTable tree. It is set in the offset of the
+	// root.
+	entryRootFlag = 1 << 62
+
+	// maxFileSize is the maximum size of the file. Offsets need to fit into
+	// the bits below storedSizeShift.
+	maxFileSize = 1 << storedSizeShift
+
+	// sealHeaderSize is the size of the header of an encrypted page. It
+	// contains a 6 byte write counter and the 2 byte length of the sealed
+	// data.
+	sealHeaderSize = 8
+
+	// sealTagSize is the size of the authentication tag of an encrypted page
+	sealTagSize = 16
+
+	// sealOverhead is the number of additional bytes an encrypted page
+	// occupies on disk
+	sealOverhead = sealHeaderSize + sealTagSize
 
-	// freePagesOffset is the offset at which the free pages of the pageManager
-	// are stored on disk relative to the start of the file. Only
-	// maxFreePagesStored pages can be stored there but the defrag thread is
-	// constantly looking for new pages
-	freePagesOffset = 0
+	// maxSealCounter is the maximum value of the write counter of an
+	// encrypted page
+	maxSealCounter = 1<<48 - 1
 )
@@ -0,0 +1,7 @@
+//go:build !debug
+// +build !debug
+
+package pages
+
+// debug enables panics for failed sanity checks
+const debug = false
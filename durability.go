@@ -0,0 +1,203 @@
+package pages
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// SyncPolicy determines when data written to the PageManager is synced
+	// to disk. Once a sync failed, it's unknown which data is durable. The
+	// error is returned by every following write, sync and Close of the
+	// PageManager until the file is reopened.
+	SyncPolicy struct {
+		mode     syncMode
+		interval time.Duration
+	}
+
+	// syncMode is the type of a SyncPolicy
+	syncMode int
+
+	// syncer syncs the file of a PageManager according to its SyncPolicy
+	syncer struct {
+		// syncFile syncs the file of the PageManager
+		syncFile func() error
+
+		// policy is the SyncPolicy of the PageManager
+		policy SyncPolicy
+
+		// requested is the number of syncs that were requested and synced is
+		// the number of requested syncs that were completed. Every requester
+		// waits for synced to reach the value of requested at the time of its
+		// request.
+		requested uint64
+		synced    uint64
+
+		// syncing indicates if a sync is in progress
+		syncing bool
+
+		// dirty indicates if data was written since the last sync
+		dirty bool
+
+		// numSyncs is the number of times the file was synced
+		numSyncs uint64
+
+		// err is the error of a failed sync. After a failed sync it's unknown
+		// which data is durable so the error is returned for every following
+		// sync. It is never cleared, only reopening the PageManager resets it.
+		err error
+
+		// mu protects the fields above and cond is used to wait for syncs to
+		// complete
+		mu   *sync.Mutex
+		cond *sync.Cond
+
+		// stop is closed to stop the background thread of SyncInterval and
+		// wg is used to wait for it to return
+		stop chan struct{}
+		wg   *sync.WaitGroup
+	}
+)
+
+const (
+	syncNever syncMode = iota
+	syncAlways
+	syncGroupCommit
+	syncInterval
+)
+
+var (
+	// SyncNever never syncs data automatically. It is only durable after
+	// Entry.Sync was called.
+	SyncNever = SyncPolicy{mode: syncNever}
+
+	// SyncAlways syncs the file after every write. Writes only return after
+	// their data is durable.
+	SyncAlways = SyncPolicy{mode: syncAlways}
+
+	// SyncGroupCommit batches the syncs of concurrent writers into a single
+	// sync. Writes only return after their data is durable.
+	SyncGroupCommit = SyncPolicy{mode: syncGroupCommit}
+)
+
+// SyncInterval syncs written data periodically in the background. Data
+// written within the last interval might be lost on a crash.
+func SyncInterval(interval time.Duration) SyncPolicy {
+	return SyncPolicy{
+		mode:     syncInterval,
+		interval: interval,
+	}
+}
+
+// newSyncer creates a syncer and starts the background thread if necessary
+func newSyncer(syncFile func() error, policy SyncPolicy) *syncer {
+	s := &syncer{
+		syncFile: syncFile,
+		policy:   policy,
+		mu:       new(sync.Mutex),
+		stop:     make(chan struct{}),
+		wg:       new(sync.WaitGroup),
+	}
+	s.cond = sync.NewCond(s.mu)
+
+	if policy.mode == syncInterval {
+		s.wg.Add(1)
+		go s.threadedSyncInterval()
+	}
+	return s
+}
+
+// threadedSyncInterval periodically syncs the file if data was written. The
+// error of a failed sync is recorded in s.err and returned by the following
+// writes and syncs.
+func (s *syncer) threadedSyncInterval() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.policy.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		dirty := s.dirty
+		s.mu.Unlock()
+		if dirty {
+			_ = s.sync()
+		}
+	}
+}
+
+// afterWrite needs to be called after data was written. Depending on the
+// policy it syncs the data before returning. Policies that don't sync return
+// the error of a previous sync.
+func (s *syncer) afterWrite() error {
+	switch s.policy.mode {
+	case syncAlways:
+		s.mu.Lock()
+		if s.err != nil {
+			s.mu.Unlock()
+			return s.err
+		}
+		s.dirty = false
+		s.mu.Unlock()
+
+		// Sync without holding the lock to not serialize concurrent writers
+		err := s.syncFile()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.numSyncs++
+		if s.err == nil {
+			s.err = err
+		}
+		return s.err
+	case syncGroupCommit:
+		return s.sync()
+	default:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.dirty = true
+		return s.err
+	}
+}
+
+// sync makes all the data that was written before the call durable. If
+// multiple threads call sync at the same time, their requests are batched.
+// The first thread syncs the file while the others wait and the threads
+// waiting afterwards are served by the next sync.
+func (s *syncer) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requested++
+	request := s.requested
+	for s.synced < request && s.err == nil {
+		if s.syncing {
+			s.cond.Wait()
+			continue
+		}
+
+		// Sync all the requests up to this point
+		s.syncing = true
+		s.dirty = false
+		target := s.requested
+		s.mu.Unlock()
+		err := s.syncFile()
+		s.mu.Lock()
+		s.syncing = false
+		s.numSyncs++
+		s.synced = target
+		s.err = err
+		s.cond.Broadcast()
+	}
+	return s.err
+}
+
+// close stops the background thread and syncs the file a last time. Changes
+// to the metadata of the file don't mark the syncer as dirty, so the file is
+// always synced.
+func (s *syncer) close() error {
+	close(s.stop)
+	s.wg.Wait()
+	return s.sync()
+}
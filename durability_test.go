@@ -0,0 +1,217 @@
+package pages
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestSyncAlways tests if every write is synced when using SyncAlways
+func TestSyncAlways(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewWithOptions(dataFilePath, Options{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every write and truncate should cause a sync
+	numWrites := 5
+	for i := 0; i < numWrites; i++ {
+		if _, err := entry.Write(fastrand.Bytes(pageSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := entry.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if pm.syncer.numSyncs != uint64(numWrites+1) {
+		t.Errorf("numSyncs should be %v but was %v", numWrites+1, pm.syncer.numSyncs)
+	}
+}
+
+// TestSyncAlwaysConcurrent tests if concurrent writers using SyncAlways sync
+// at the same time and if a failed sync is returned by the following writes
+func TestSyncAlwaysConcurrent(t *testing.T) {
+	// Create a syncer that waits for all threads to sync
+	numThreads := 5
+	var wg sync.WaitGroup
+	wg.Add(numThreads)
+	syncErr := errors.New("sync failed")
+	var mu sync.Mutex
+	var fail bool
+	s := newSyncer(func() error {
+		wg.Done()
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return syncErr
+		}
+		return nil
+	}, SyncAlways)
+
+	// The threads would deadlock if the syncs were serialized
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var writers sync.WaitGroup
+		for i := 0; i < numThreads; i++ {
+			writers.Add(1)
+			go func() {
+				defer writers.Done()
+				if err := s.afterWrite(); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		writers.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("syncs were serialized")
+	}
+	if s.numSyncs != uint64(numThreads) {
+		t.Errorf("numSyncs should be %v but was %v", numThreads, s.numSyncs)
+	}
+
+	// After a failed sync every write returns the error
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	wg.Add(1)
+	if err := s.afterWrite(); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if err := s.afterWrite(); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+}
+
+// TestSyncGroupCommit tests if concurrent syncs are batched
+func TestSyncGroupCommit(t *testing.T) {
+	// Create a syncer with a slow sync
+	var mu sync.Mutex
+	var synced int
+	s := newSyncer(func() error {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		synced++
+		mu.Unlock()
+		return nil
+	}, SyncGroupCommit)
+
+	// Let many threads request a sync at the same time
+	numThreads := 50
+	var wg sync.WaitGroup
+	for i := 0; i < numThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.afterWrite(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The requests should have been batched
+	if synced >= numThreads || synced == 0 {
+		t.Errorf("%v requests should have been batched but there were %v syncs", numThreads, synced)
+	}
+	if s.synced != s.requested {
+		t.Errorf("all %v requests should have been synced but only %v were", s.requested, s.synced)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSyncInterval tests if written data is synced in the background when
+// using SyncInterval
+func TestSyncInterval(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewWithOptions(dataFilePath, Options{SyncPolicy: SyncInterval(10 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write some data and wait for it to be synced
+	if _, err := entry.Write(fastrand.Bytes(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		pm.syncer.mu.Lock()
+		synced := !pm.syncer.dirty && pm.syncer.numSyncs > 0
+		pm.syncer.mu.Unlock()
+		if synced {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pm.syncer.mu.Lock()
+	if pm.syncer.dirty || pm.syncer.numSyncs == 0 {
+		t.Error("data wasn't synced in the background")
+	}
+	pm.syncer.mu.Unlock()
+
+	// Closing the PageManager should stop the background thread
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSyncIntervalError tests if the error of a failed background sync is
+// returned by the following writes and syncs
+func TestSyncIntervalError(t *testing.T) {
+	syncErr := errors.New("sync failed")
+	s := newSyncer(func() error {
+		return syncErr
+	}, SyncInterval(time.Millisecond))
+
+	// The first write succeeds and is synced in the background
+	if err := s.afterWrite(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		failed := s.err != nil
+		s.mu.Unlock()
+		if failed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The error is returned by the next write, sync and close
+	if err := s.afterWrite(); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+	if err := s.sync(); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+	if err := s.close(); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+}
@@ -1,10 +1,10 @@
 package pages
 
 import (
+	"context"
 	"errors"
 	"io"
-
-	"github.com/NebulousLabs/Sia/build"
+	"sync/atomic"
 )
 
 type (
@@ -26,21 +26,50 @@ type (
 	}
 )
 
-// Close is a no-op
+// Close closes the instance of the entry. It returns ErrClosed if the
+// PageManager was closed before.
 func (e *Entry) Close() error {
-	e.ep.pm.mu.Lock()
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.pm.exit()
+	e.ep.pm.lock()
 	defer e.ep.pm.mu.Unlock()
 	// If the remaining entries pointing to this entryPage is 0 we can delete
 	// it from the map
 	e.ep.instanceCounter--
 	if e.ep.instanceCounter == 0 {
-		delete(e.ep.pm.entryPages, Identifier(e.ep.pp.fileOff))
+		delete(e.ep.pm.entryPages, e.ep.id)
+	}
+	return nil
+}
+
+// identifier returns the Identifier of the entry
+func (e *Entry) identifier() Identifier {
+	return e.ep.id
+}
+
+// enter registers an operation on the entry with the PageManager. If the
+// PageManager was closed, it returns ErrClosed for the entry's entryPage.
+func (e *Entry) enter() error {
+	if err := e.pm.enter(); err != nil {
+		return withIdentifier(pageError(err, e.ep.pp.fileOff, ""), e.identifier())
 	}
 	return nil
 }
 
-// read is a helper function that reads at a specific cursorPage and offset
-func (e *Entry) read(p []byte, cursorPage *int64, cursorOff *int64) (n int, err error) {
+// read is a helper function that reads at a specific cursorPage and offset.
+// It stops reading if ctx is cancelled.
+func (e *Entry) read(ctx context.Context, p []byte, cursorPage *int64, cursorOff *int64) (n int, err error) {
+	start := e.pm.metrics.start()
+	info := e.spanInfo(*cursorPage, *cursorOff, int64(len(p)))
+	span := e.pm.startSpan(SpanRead, info)
+	defer func() {
+		e.pm.metrics.add(MetricBytesRead, int64(n))
+		e.pm.metrics.observe(MetricReadDuration, start)
+		info.Bytes = int64(n)
+		span.End(info, err)
+	}()
 	if len(e.ep.pages) == 0 {
 		return 0, io.EOF
 	}
@@ -58,11 +87,20 @@ func (e *Entry) read(p []byte, cursorPage *int64, cursorOff *int64) (n int, err
 			break
 		}
 
-		// Read the data from the page
+		// Abort if the read was cancelled
+		if err := ctx.Err(); err != nil {
+			return copyDest, err
+		}
+
+		// Read the data from the page. If the last page isn't full we might
+		// reach its end before the end of the page.
 		var bytesRead int
-		bytesRead, err = e.ep.pages[*cursorPage].readAt(readData, *cursorOff)
+		bytesRead, err = e.ep.pages[*cursorPage].readAt(readData[:bytesToRead], *cursorOff)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return 0, err
+			return 0, withIdentifier(err, e.identifier())
 		}
 
 		// Adjust the remaining bytesToRead and the cursor position
@@ -87,14 +125,28 @@ func (e *Entry) read(p []byte, cursorPage *int64, cursorOff *int64) (n int, err
 
 // Read tries to read len(p) bytes from the current cursor position
 func (e *Entry) Read(p []byte) (n int, err error) {
-	e.ep.mu.RLock()
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	e.ep.rLock()
 	defer e.ep.mu.RUnlock()
-	return e.read(p, &e.cursorPage, &e.cursorOff)
+	return e.read(context.Background(), p, &e.cursorPage, &e.cursorOff)
 }
 
 // ReadAt reads from a specific offset
 func (e *Entry) ReadAt(p []byte, off int64) (int, error) {
-	e.ep.mu.RLock()
+	return e.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext reads from a specific offset. If ctx is cancelled before all
+// the data was read, the number of read bytes and ctx.Err() are returned.
+func (e *Entry) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	e.ep.rLock()
 	defer e.ep.mu.RUnlock()
 
 	// Seek to the offset from the beginning of the file
@@ -105,7 +157,7 @@ func (e *Entry) ReadAt(p []byte, off int64) (int, error) {
 	}
 
 	// Read the data
-	return e.read(p, &cursorPage, &cursorOff)
+	return e.read(ctx, p, &cursorPage, &cursorOff)
 }
 
 // seek is a helper function that seeks a specific offset starting at a
@@ -136,7 +188,11 @@ func (e *Entry) seek(offset int64, cursorPage *int64, cursorOff *int64) error {
 // Seek moves the cursor for reading and writing to the appropriate page and
 // offset
 func (e *Entry) Seek(offset int64, whence int) (int64, error) {
-	e.ep.mu.RLock()
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	e.ep.rLock()
 	defer e.ep.mu.RUnlock()
 
 	// Calculate the correct page and page offset
@@ -151,8 +207,8 @@ func (e *Entry) Seek(offset int64, whence int) (int64, error) {
 		pageNum = e.cursorPage
 		pageOff = e.cursorOff
 	case io.SeekEnd:
-		pageNum = int64(len(e.ep.pages))
-		pageOff = 0
+		pageNum = e.ep.usedSize / pageSize
+		pageOff = e.ep.usedSize % pageSize
 	}
 
 	err := e.seek(offset, &pageNum, &pageOff)
@@ -166,21 +222,68 @@ func (e *Entry) Seek(offset int64, whence int) (int64, error) {
 	return e.cursorPage*pageSize + e.cursorOff, nil
 }
 
-// Sync calls sync on the underlying file of the Page Manager
+// Size returns the size of the entry's data in bytes. It only reads the
+// in-memory state of the entry and remains valid after the PageManager was
+// closed, in which case it returns the size the entry had at that time.
+func (e *Entry) Size() int64 {
+	e.ep.rLock()
+	defer e.ep.mu.RUnlock()
+	return e.ep.usedSize
+}
+
+// Sync calls sync on the underlying file of the Page Manager. Concurrent
+// calls are batched into a single sync.
 func (e *Entry) Sync() error {
-	return e.pm.file.Sync()
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.pm.exit()
+	return e.pm.syncer.sync()
 }
 
 // Truncate shortens an entry to size bytes
 func (e *Entry) Truncate(size int64) error {
-	e.ep.mu.Lock()
-	defer e.ep.mu.Unlock()
+	return e.TruncateContext(context.Background(), size)
+}
 
-	// Recursively truncate the tree
-	_, pagesToFree1, err := e.ep.recursiveTruncate(e.ep.root, size)
-	if err != nil {
+// TruncateContext shortens an entry to size bytes. If ctx is cancelled before
+// the entry is fully truncated, the entry is left at a size between its
+// previous size and size and ctx.Err() is returned.
+func (e *Entry) TruncateContext(ctx context.Context, size int64) error {
+	if err := e.enter(); err != nil {
 		return err
 	}
+	defer e.pm.exit()
+	var events []Event
+	if err := e.truncate(ctx, size, &events); err != nil {
+		return withIdentifier(err, e.identifier())
+	}
+	return e.commit(events)
+}
+
+// truncate is a helper function that shortens an entry to size bytes. The
+// events for the watchers are appended to events if it isn't nil.
+func (e *Entry) truncate(ctx context.Context, size int64, events *[]Event) error {
+	start := e.pm.metrics.start()
+	defer e.pm.metrics.observe(MetricTruncateDuration, start)
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+	oldSize := e.ep.usedSize
+	oldPages := len(e.ep.pages)
+
+	// The leaf of the last remaining page changes
+	if size < e.ep.usedSize {
+		if err := e.ep.invalidateMerkleLeaves(int(size/pageSize), int(size/pageSize)+1); err != nil {
+			return extendErr("failed to invalidate Merkle leaves", err)
+		}
+	}
+
+	// Recursively truncate the tree. If the truncation was cancelled we still
+	// need to finish updating the tree for the removed pages.
+	_, pagesToFree1, truncateErr := e.ep.recursiveTruncate(ctx, e.ep.root, size)
+	if truncateErr != nil && truncateErr != ctx.Err() {
+		return truncateErr
+	}
 
 	// Defrag the tree afterwards
 	pagesToFree2, err := e.ep.defrag()
@@ -188,65 +291,143 @@ func (e *Entry) Truncate(size int64) error {
 		return err
 	}
 
+	// Remove the leaves of the removed pages
+	if err := e.ep.truncateMerkleLeaves(); err != nil {
+		return extendErr("failed to truncate Merkle leaves", err)
+	}
+
 	// Free pages
-	return e.pm.freePages.addPages(append(pagesToFree1, pagesToFree2...))
+	atomic.AddInt64(&e.pm.atomicDataPages, int64(len(e.ep.pages)-oldPages))
+	if err := e.pm.managedFreePages(append(pagesToFree1, pagesToFree2...)); err != nil {
+		return err
+	}
+
+	// Remember the event for the watchers
+	if e.ep.usedSize < oldSize && events != nil {
+		*events = append(*events, Truncated{Size: e.ep.usedSize})
+	}
+	return truncateErr
+}
+
+// writeEvents appends the events for n bytes that were written at off to an
+// entry of size oldSize to events if it isn't nil
+func (e *Entry) writeEvents(events *[]Event, off, n, oldSize int64) {
+	if events == nil {
+		return
+	}
+	overwritten := oldSize - off
+	if overwritten > n {
+		overwritten = n
+	}
+	if overwritten > 0 {
+		*events = append(*events, Overwritten{Off: off, Len: overwritten})
+	}
+	if e.ep.usedSize > oldSize {
+		*events = append(*events, Appended{NewSize: e.ep.usedSize})
+	}
+}
+
+// commit needs to be called after a change of the entry was written. It calls
+// the syncer's afterWrite and informs the watchers about the events of the
+// change once the change is committed.
+func (e *Entry) commit(events []Event) error {
+	if err := e.pm.syncer.afterWrite(); err != nil {
+		return err
+	}
+	for _, ev := range events {
+		e.pm.notify(e.ep.id, ev)
+	}
+	return nil
 }
 
-// write is a helper function that writes at a specific cursorPage and offset
-func (e *Entry) write(p []byte, cursorPage *int64, cursorOff *int64) (int, error) {
+// write is a helper function that writes at a specific cursorPage and offset.
+// If ctx is cancelled, it stops writing after the current page and returns the
+// number of bytes written so far and ctx.Err(). The caller needs to hold the
+// ep.mu read lock which is upgraded if necessary or the write lock if locked
+// is true. The events for the watchers are appended to events if it isn't
+// nil.
+func (e *Entry) write(ctx context.Context, p []byte, cursorPage *int64, cursorOff *int64, locked bool, events *[]Event) (n int, err error) {
 	// Get the amount of bytes the caller would like to write
 	bytesToWrite := int64(len(p))
 
 	// Inform the entryPage about new pages and the increase data usage
 	byteIncrease := int64(0)
-	addedPages := make([]*physicalPage, 0)
+	var addedPages []*physicalPage
+
+	// Remember the pages whose on-disk size changed. Their pageTables need to
+	// be updated afterwards
+	changedPages := make(map[uint64]struct{})
 
 	// backup cursorPage and cursorOff in case we need to reset the loop
 	bCursorPage := *cursorPage
 	bCursorOff := *cursorOff
 
+	// Remember the size of the entry before the write
+	oldSize := e.ep.usedSize
+
 	// Write until all the bytes are written. If necessary allocate new pages
 	writeCursor := 0
-	appending := false
+	start := e.pm.metrics.start()
+	info := e.spanInfo(*cursorPage, *cursorOff, int64(len(p)))
+	span := e.pm.startSpan(SpanWrite, info)
+	defer func() {
+		e.pm.metrics.add(MetricBytesWritten, int64(writeCursor))
+		e.pm.metrics.observe(MetricWriteDuration, start)
+		info.Bytes = int64(n)
+		span.End(info, err)
+	}()
+
+	// Make sure the write doesn't exceed a quota and allocate the new pages
+	// before anything is written. Only writes that hold the write lock can
+	// grow the entry.
+	end := bCursorPage*pageSize + bCursorOff + bytesToWrite
+	if locked {
+		if err := e.checkQuota(end); err != nil {
+			return 0, err
+		}
+		if addedPages, byteIncrease, err = e.allocatePages(end, *cursorPage); err != nil {
+			return 0, err
+		}
+		defer e.freeReserved(&err)
+	}
+	if err := e.invalidateMerkleLeaves(bCursorPage, end); err != nil {
+		return 0, err
+	}
+
+	appending := locked
 	for bytesToWrite > 0 {
-		// Check if we are going to add a new page or extend the last page
+		// Check if we are going to add a new page, extend the last page or
+		// rewrite a compressed or encrypted page
 		if !appending &&
 			(*cursorPage >= int64(len(e.ep.pages)) ||
+				e.ep.pages[*cursorPage].wholePageIO() ||
 				(*cursorPage == int64(len(e.ep.pages)-1) &&
 					*cursorOff+bytesToWrite > e.ep.pages[*cursorPage].usedSize)) {
 			// Seems like we are appending now. Change to write lock and
 			// restart loop.
 			appending = true
 			e.ep.mu.RUnlock()
-			e.ep.mu.Lock()
+			e.ep.lock()
 			defer e.ep.mu.RLock()
 			defer e.ep.mu.Unlock()
+			if err := e.checkQuota(end); err != nil {
+				return 0, err
+			}
 
 			// Reset loop
 			*cursorPage = bCursorPage
 			*cursorOff = bCursorOff
+			oldSize = e.ep.usedSize
 			bytesToWrite = int64(len(p))
 			writeCursor = 0
-			byteIncrease = int64(0)
-			addedPages = make([]*physicalPage, 0)
-			continue
-		}
-
-		if *cursorPage >= int64(len(e.ep.pages)) {
-			// Allocate new page if necessary
-			newPage, err := e.pm.managedAllocatePage()
-			if err != nil {
+			if addedPages, byteIncrease, err = e.allocatePages(end, *cursorPage); err != nil {
 				return 0, err
 			}
-			// Add it to the list of pages and addedPages
-			addedPages = append(addedPages, newPage)
-			e.ep.pages = append(e.ep.pages, newPage)
-
-			// If we still don't have enough pages mark this page as full
-			if *cursorPage >= int64(len(e.ep.pages)) {
-				newPage.usedSize = pageSize
-				byteIncrease += pageSize
+			defer e.freeReserved(&err)
+			if err := e.invalidateMerkleLeaves(bCursorPage, end); err != nil {
+				return 0, err
 			}
+			changedPages = make(map[uint64]struct{})
 			continue
 		}
 
@@ -254,11 +435,15 @@ func (e *Entry) write(p []byte, cursorPage *int64, cursorOff *int64) (int, error
 		// of the page
 		page := e.ep.pages[*cursorPage]
 		usedPageSize := page.usedSize
+		storedSize := page.storedSize
 		bytesWritten, err := page.writeAt(p[writeCursor:], *cursorOff)
 		byteIncrease += (page.usedSize - usedPageSize)
 		if err != nil {
 			return 0, err
 		}
+		if page.storedSize != storedSize {
+			changedPages[uint64(*cursorPage)] = struct{}{}
+		}
 
 		// Adjust the remaining bytesToWrite and the cursor position
 		bytesToWrite -= int64(bytesWritten)
@@ -269,25 +454,181 @@ func (e *Entry) write(p []byte, cursorPage *int64, cursorOff *int64) (int, error
 
 		// Increment the writeCursor of the input data
 		writeCursor += bytesWritten
+
+		// Stop if the write was cancelled. The written pages still need to
+		// be added to the entry.
+		if bytesToWrite > 0 && ctx.Err() != nil {
+			break
+		}
+	}
+
+	// Free the allocated pages that weren't written to since the write was
+	// cancelled
+	written := len(addedPages)
+	for written > 0 && addedPages[written-1].usedSize == 0 {
+		written--
+	}
+	if written < len(addedPages) {
+		e.ep.pages = e.ep.pages[:len(e.ep.pages)-len(addedPages)+written]
+		if err := e.pm.managedFreePages(addedPages[written:]); err != nil {
+			return 0, extendErr("failed to free unused pages", err)
+		}
+		addedPages = addedPages[:written]
 	}
-	err := e.ep.addPages(addedPages, byteIncrease)
+
+	err = e.ep.addPages(addedPages, byteIncrease)
 	if err != nil {
-		return 0, build.ExtendErr("failed to add pages to entryPage", err)
+		return 0, extendErr("failed to add pages to entryPage", err)
+	}
+	if err := e.ep.updatePageTables(changedPages); err != nil {
+		return 0, extendErr("failed to update pageTables", err)
 	}
+	e.writeEvents(events, bCursorPage*pageSize+bCursorOff, int64(writeCursor), oldSize)
 
+	if writeCursor < len(p) {
+		return writeCursor, ctx.Err()
+	}
 	return len(p), nil
 }
 
-// Write tries to write len(p) byte to the current cursor position
+// spanInfo returns the SpanInfo of an operation on bytes bytes of the entry
+// at a specific cursorPage and offset
+func (e *Entry) spanInfo(cursorPage, cursorOff, bytes int64) SpanInfo {
+	info := SpanInfo{
+		ID:         e.ep.id,
+		PageOffset: -1,
+		Offset:     cursorPage*pageSize + cursorOff,
+		Bytes:      bytes,
+	}
+	if cursorPage < int64(len(e.ep.pages)) {
+		info.PageOffset = e.ep.pages[cursorPage].fileOff
+	}
+	return info
+}
+
+// Write tries to write len(p) byte to the current cursor position. Depending
+// on the SyncPolicy it only returns after the data is durable.
 func (e *Entry) Write(p []byte) (int, error) {
-	e.ep.mu.RLock()
-	defer e.ep.mu.RUnlock()
-	return e.write(p, &e.cursorPage, &e.cursorOff)
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	var events []Event
+	e.ep.rLock()
+	n, err := e.write(context.Background(), p, &e.cursorPage, &e.cursorOff, false, &events)
+	e.ep.mu.RUnlock()
+	if err != nil {
+		return n, withIdentifier(err, e.identifier())
+	}
+	return n, e.commit(events)
 }
 
-// WriteAt writes to a specific offset
+// ReadFrom writes the data of r to the current cursor position until r
+// returns io.EOF. The data is written in batches of readFromBatchSize bytes
+// which end at page boundaries. Depending on the SyncPolicy it only returns
+// after the data is durable. It implements io.ReaderFrom.
+func (e *Entry) ReadFrom(r io.Reader) (int64, error) {
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	buf := make([]byte, readFromBatchSize)
+	var total int64
+	var events []Event
+	for {
+		// Fill the batch. The first batch might start in the middle of a
+		// page.
+		batch := buf[:readFromBatchSize-e.cursorOff]
+		n, err := io.ReadFull(r, batch)
+		if n > 0 {
+			e.ep.lock()
+			written, err := e.write(context.Background(), batch[:n], &e.cursorPage, &e.cursorOff, true, &events)
+			e.ep.mu.Unlock()
+			total += int64(written)
+			if err != nil {
+				return total, withIdentifier(err, e.identifier())
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, e.commit(events)
+}
+
+// WriteTo writes the data from the current cursor position to the end of the
+// entry to w. Every page is read from disk into a reusable buffer and passed
+// to w without copying it. It implements io.WriterTo.
+func (e *Entry) WriteTo(w io.Writer) (int64, error) {
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	buf := make([]byte, pageSize)
+	var total int64
+	for {
+		// Read the data of the page the cursor points to
+		e.ep.rLock()
+		if e.cursorPage >= int64(len(e.ep.pages)) {
+			e.ep.mu.RUnlock()
+			return total, nil
+		}
+		data, err := e.ep.pages[e.cursorPage].dataAt(buf, e.cursorOff)
+		e.ep.mu.RUnlock()
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, withIdentifier(err, e.identifier())
+		}
+
+		// Pass it to w and move the cursor
+		n := len(data)
+		written, err := w.Write(data)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+		if written < n {
+			return total, io.ErrShortWrite
+		}
+		e.ep.rLock()
+		err = e.seek(int64(written), &e.cursorPage, &e.cursorOff)
+		e.ep.mu.RUnlock()
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteAt writes to a specific offset. Depending on the SyncPolicy it only
+// returns after the data is durable.
 func (e *Entry) WriteAt(p []byte, off int64) (n int, err error) {
-	e.ep.mu.RLock()
+	return e.WriteAtContext(context.Background(), p, off)
+}
+
+// WriteAtContext writes to a specific offset. If ctx is cancelled before all
+// the data was written, the number of written bytes and ctx.Err() are
+// returned. The data written up to that point is part of the entry.
+func (e *Entry) WriteAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if err := e.enter(); err != nil {
+		return 0, err
+	}
+	defer e.pm.exit()
+	var events []Event
+	if n, err = e.writeAt(ctx, p, off, &events); err != nil {
+		return n, withIdentifier(err, e.identifier())
+	}
+	return n, e.commit(events)
+}
+
+// writeAt is a helper function that writes to a specific offset. The events
+// for the watchers are appended to events if it isn't nil.
+func (e *Entry) writeAt(ctx context.Context, p []byte, off int64, events *[]Event) (n int, err error) {
+	e.ep.rLock()
 	defer e.ep.mu.RUnlock()
 
 	// Seek to the offset from the beginning of the file
@@ -298,5 +639,74 @@ func (e *Entry) WriteAt(p []byte, off int64) (n int, err error) {
 	}
 
 	// Write data
-	return e.write(p, &cursorPage, &cursorOff)
+	return e.write(ctx, p, &cursorPage, &cursorOff, false, events)
+}
+
+// invalidateMerkleLeaves invalidates the leaves of the pages that a write
+// from page firstPage up to the offset end touches
+func (e *Entry) invalidateMerkleLeaves(firstPage, end int64) error {
+	endPage := (end + pageSize - 1) / pageSize
+	if endPage > int64(len(e.ep.pages)) {
+		endPage = int64(len(e.ep.pages))
+	}
+	if err := e.ep.invalidateMerkleLeaves(int(firstPage), int(endPage)); err != nil {
+		return extendErr("failed to invalidate Merkle leaves", err)
+	}
+	return nil
+}
+
+// MerkleRoot returns the Merkle root over the pages of the entry. If the root
+// stored on disk is outdated, the pages that changed since the root was
+// computed are hashed. Writes only invalidate the hashes of the pages they
+// touch, so the cost of hashing is paid here instead. MerkleRoot holds the
+// entry's write lock while hashing, which blocks all readers and writers of
+// the entry until every changed page was read and hashed.
+func (e *Entry) MerkleRoot() (Hash, error) {
+	if err := e.enter(); err != nil {
+		return Hash{}, err
+	}
+	defer e.pm.exit()
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+	e.ep.merkleMu.Lock()
+	defer e.ep.merkleMu.Unlock()
+
+	// Use the stored root if possible
+	if e.ep.merkleTree == nil && e.ep.merkleRootValid {
+		root, _, err := readMerkleRoot(e.ep.pp)
+		return root, withIdentifier(err, e.identifier())
+	}
+	if err := e.ep.updateMerkleTree(); err != nil {
+		return Hash{}, extendErr("failed to update Merkle tree", withIdentifier(err, e.identifier()))
+	}
+	return e.ep.merkleTree.root(), nil
+}
+
+// Proof creates a proof that the page at pageIndex is part of the entry. The
+// page's data can be read from pageIndex*PageSize and verified using
+// VerifyProof. Like MerkleRoot it hashes the pages that changed since the
+// last call while holding the entry's write lock.
+func (e *Entry) Proof(pageIndex int64) (MerkleProof, error) {
+	if err := e.enter(); err != nil {
+		return MerkleProof{}, err
+	}
+	defer e.pm.exit()
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+	e.ep.merkleMu.Lock()
+	defer e.ep.merkleMu.Unlock()
+
+	if pageIndex < 0 || pageIndex >= int64(len(e.ep.pages)) {
+		return MerkleProof{}, errInvalidPageIndex
+	}
+	if e.ep.merkleTree == nil || !e.ep.merkleRootValid {
+		if err := e.ep.updateMerkleTree(); err != nil {
+			return MerkleProof{}, extendErr("failed to update Merkle tree", withIdentifier(err, e.identifier()))
+		}
+	}
+	return MerkleProof{
+		PageIndex: pageIndex,
+		NumPages:  int64(len(e.ep.pages)),
+		Hashes:    e.ep.merkleTree.proof(int(pageIndex)),
+	}, nil
 }
@@ -2,6 +2,7 @@ package pages
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"sync"
 	"testing"
@@ -37,11 +38,12 @@ func TestEntrySeek(t *testing.T) {
 	}
 
 	// Add a page to the entry
-	pp, err := pt.pm.managedAllocatePage()
+	pp, err := pt.pm.managedAllocatePage(-1)
 	if err != nil {
 		t.Errorf("Failed to allocate new page: %v", err)
 	}
 	entry.ep.pages = append(entry.ep.pages, pp)
+	entry.ep.usedSize += pageSize
 
 	// Seek to the start of the page
 	pos, err = entry.Seek(0, io.SeekStart)
@@ -70,15 +72,16 @@ func TestEntrySeek(t *testing.T) {
 	}
 
 	// Add two more pages to the entry
-	pp1, err := pt.pm.managedAllocatePage()
+	pp1, err := pt.pm.managedAllocatePage(-1)
 	if err != nil {
 		t.Errorf("Failed to allocate new page: %v", err)
 	}
-	pp2, err := pt.pm.managedAllocatePage()
+	pp2, err := pt.pm.managedAllocatePage(-1)
 	if err != nil {
 		t.Errorf("Failed to allocate new page: %v", err)
 	}
 	entry.ep.pages = append(entry.ep.pages, pp1, pp2)
+	entry.ep.usedSize += 2 * pageSize
 
 	// Seek to the end of the 3 pages
 	pos, err = entry.Seek(0, io.SeekEnd)
@@ -139,7 +142,7 @@ func TestEntryRead(t *testing.T) {
 	entryData := make([]byte, 0)
 	for i := 0; i < 3; i++ {
 		// Allocate pages
-		pp, err := pt.pm.managedAllocatePage()
+		pp, err := pt.pm.managedAllocatePage(-1)
 		if err != nil {
 			t.Errorf("Failed to allocate new page: %v", err)
 		}
@@ -286,6 +289,7 @@ func TestTruncate(t *testing.T) {
 
 	// Truncate the file
 	truncatedSize := int64(15000)
+	tables := int64(totalTables(entry.ep.root))
 	if err := entry.Truncate(truncatedSize); err != nil {
 		t.Errorf("Truncate failed %v", err)
 	}
@@ -301,11 +305,11 @@ func TestTruncate(t *testing.T) {
 		t.Errorf("len(entry.pages) should be %v but was %v", expectedPages, len(entry.ep.pages))
 	}
 
-	// The remaining pages should be in the freePages slice
-	freedPageTables := int64(pages/numPageEntries) + 1
-	if int64(pt.pm.freePages.nextIndex()) != int64(pages)-expectedPages+freedPageTables {
+	// The remaining pages should be free
+	freedPageTables := tables - int64(totalTables(entry.ep.root))
+	if int64(pt.pm.bitmap.Len()) != int64(pages)-expectedPages+freedPageTables {
 		t.Errorf("there should be %v free pages but there are %v",
-			int64(pages)-expectedPages+freedPageTables, pt.pm.freePages.nextIndex())
+			int64(pages)-expectedPages+freedPageTables, pt.pm.bitmap.Len())
 	}
 
 	// Make sure the data wasn't corrupted
@@ -442,3 +446,180 @@ func TestWriteTruncateConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestEntryContext tests if cancelling ReadAtContext, WriteAtContext and
+// TruncateContext stops them while leaving the entry in a consistent state
+func TestEntryContext(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled write should stop after the first page
+	data := fastrand.Bytes(10 * pageSize)
+	n, err := entry.WriteAtContext(ctx, data, 0)
+	if err != context.Canceled {
+		t.Errorf("err should be %v but was %v", context.Canceled, err)
+	}
+	if n != pageSize {
+		t.Errorf("n should be %v but was %v", pageSize, n)
+	}
+	if entry.ep.usedSize != pageSize || len(entry.ep.pages) != 1 {
+		t.Errorf("entry should contain a single page but had %v pages and %v bytes",
+			len(entry.ep.pages), entry.ep.usedSize)
+	}
+
+	// Write the rest of the data without a context
+	if _, err := entry.WriteAt(data[n:], int64(n)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cancelled read shouldn't return any data
+	readData := make([]byte, len(data))
+	if n, err := entry.ReadAtContext(ctx, readData, 0); err != context.Canceled || n != 0 {
+		t.Errorf("ReadAtContext should return 0 bytes and %v but returned %v bytes and %v",
+			context.Canceled, n, err)
+	}
+
+	// A cancelled truncate shouldn't remove any pages
+	if err := entry.TruncateContext(ctx, 0); err != context.Canceled {
+		t.Errorf("err should be %v but was %v", context.Canceled, err)
+	}
+	if entry.ep.usedSize != int64(len(data)) {
+		t.Errorf("usedSize should be %v but was %v", len(data), entry.ep.usedSize)
+	}
+
+	// The entry should still be consistent after recovering it
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+}
+
+// TestAppendPartialPage tests if data can be appended to an entry whose last
+// page is only partially used
+func TestAppendPartialPage(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Append chunks that don't line up with the pages
+	var data []byte
+	for i := 0; i < 10; i++ {
+		chunk := fastrand.Bytes(1000 + fastrand.Intn(pageSize))
+		if _, err := entry.WriteAt(chunk, int64(len(data))); err != nil {
+			t.Fatalf("Failed to append chunk %v: %v", i, err)
+		}
+		data = append(data, chunk...)
+	}
+	if entry.Size() != int64(len(data)) {
+		t.Errorf("size should be %v but was %v", len(data), entry.Size())
+	}
+
+	// Read the data and compare it
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(data, readData) != 0 {
+		t.Error("Read data doesn't match written data")
+	}
+
+	// Seeking the end should return the size of the entry
+	if pos, err := entry.Seek(0, io.SeekEnd); err != nil || pos != int64(len(data)) {
+		t.Errorf("Position should be %v but was %v: %v", len(data), pos, err)
+	}
+}
+
+// TestEntryReadFromWriteTo tests if data can be copied into and out of an
+// entry using io.Copy
+func TestEntryReadFromWriteTo(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy data that spans multiple batches into the entry. The reader is
+	// wrapped to prevent io.Copy from using bytes.Reader.WriteTo.
+	data := fastrand.Bytes(2*readFromBatchSize + 3*pageSize + 100)
+	n, err := io.Copy(entry, struct{ io.Reader }{bytes.NewReader(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || entry.Size() != int64(len(data)) {
+		t.Fatalf("%v bytes should be written but %v were", len(data), n)
+	}
+
+	// Overwrite data in the middle of a page and append more data at the
+	// same time
+	off := int64(len(data) - 1000)
+	if _, err := entry.Seek(off, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	newData := fastrand.Bytes(readFromBatchSize)
+	if _, err := entry.ReadFrom(bytes.NewReader(newData)); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data[:off], newData...)
+
+	// Copy the data out of the entry starting in the middle of a page
+	if _, err := entry.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err = io.Copy(&buf, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)-100) || !bytes.Equal(buf.Bytes(), data[100:]) {
+		t.Fatalf("data doesn't match: %v bytes were copied", n)
+	}
+
+	// The cursor should be at the end of the entry
+	if n, err := entry.WriteTo(&buf); err != nil || n != 0 {
+		t.Errorf("no data should be written but %v bytes were: %v", n, err)
+	}
+
+	// The pages are read into a single buffer
+	allocs := testing.AllocsPerRun(5, func() {
+		if _, err := entry.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.WriteTo(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if numPages := len(entry.ep.pages); allocs >= float64(numPages) {
+		t.Errorf("copying %v pages shouldn't need %v allocations", numPages, allocs)
+	}
+}
@@ -0,0 +1,204 @@
+// Package entryfs exposes the entries of a PageManager as a read-only
+// filesystem. The names of the entries are stored in a kv.Store. Directories
+// don't exist on their own but are derived from the slash-separated names.
+package entryfs
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/HopeThinkLab/pages"
+	"github.com/HopeThinkLab/pages/kv"
+)
+
+var (
+	// errNameConflict is returned when trying to link a name that is a
+	// directory or that is within the directory of another file
+	errNameConflict = errors.New("name conflicts with an existing file or directory")
+)
+
+// FS is a read-only filesystem over the named entries of a PageManager. It
+// implements fs.FS, fs.ReadDirFS and fs.StatFS.
+type FS struct {
+	// pm is the PageManager that stores the entries
+	pm *pages.PageManager
+
+	// names maps the names of the files to the Identifiers of their entries
+	names *kv.Store
+}
+
+// New creates an FS for the entries named in names
+func New(pm *pages.PageManager, names *kv.Store) *FS {
+	return &FS{
+		pm:    pm,
+		names: names,
+	}
+}
+
+// dirPrefix returns the prefix of the names within a directory
+func dirPrefix(name string) string {
+	if name == "." {
+		return ""
+	}
+	return name + "/"
+}
+
+// prefixEnd returns the smallest key that is greater than all the keys with
+// the given prefix. It is only used for prefixes that end with a slash.
+func prefixEnd(prefix string) []byte {
+	if prefix == "" {
+		return nil
+	}
+	return []byte(prefix[:len(prefix)-1] + "0")
+}
+
+// Link names an entry. The name needs to be a valid path according to
+// fs.ValidPath. Its parent directories can't be files and it can't be a
+// directory itself.
+func Link(names *kv.Store, name string, id pages.Identifier) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "link", Path: name, Err: fs.ErrInvalid}
+	}
+
+	// None of the parents can be files
+	for i := strings.Index(name, "/"); i >= 0; i = nextSlash(name, i) {
+		if _, err := names.Get([]byte(name[:i])); err == nil {
+			return &fs.PathError{Op: "link", Path: name, Err: errNameConflict}
+		} else if err != kv.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	// The name can't be a directory
+	prefix := dirPrefix(name)
+	c := names.Range([]byte(prefix), prefixEnd(prefix))
+	if c.Next() {
+		return &fs.PathError{Op: "link", Path: name, Err: errNameConflict}
+	}
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return names.Put([]byte(name), id[:])
+}
+
+// Unlink removes the name of an entry. The entry itself is not deleted.
+func Unlink(names *kv.Store, name string) error {
+	err := names.Delete([]byte(name))
+	if err == kv.ErrKeyNotFound {
+		return &fs.PathError{Op: "unlink", Path: name, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// nextSlash returns the index of the next slash in name after i or -1
+func nextSlash(name string, i int) int {
+	j := strings.Index(name[i+1:], "/")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// lookup returns the Identifier of a file. If name is a directory, isDir is
+// true.
+func (fsys *FS) lookup(op, name string) (id pages.Identifier, isDir bool, err error) {
+	if !fs.ValidPath(name) {
+		return id, false, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return id, true, nil
+	}
+	b, err := fsys.names.Get([]byte(name))
+	if err == nil {
+		copy(id[:], b)
+		return id, false, nil
+	}
+	if err != kv.ErrKeyNotFound {
+		return id, false, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	// The name is a directory if any other name starts with it
+	prefix := dirPrefix(name)
+	c := fsys.names.Range([]byte(prefix), prefixEnd(prefix))
+	if c.Next() {
+		return id, true, nil
+	}
+	if err := c.Err(); err != nil {
+		return id, false, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return id, false, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Open opens a file or directory
+func (fsys *FS) Open(name string) (fs.File, error) {
+	id, isDir, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		return &dir{fsys: fsys, name: name}, nil
+	}
+	e, err := fsys.pm.Open(id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{e: e, name: name}, nil
+}
+
+// Stat returns the FileInfo of a file or directory
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	id, isDir, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		return fileInfo{name: name, dir: true}, nil
+	}
+	e, err := fsys.pm.Open(id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	defer e.Close()
+	return fileInfo{name: name, size: e.Size()}, nil
+}
+
+// ReadDir returns the entries of a directory sorted by their names
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	_, isDir, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	// Iterate over all the names within the directory. If a name is within
+	// a subdirectory, the rest of the subdirectory is skipped.
+	var entries []fs.DirEntry
+	prefix := dirPrefix(name)
+	end := prefixEnd(prefix)
+	c := fsys.names.Range([]byte(prefix), end)
+	for c.Next() {
+		child := strings.TrimPrefix(string(c.Key()), prefix)
+		if i := strings.Index(child, "/"); i >= 0 {
+			child = child[:i]
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo{name: child, dir: true}))
+			c = fsys.names.Range(prefixEnd(prefix+child+"/"), end)
+			continue
+		}
+		info, err := fsys.Stat(prefix + child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	if err := c.Err(); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
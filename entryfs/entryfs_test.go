@@ -0,0 +1,137 @@
+package entryfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/HopeThinkLab/pages"
+	"github.com/HopeThinkLab/pages/kv"
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// newTestFS is a helper function that creates an FS for testing
+func newTestFS(name string) (*FS, func(), error) {
+	testdir := build.TempDir("entryfs", name)
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		return nil, nil, err
+	}
+	pm, err := pages.New(filepath.Join(testdir, "data.dat"))
+	if err != nil {
+		return nil, nil, err
+	}
+	names, _, err := kv.Create(pm)
+	if err != nil {
+		pm.Close()
+		return nil, nil, err
+	}
+	closeFn := func() {
+		names.Close()
+		pm.Close()
+	}
+	return New(pm, names), closeFn, nil
+}
+
+// createFile is a helper function that creates an entry with the given data
+// and links it
+func createFile(fsys *FS, name string, data []byte) error {
+	e, id, err := fsys.pm.Create()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	if _, err := e.Write(data); err != nil {
+		return err
+	}
+	return Link(fsys.names, name, id)
+}
+
+// TestFS tests the FS using fstest.TestFS
+func TestFS(t *testing.T) {
+	fsys, closeFn, err := newTestFS(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFn()
+
+	files := map[string][]byte{
+		"a":              fastrand.Bytes(10),
+		"b.txt":          fastrand.Bytes(2*pages.PageSize + 10),
+		"b/c":            nil,
+		"b/d/e":          fastrand.Bytes(100),
+		"b/d/f":          fastrand.Bytes(pages.PageSize),
+		"b/d0":           fastrand.Bytes(1),
+		"dir/sub/file":   fastrand.Bytes(5),
+		"dir/sub2/file2": fastrand.Bytes(5),
+	}
+	var expected []string
+	for name, data := range files {
+		if err := createFile(fsys, name, data); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, name)
+	}
+	if err := fstest.TestFS(fsys, expected...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the data of a file
+	data, err := fs.ReadFile(fsys, "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(files["b.txt"]) {
+		t.Error("data of file doesn't match")
+	}
+
+	// Check the entries of the root
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 4 || names[0] != "a" || names[1] != "b" || names[2] != "b.txt" || names[3] != "dir" {
+		t.Errorf("root has the wrong entries: %v", names)
+	}
+}
+
+// TestLink tests if invalid and conflicting names are rejected
+func TestLink(t *testing.T) {
+	fsys, closeFn, err := newTestFS(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFn()
+
+	if err := createFile(fsys, "a/b", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", ".", "/a", "a/../b", "a/"} {
+		if err := createFile(fsys, name, nil); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("linking %q should fail with %v but failed with %v", name, fs.ErrInvalid, err)
+		}
+	}
+	for _, name := range []string{"a", "a/b/c"} {
+		if err := createFile(fsys, name, nil); !errors.Is(err, errNameConflict) {
+			t.Errorf("linking %q should fail with %v but failed with %v", name, errNameConflict, err)
+		}
+	}
+
+	// Unlinking the file removes the directory
+	if err := Unlink(fsys.names, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err should be %v but was %v", fs.ErrNotExist, err)
+	}
+	if err := Unlink(fsys.names, "a/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err should be %v but was %v", fs.ErrNotExist, err)
+	}
+}
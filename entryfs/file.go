@@ -0,0 +1,134 @@
+package entryfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/HopeThinkLab/pages"
+)
+
+var (
+	// errIsDir is returned when trying to read a directory
+	errIsDir = errors.New("is a directory")
+
+	// errNotDir is returned when trying to read the entries of a file
+	errNotDir = errors.New("not a directory")
+)
+
+type (
+	// file is an open file that reads from an entry
+	file struct {
+		e    *pages.Entry
+		name string
+	}
+
+	// dir is an open directory
+	dir struct {
+		fsys *FS
+		name string
+
+		// entries are the remaining entries of the directory. They are
+		// loaded by the first call to ReadDir.
+		entries []fs.DirEntry
+		loaded  bool
+	}
+
+	// fileInfo describes a file or directory. Entries don't have a
+	// modification time.
+	fileInfo struct {
+		name string
+		size int64
+		dir  bool
+	}
+)
+
+// Name returns the base name of the file
+func (fi fileInfo) Name() string { return path.Base(fi.name) }
+
+// Size returns the size of the file's data
+func (fi fileInfo) Size() int64 { return fi.size }
+
+// Mode returns the read-only mode of the file
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ModTime returns the zero time
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+
+// IsDir returns true for directories
+func (fi fileInfo) IsDir() bool { return fi.dir }
+
+// Sys returns nil
+func (fi fileInfo) Sys() interface{} { return nil }
+
+// Read reads from the entry
+func (f *file) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return f.e.Read(b)
+}
+
+// Seek sets the offset of the next Read
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	return f.e.Seek(offset, whence)
+}
+
+// Stat returns the FileInfo of the file
+func (f *file) Stat() (fs.FileInfo, error) {
+	return fileInfo{name: f.name, size: f.e.Size()}, nil
+}
+
+// Close closes the entry
+func (f *file) Close() error {
+	return f.e.Close()
+}
+
+// Read always fails for directories
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+// Stat returns the FileInfo of the directory
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return fileInfo{name: d.name, dir: true}, nil
+}
+
+// Close does nothing for directories
+func (d *dir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory. If n <= 0 all the
+// remaining entries are returned.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
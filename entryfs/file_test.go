@@ -0,0 +1,84 @@
+package entryfs
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HopeThinkLab/pages"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestFileServer tests if files can be served by http.FileServer including
+// range requests
+func TestFileServer(t *testing.T) {
+	fsys, closeFn, err := newTestFS(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFn()
+
+	data := fastrand.Bytes(3*pages.PageSize + 123)
+	if err := createFile(fsys, "dir/file.bin", data); err != nil {
+		t.Fatal(err)
+	}
+	server := http.FileServer(http.FS(fsys))
+
+	// Request the whole file
+	req := httptest.NewRequest("GET", "/dir/file.bin", nil)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status should be %v but was %v", http.StatusOK, rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Error("served data doesn't match")
+	}
+
+	// Request a range that spans multiple pages
+	req = httptest.NewRequest("GET", "/dir/file.bin", nil)
+	req.Header.Set("Range", "bytes=4000-9000")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status should be %v but was %v", http.StatusPartialContent, rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data[4000:9001]) {
+		t.Error("served range doesn't match")
+	}
+}
+
+// TestReadDirFile tests if the entries of a directory can be read in batches
+func TestReadDirFile(t *testing.T) {
+	fsys, closeFn, err := newTestFS(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFn()
+
+	for _, name := range []string{"d/1", "d/2", "d/3"} {
+		if err := createFile(fsys, name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := fsys.Open("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := f.(*dir)
+	if _, err := d.Read(nil); err == nil {
+		t.Error("reading a directory should fail")
+	}
+	if entries, err := d.ReadDir(2); err != nil || len(entries) != 2 {
+		t.Fatalf("2 entries should be returned but %v were: %v", len(entries), err)
+	}
+	if entries, err := d.ReadDir(2); err != nil || len(entries) != 1 || entries[0].Name() != "3" {
+		t.Fatalf("the last entry should be returned: %v", err)
+	}
+	if _, err := d.ReadDir(2); err != io.EOF {
+		t.Errorf("err should be %v but was %v", io.EOF, err)
+	}
+}
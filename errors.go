@@ -0,0 +1,119 @@
+package pages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrCorrupted is returned if the data read from the file is
+	// inconsistent
+	ErrCorrupted = errors.New("the file is corrupted")
+
+	// ErrInvalidIdentifier is returned if an Identifier can't point to an
+	// entry
+	ErrInvalidIdentifier = errors.New("invalid identifier")
+
+	// ErrNotFound is returned if an Identifier doesn't point to an existing
+	// entry
+	ErrNotFound = errors.New("entry not found")
+
+	// ErrOutOfSpace is returned if no more pages can be allocated
+	ErrOutOfSpace = errors.New("out of space")
+
+	// ErrClosed is returned if the PageManager was already closed
+	ErrClosed = errors.New("the PageManager was closed")
+)
+
+// Error is an error that occurred while accessing a specific page of the
+// file. It usually wraps one of the sentinel errors which can be checked using
+// errors.Is.
+type Error struct {
+	// Err is the underlying error
+	Err error
+
+	// Offset is the offset of the page within the file
+	Offset int64
+
+	// Identifier is the entry that was accessed. It is the zero Identifier if
+	// the page doesn't belong to a known entry.
+	Identifier Identifier
+
+	// detail describes the error in more detail
+	detail string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	s := e.Err.Error()
+	if e.detail != "" {
+		s = e.detail + ": " + s
+	}
+	if e.Identifier != (Identifier{}) {
+		return fmt.Sprintf("%v (offset %v, entry %v)", s, e.Offset, e.Identifier)
+	}
+	return fmt.Sprintf("%v (offset %v)", s, e.Offset)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// pageError creates an Error for the page at offset
+func pageError(err error, offset int64, format string, args ...interface{}) error {
+	return &Error{
+		Err:    err,
+		Offset: offset,
+		detail: fmt.Sprintf(format, args...),
+	}
+}
+
+// ioError converts an error returned by the file while accessing the page at
+// offset into an Error
+func ioError(err error, offset int64) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrClosed):
+		return pageError(ErrClosed, offset, "")
+	case errors.Is(err, syscall.ENOSPC):
+		return pageError(ErrOutOfSpace, offset, "")
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return pageError(ErrCorrupted, offset, "page extends past the end of the file")
+	}
+	return pageError(err, offset, "")
+}
+
+// withIdentifier sets the Identifier of an Error within err if it isn't set
+// yet
+func withIdentifier(err error, id Identifier) error {
+	var e *Error
+	if errors.As(err, &e) && e.Identifier == (Identifier{}) {
+		e.Identifier = id
+	}
+	return err
+}
+
+// extendErr extends an error with a message like build.ExtendErr but keeps it
+// comparable using errors.Is and errors.As
+func extendErr(s string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%v: %w", s, err)
+}
+
+// critical is called when a sanity check fails. Since this indicates a bug
+// rather than an invalid file, it panics in debug builds. Otherwise the error
+// is returned to avoid crashing the whole process.
+func critical(format string, args ...interface{}) error {
+	err := fmt.Errorf("sanity check failed: "+format, args...)
+	if debug {
+		panic(err)
+	}
+	return err
+}
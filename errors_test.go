@@ -0,0 +1,121 @@
+package pages
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestInvalidIdentifier tests if opening the zero Identifier returns
+// ErrInvalidIdentifier
+func TestInvalidIdentifier(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	if _, err := pt.pm.Open(Identifier{}); !errors.Is(err, ErrInvalidIdentifier) {
+		t.Errorf("err should be %v but was %v", ErrInvalidIdentifier, err)
+	}
+}
+
+// TestCorruptedPageTable tests if recovering an entry with a corrupted
+// pageTable returns ErrCorrupted with the offset of the pageTable and the
+// Identifier of the entry
+func TestCorruptedPageTable(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry and close it
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	rootOff := entry.ep.root.pp.fileOff
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the number of entries of the root pageTable
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, numPageEntries+1)
+	if _, err := pt.pm.file.WriteAt(data, rootOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the entry should fail
+	_, err = pt.pm.Open(identifier)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("err should be %v but was %v", ErrCorrupted, err)
+	}
+	var pageErr *Error
+	if !errors.As(err, &pageErr) {
+		t.Fatalf("err should contain an Error: %v", err)
+	}
+	if pageErr.Offset != rootOff || pageErr.Identifier != identifier {
+		t.Errorf("Error should have offset %v and identifier %v but had %v and %v",
+			rootOff, identifier, pageErr.Offset, pageErr.Identifier)
+	}
+
+	// Unmarshaling invalid pageTables shouldn't panic
+	if _, err := unmarshalPageTable(make([]byte, 4), pageSize); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("err should be %v but was %v", ErrCorrupted, err)
+	}
+
+	// Extents of multiple pages can't contain compressed pages
+	extents := make([]byte, 16)
+	binary.LittleEndian.PutUint64(extents, 1|extentTableFlag)
+	binary.LittleEndian.PutUint64(extents[8:], encodeExtent(extent{
+		first: &physicalPage{fileOff: dataOff, storedSize: 100},
+		count: 2,
+	}))
+	if _, err := unmarshalPageTable(extents, pageSize); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("err should be %v but was %v", ErrCorrupted, err)
+	}
+}
+
+// TestErrClosed tests if accessing an entry after its PageManager was closed
+// returns ErrClosed
+func TestErrClosed(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = entry.ReadAt(make([]byte, pageSize), 0)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("err should be %v but was %v", ErrClosed, err)
+	}
+	var pageErr *Error
+	if !errors.As(err, &pageErr) || pageErr.Identifier != identifier {
+		t.Errorf("err should contain the identifier %v: %v", identifier, err)
+	}
+	if _, err := entry.WriteAt(fastrand.Bytes(pageSize), 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+
+	// The size remains valid
+	if entry.Size() != pageSize {
+		t.Errorf("size should be %v but was %v", pageSize, entry.Size())
+	}
+}
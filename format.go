@@ -0,0 +1,190 @@
+package pages
+
+// The on-disk format of a file is versioned. Files of older versions are
+// migrated to the current version 3 when they are opened.
+//
+// Version 1 files were written before the format was versioned and have no
+// format header. The first page is the recyclingPage, a tieredPage whose tree
+// contains the free pages, and the data area starts at the second page.
+// Entries are identified by the offset of their entryPage. The used size and
+// the offset of a tiered entry are varints in 8 byte fields. The root of a
+// tree is stored in the first entry that isn't full yet. A pageTable starts
+// with the number of its entries as a little-endian uint64 followed by a
+// varint per entry. Leaf tables aren't rewritten when pages are truncated
+// and might contain stale entries past the used size.
+//
+// Version 2 stores all metadata using fixed-width little-endian uint64
+// fields. The first page is still the recyclingPage and its tiered entries
+// are followed by the format header at formatHeaderOff. The header contains
+// the formatMagic, the format version and the layout of the file. The pages
+// of the data area start at dataOff and the idTable's entryPage is stored at
+// idTableOff unless the layout says otherwise.
+//
+// Every tieredPage starts with maxTieredEntries entries of 16 bytes. The first
+// 8 bytes of an entry are the used size of the tree and the second 8 bytes
+// are the offset of the pageTable the entry points to. The offset is marked
+// with entryValidFlag if the entry is set and with entryRootFlag if the
+// pageTable is the current root of the tree. If multiple entries are marked
+// as the root, the one with the lowest height is the root.
+//
+// A pageTable starts with the number of its entries which is marked with
+// pageTableFlag. Tables with a height of 0 are also marked with
+// extentTableFlag and store an extent per entry. An extent consists of the
+// offset of its first page, the storedSize of the page at storedSizeShift and
+// the number of its pages minus one at extentCountShift. Other tables store
+// the offsets of their children marked with slotValidFlag.
+//
+// Version 3 uses the header, the tieredPages and the pageTables of version 2
+// but replaces the recyclingPage with an allocation bitmap. The first page is
+// the tieredPage of the bitmap. Every bit of the bitmap's pages represents a
+// page of the data area and is set if the page is allocated.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// formatVersion1 is the version of files that were created before the
+	// format was versioned
+	formatVersion1 = 1
+
+	// formatVersion2 is the version of files that store their metadata using
+	// fixed-width fields
+	formatVersion2 = 2
+
+	// formatVersion3 is the current version of the on-disk format. It keeps
+	// track of free pages using a bitmap.
+	formatVersion3 = 3
+
+	// formatMagic marks the format header of a file
+	formatMagic = 0x746d667365676170
+
+	// formatHeaderOff is the offset of the format header within the first
+	// page of the file
+	formatHeaderOff = maxTieredEntries * tieredPageEntrySize
+
+	// formatHeaderSize is the size of the format header. It contains the
+	// magic, the version and the layout of the file.
+	formatHeaderSize = 4 * 8
+)
+
+var (
+	// errUnsupportedFormat is returned when opening a file that was created
+	// by a newer version of the package
+	errUnsupportedFormat = errors.New("unsupported format version")
+)
+
+type (
+	// formatHeader is the header of a file that contains the version of its
+	// format
+	formatHeader struct {
+		version uint64
+
+		// layout is the layout of the file
+		layout fileLayout
+	}
+
+	// fileLayout contains the indices of the pages that contain the
+	// idTable's entryPage and the start of the data area. Files that were
+	// created with a different layout record it in their format header. Zero
+	// values stand for idTableOff and dataOff.
+	fileLayout struct {
+		idTablePage int64
+		dataPage    int64
+	}
+)
+
+// marshal serializes the formatHeader
+func (h formatHeader) marshal() []byte {
+	data := make([]byte, formatHeaderSize)
+	binary.LittleEndian.PutUint64(data[0:], formatMagic)
+	binary.LittleEndian.PutUint64(data[8:], h.version)
+	binary.LittleEndian.PutUint64(data[16:], uint64(h.layout.idTablePage))
+	binary.LittleEndian.PutUint64(data[24:], uint64(h.layout.dataPage))
+	return data
+}
+
+// unmarshalFormatHeader deserializes a formatHeader. Files without a header
+// use version 1.
+func unmarshalFormatHeader(data []byte) (formatHeader, error) {
+	if binary.LittleEndian.Uint64(data[0:]) != formatMagic {
+		for _, b := range data {
+			if b != 0 {
+				return formatHeader{}, fmt.Errorf("%w: invalid format header", ErrCorrupted)
+			}
+		}
+		return formatHeader{version: formatVersion1}, nil
+	}
+	h := formatHeader{
+		version: binary.LittleEndian.Uint64(data[8:]),
+		layout: fileLayout{
+			idTablePage: int64(binary.LittleEndian.Uint64(data[16:])),
+			dataPage:    int64(binary.LittleEndian.Uint64(data[24:])),
+		},
+	}
+	if h.version == 0 || h.version > formatVersion3 {
+		return formatHeader{}, fmt.Errorf("%w: %v", errUnsupportedFormat, h.version)
+	}
+	for _, page := range []int64{h.layout.idTablePage, h.layout.dataPage} {
+		if page < 0 || page >= maxFileSize/pageSize {
+			return formatHeader{}, fmt.Errorf("%w: invalid file layout", ErrCorrupted)
+		}
+	}
+	return h, nil
+}
+
+// readFormatHeader reads the format header from the first page of the file
+func readFormatHeader(f *pageFile) (formatHeader, error) {
+	pp := &physicalPage{
+		file:     f,
+		fileOff:  freeOff,
+		usedSize: pageSize,
+	}
+	data := make([]byte, formatHeaderSize)
+	if _, err := pp.readAt(data, formatHeaderOff); err != nil {
+		return formatHeader{}, err
+	}
+	h, err := unmarshalFormatHeader(data)
+	if err != nil {
+		return formatHeader{}, pageError(err, freeOff, "failed to read format header")
+	}
+	return h, nil
+}
+
+// writeFormatHeader writes the format header to the first page of the file
+func writeFormatHeader(f *pageFile, h formatHeader) error {
+	pp := &physicalPage{
+		file:     f,
+		fileOff:  freeOff,
+		usedSize: pageSize,
+	}
+	_, err := pp.writeAt(h.marshal(), formatHeaderOff)
+	return err
+}
+
+// marshalTieredPageEntry serializes an entry of a tieredPage. Set entries are
+// marked with entryValidFlag.
+func marshalTieredPageEntry(usedBytes int64, pageOff int64) []byte {
+	data := make([]byte, tieredPageEntrySize)
+	binary.LittleEndian.PutUint64(data[0:8], uint64(usedBytes))
+	binary.LittleEndian.PutUint64(data[8:], uint64(pageOff)|entryValidFlag)
+	return data
+}
+
+// unmarshalTieredPageEntry deserializes an entry of a tieredPage. The
+// returned pageOff still contains the entryRootFlag. Entries that are not set
+// are returned as invalid.
+func unmarshalTieredPageEntry(data []byte) (usedBytes int64, pageOff int64, valid bool, err error) {
+	usedBytes = int64(binary.LittleEndian.Uint64(data[0:8]))
+	off := binary.LittleEndian.Uint64(data[8:])
+	if off&entryValidFlag == 0 {
+		return 0, 0, false, nil
+	}
+	pageOff = int64(off &^ entryValidFlag)
+	if usedBytes < 0 || pageOff&^entryRootFlag >= maxFileSize {
+		return 0, 0, false, fmt.Errorf("%w: invalid tiered entry", ErrCorrupted)
+	}
+	return usedBytes, pageOff, true, nil
+}
@@ -0,0 +1,255 @@
+package pages
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden test vectors")
+
+// goldenFile contains the encoding of the golden test vectors
+var goldenFile = filepath.Join("testdata", "format_v2.golden")
+
+// goldenVectors returns the encodings of the golden test vectors
+func goldenVectors() (map[string][]byte, error) {
+	leaf := pageTable{
+		extents: []extent{
+			{first: &physicalPage{fileOff: 2 * pageSize}, count: 3},
+			{first: &physicalPage{fileOff: 9 * pageSize, storedSize: 0x123}, count: 1},
+			{first: &physicalPage{fileOff: 0}, count: maxExtentPages},
+		},
+	}
+	internal := pageTable{
+		height: 1,
+		childTables: map[uint64]*pageTable{
+			0: {pp: &physicalPage{fileOff: 0}},
+			1: {pp: &physicalPage{fileOff: 4 * pageSize}},
+		},
+	}
+	leafData, err := leaf.marshal()
+	if err != nil {
+		return nil, err
+	}
+	internalData, err := internal.marshal()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"tiered_entry":      marshalTieredPageEntry(3*pageSize+10, 5*pageSize),
+		"tiered_entry_root": marshalTieredPageEntry(1<<60, (maxFileSize-pageSize)|entryRootFlag),
+		"tiered_entry_zero": marshalTieredPageEntry(0, 0),
+		"leaf_table":        leafData,
+		"internal_table":    internalData,
+		"format_header":     formatHeader{version: formatVersion3}.marshal(),
+		"format_header_layout": formatHeader{
+			version: formatVersion3,
+			layout:  fileLayout{idTablePage: 7, dataPage: 1},
+		}.marshal(),
+	}, nil
+}
+
+// readGoldenFile reads the golden test vectors from disk
+func readGoldenFile() (map[string][]byte, error) {
+	f, err := os.Open(goldenFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vectors := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		if vectors[fields[0]], err = hex.DecodeString(fields[1]); err != nil {
+			return nil, err
+		}
+	}
+	return vectors, scanner.Err()
+}
+
+// writeGoldenFile writes the golden test vectors to disk
+func writeGoldenFile(vectors map[string][]byte) error {
+	var names []string
+	for name := range vectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%v %x\n", name, vectors[name])
+	}
+	return os.WriteFile(goldenFile, buf.Bytes(), 0644)
+}
+
+// TestGoldenVectors tests if the encoding of the on-disk structures matches
+// the checked in test vectors. Run the test with -update to update them.
+func TestGoldenVectors(t *testing.T) {
+	vectors, err := goldenVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateGolden {
+		if err := writeGoldenFile(vectors); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := readGoldenFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(golden) != len(vectors) {
+		t.Fatalf("there should be %v vectors but there were %v", len(vectors), len(golden))
+	}
+	for name, data := range vectors {
+		if !bytes.Equal(data, golden[name]) {
+			t.Errorf("%v should be %x but was %x", name, golden[name], data)
+		}
+	}
+
+	// Decode the vectors
+	usedBytes, pageOff, valid, err := unmarshalTieredPageEntry(golden["tiered_entry_zero"])
+	if err != nil || !valid || usedBytes != 0 || pageOff != 0 {
+		t.Errorf("zero entry wasn't decoded correctly: %v %v %v %v", usedBytes, pageOff, valid, err)
+	}
+	usedBytes, pageOff, valid, err = unmarshalTieredPageEntry(golden["tiered_entry_root"])
+	if err != nil || !valid || usedBytes != 1<<60 || pageOff != (maxFileSize-pageSize)|entryRootFlag {
+		t.Errorf("root entry wasn't decoded correctly: %v %v %v %v", usedBytes, pageOff, valid, err)
+	}
+	if _, _, valid, err := unmarshalTieredPageEntry(make([]byte, tieredPageEntrySize)); err != nil || valid {
+		t.Errorf("unset entry should be invalid: %v", err)
+	}
+	entries, err := unmarshalPageTable(golden["leaf_table"], pageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3+1+maxExtentPages {
+		t.Fatalf("there should be %v entries but there were %v", 3+1+maxExtentPages, len(entries))
+	}
+	if entries[2] != 4*pageSize || entries[3] != encodeSlot(9*pageSize, 0x123) || entries[4] != 0 {
+		t.Errorf("wrong entries %v", entries[:5])
+	}
+	entries, err = unmarshalPageTable(golden["internal_table"], pageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != 0 || entries[1] != 4*pageSize {
+		t.Errorf("wrong entries %v", entries)
+	}
+	h, err := unmarshalFormatHeader(golden["format_header_layout"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.layout.idTablePage != 7 || h.layout.dataPage != 1 {
+		t.Errorf("wrong layout %+v", h.layout)
+	}
+}
+
+// TestFormatHeader tests if a new file has a format header and if files of a
+// newer version are rejected
+func TestFormatHeader(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := readFormatHeader(pm.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.version != formatVersion3 {
+		t.Fatalf("wrong header %+v", h)
+	}
+	if err := writeFormatHeader(pm.file, formatHeader{version: formatVersion3 + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path); !errors.Is(err, errUnsupportedFormat) {
+		t.Errorf("err should be %v but was %v", errUnsupportedFormat, err)
+	}
+}
+
+// TestFileLayout tests if the layout recorded in the format header is used to
+// find the idTable
+func TestFileLayout(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stats := pm.Stats()
+
+	// Move the idTable's entryPage into the data area
+	data := make([]byte, pageSize)
+	if _, err := pm.ids.pp.readAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	pp, err := pm.managedAllocatePage(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pp.writeAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.ids.pp.writeAt(make([]byte, pageSize), 0); err != nil {
+		t.Fatal(err)
+	}
+	h := formatHeader{
+		version: formatVersion3,
+		layout:  fileLayout{idTablePage: pp.fileOff / pageSize},
+	}
+	if err := writeFormatHeader(pm.file, h); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry should still be found and the entryPage of the idTable should
+	// be counted as metadata
+	pm, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if pm.ids.pp.fileOff != pp.fileOff {
+		t.Fatalf("idTable should be at %v but was at %v", pp.fileOff, pm.ids.pp.fileOff)
+	}
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	s := pm.Stats()
+	if s.FilePages != stats.FilePages+1 || s.MetadataPages != stats.MetadataPages+1 || s.TablePages != stats.TablePages {
+		t.Errorf("wrong stats %+v", s)
+	}
+}
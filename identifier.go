@@ -0,0 +1,69 @@
+package pages
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// Identifier is a helper type that can be used to reopen a previously created
+// entry. It consists of the entry's index within the idTable followed by a
+// random nonce that is stored in the entryPage. The nonce prevents stale
+// identifiers from opening pages that were reused for other data.
+type Identifier [16]byte
+
+const (
+	// legacyIndex is the index of Identifiers that were created using
+	// LegacyIdentifier. Their nonce is the offset of the entryPage.
+	legacyIndex = math.MaxUint64
+)
+
+// newIdentifier creates the Identifier for an entry
+func newIdentifier(index uint64, nonce uint64) (id Identifier) {
+	binary.LittleEndian.PutUint64(id[:8], index)
+	binary.LittleEndian.PutUint64(id[8:], nonce)
+	return
+}
+
+// index returns the index of the entry within the idTable
+func (id Identifier) index() uint64 {
+	return binary.LittleEndian.Uint64(id[:8])
+}
+
+// nonce returns the nonce of the entryPage the Identifier points to
+func (id Identifier) nonce() uint64 {
+	return binary.LittleEndian.Uint64(id[8:])
+}
+
+// String returns the hex encoding of the Identifier
+func (id Identifier) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// newEntryNonce creates a random nonce for a new entryPage
+func newEntryNonce() uint64 {
+	return binary.LittleEndian.Uint64(fastrand.Bytes(8))
+}
+
+// readEntryHeader reads the tag and nonce of an entryPage
+func readEntryHeader(pp *physicalPage) (tag uint64, nonce uint64, err error) {
+	data := make([]byte, entryHeaderSize)
+	if _, err = pp.readAt(data, entryHeaderOff); err != nil {
+		return
+	}
+	tag = binary.LittleEndian.Uint64(data[:8])
+	nonce = binary.LittleEndian.Uint64(data[8:])
+	return
+}
+
+// writeEntryHeader marks a page as the entryPage of the entry with the given
+// nonce
+func writeEntryHeader(pp *physicalPage, nonce uint64) error {
+	data := make([]byte, entryHeaderSize)
+	binary.LittleEndian.PutUint64(data[:8], entryPageTag)
+	binary.LittleEndian.PutUint64(data[8:], nonce)
+	_, err := pp.writeAt(data, entryHeaderOff)
+	return err
+}
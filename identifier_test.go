@@ -0,0 +1,85 @@
+package pages
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestIdentifier tests if the index and nonce of an Identifier can be
+// recovered
+func TestIdentifier(t *testing.T) {
+	index := fastrand.Uint64n(1 << 40)
+	nonce := newEntryNonce()
+	id := newIdentifier(index, nonce)
+	if id.index() != index || id.nonce() != nonce {
+		t.Errorf("Identifier should contain index %v and nonce %v but contained %v and %v",
+			index, nonce, id.index(), id.nonce())
+	}
+}
+
+// TestOpenNotFound tests if opening identifiers which don't point to an
+// entryPage fails with ErrNotFound
+func TestOpenNotFound(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Create an entry and free some of its pages
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	dataOff := entry.ep.pages[0].fileOff
+	freeOff := entry.ep.pages[9].fileOff
+	if err := entry.Truncate(5 * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	rootOff := entry.ep.root.pp.fileOff
+	entryOff := entry.ep.pp.fileOff
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Identifiers that aren't in the idTable or have the wrong nonce
+	index, nonce := identifier.index(), identifier.nonce()
+	ids := map[string]Identifier{
+		"unused index": newIdentifier(index+1, nonce),
+		"large index":  newIdentifier(index+100*idsPerPage, nonce),
+		"wrong nonce":  newIdentifier(index, nonce+1),
+	}
+	for name, id := range ids {
+		if _, err := pt.pm.Open(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Opening %v should fail with %v but was %v", name, ErrNotFound, err)
+		}
+	}
+
+	// Point the idTable to pages that aren't entryPages
+	offsets := map[string]int64{
+		"data page": dataOff,
+		"free page": freeOff,
+		"pageTable": rootOff,
+	}
+	for name, off := range offsets {
+		if err := pt.pm.ids.set(index, off); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pt.pm.Open(identifier); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Opening %v should fail with %v but was %v", name, ErrNotFound, err)
+		}
+	}
+
+	// Restoring the offset should make the entry accessible again
+	if err := pt.pm.ids.set(index, entryOff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pt.pm.Open(identifier); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,180 @@
+package pages
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+)
+
+type (
+	// idTable maps the index of an Identifier to the offset of the entry's
+	// entryPage. Every page of the table stores idsPerPage
+	// offsets. An offset of 0 means that the index is unused. The
+	// PageManager's mu needs to be acquired to access the table.
+	idTable struct {
+		// idTable is a tieredPage
+		*tieredPage
+
+		// offsets caches the offsets of the entryPages of all the entries
+		offsets []int64
+	}
+)
+
+// newIDTable creates the idTable of a new PageManager
+func newIDTable(pm *PageManager) (*idTable, error) {
+	root, err := newPageTable(0, nil, pm)
+	if err != nil {
+		return nil, extendErr("Failed to create pageTable for idTable", err)
+	}
+	it := &idTable{
+		&tieredPage{
+			pm:   pm,
+			root: root,
+			mu:   new(sync.RWMutex),
+			pp: &physicalPage{
+				file:     pm.file,
+				fileOff:  pm.file.idTableOff(),
+				usedSize: pageSize,
+			},
+		},
+		[]int64{0},
+	}
+
+	// Write the root of the idTable to disk
+	if err := writeTieredPageRoot(it.pp, 0, 0, root.pp.fileOff); err != nil {
+		return nil, extendErr("Failed to initialize idTable", err)
+	}
+	return it, nil
+}
+
+// loadIDTable loads the idTable of an existing PageManager from disk
+func loadIDTable(pm *PageManager) (*idTable, error) {
+	pp := &physicalPage{
+		file:     pm.file,
+		fileOff:  pm.file.idTableOff(),
+		usedSize: pageSize,
+	}
+	usedSize, rootOff, height, err := readTieredPageRoot(pp)
+	if err != nil {
+		return nil, extendErr("Failed to read idTable entry", err)
+	}
+	it := &idTable{
+		&tieredPage{
+			pp:       pp,
+			usedSize: usedSize,
+			pm:       pm,
+			mu:       new(sync.RWMutex),
+		},
+		nil,
+	}
+	if err := it.recoverTree(context.Background(), rootOff, height); err != nil {
+		return nil, extendErr("Failed to recover idTable", err)
+	}
+
+	// Read the offsets of all the entryPages
+	data := make([]byte, pageSize)
+	for _, page := range it.pages {
+		if _, err := page.readAt(data, 0); err != nil {
+			return nil, extendErr("Failed to read idTable page", err)
+		}
+		for i := 0; i < idsPerPage; i++ {
+			it.offsets = append(it.offsets, int64(binary.LittleEndian.Uint64(data[i*8:])))
+		}
+	}
+
+	// Unused indices at the end of the table can be reused. Index 0 is never
+	// used.
+	for len(it.offsets) > 1 && it.offsets[len(it.offsets)-1] == 0 {
+		it.offsets = it.offsets[:len(it.offsets)-1]
+	}
+	if len(it.offsets) == 0 {
+		it.offsets = []int64{0}
+	}
+	return it, nil
+}
+
+// lookup returns the offset of the entryPage for an index
+func (it *idTable) lookup(index uint64) (int64, bool) {
+	if index >= uint64(len(it.offsets)) || it.offsets[index] == 0 {
+		return 0, false
+	}
+	return it.offsets[index], true
+}
+
+// add adds the offset of a new entryPage to the table and returns its index
+func (it *idTable) add(fileOff int64) (uint64, error) {
+	index := uint64(len(it.offsets))
+	it.offsets = append(it.offsets, 0)
+	if err := it.set(index, fileOff); err != nil {
+		it.offsets = it.offsets[:index]
+		return 0, err
+	}
+	return index, nil
+}
+
+// set sets the offset of the entryPage for an index and writes it to disk
+func (it *idTable) set(index uint64, fileOff int64) error {
+	// Add pages to the table until it contains the index
+	for uint64(len(it.pages))*idsPerPage <= index {
+		if err := it.addPage(); err != nil {
+			return extendErr("failed to extend idTable", err)
+		}
+	}
+
+	// Write the offset
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(fileOff))
+	if _, err := it.pages[index/idsPerPage].writeAt(data, int64(index%idsPerPage)*8); err != nil {
+		return err
+	}
+	it.offsets[index] = fileOff
+	return nil
+}
+
+// addPage adds an empty page to the table
+func (it *idTable) addPage() error {
+	page, err := it.pm.allocatePage()
+	if err != nil {
+		return err
+	}
+
+	// Recycled pages might still contain old data
+	if _, err := page.writeAt(make([]byte, pageSize), 0); err != nil {
+		return err
+	}
+
+	// Insert the page into the tree
+	height := it.root.height
+	if err := it.insertPage(it.nextIndex(), page); err != nil {
+		return extendErr("failed to insert page", err)
+	}
+	it.pages = append(it.pages, page)
+
+	// Increment the usedSize and write the root
+	it.usedSize += pageSize
+	return it.writeRoot(height)
+}
+
+// readTieredPageRoot reads the entries of a tieredPage and returns the
+// usedSize, the offset and the height of the tree's root. If none of the
+// entries is set, errEntriesV1 is returned.
+func readTieredPageRoot(pp *physicalPage) (usedSize, rootOff, height int64, err error) {
+	var anyValid bool
+	for i := int64(0); i < maxTieredEntries; i++ {
+		var valid bool
+		usedSize, rootOff, valid, err = readEntryPageEntry(pp, i)
+		if err != nil {
+			return
+		}
+		anyValid = anyValid || valid
+
+		// Stop at the lowest entry that is marked as the root
+		if valid && rootOff&entryRootFlag != 0 {
+			return usedSize, rootOff &^ entryRootFlag, i, nil
+		}
+	}
+	if !anyValid {
+		return 0, 0, 0, pageError(errEntriesV1, pp.fileOff, "tieredPage has no root")
+	}
+	return 0, 0, 0, pageError(ErrCorrupted, pp.fileOff, "tieredPage has no root")
+}
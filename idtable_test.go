@@ -0,0 +1,61 @@
+package pages
+
+import (
+	"testing"
+)
+
+// TestIDTable tests if the idTable grows correctly and can be recovered from
+// disk
+func TestIDTable(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create enough entries to require multiple pages
+	numEntries := 2*idsPerPage + 10
+	ids := make([]Identifier, numEntries)
+	offsets := make([]int64, numEntries)
+	for i := range ids {
+		entry, id, err := pt.pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+		offsets[i] = entry.ep.pp.fileOff
+		if id.index() != uint64(i+1) {
+			t.Fatalf("index of entry %v should be %v but was %v", i, i+1, id.index())
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pt.pm.ids.pages) != 3 {
+		t.Errorf("idTable should have 3 pages but had %v", len(pt.pm.ids.pages))
+	}
+
+	// Recover the PageManager and compare the table
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(pt.pm.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	for i, id := range ids {
+		if off, exists := pm.ids.lookup(id.index()); !exists || off != offsets[i] {
+			t.Fatalf("offset of entry %v should be %v but was %v", i, offsets[i], off)
+		}
+	}
+	if len(pm.ids.offsets) != numEntries+1 {
+		t.Errorf("idTable should contain %v offsets but contained %v", numEntries+1, len(pm.ids.offsets))
+	}
+
+	// New entries should get the next index
+	if _, id, err := pm.Create(); err != nil {
+		t.Fatal(err)
+	} else if id.index() != uint64(numEntries+1) {
+		t.Errorf("index should be %v but was %v", numEntries+1, id.index())
+	}
+}
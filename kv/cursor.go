@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"bytes"
+)
+
+// Cursor iterates over a range of keys of a Store in ascending order. It
+// reads a single leaf at a time. Changes to the Store that happen during the
+// iteration might not be visible to the Cursor.
+type Cursor struct {
+	// s is the Store the keys are read from
+	s *Store
+
+	// next is the smallest key that wasn't returned yet and end is the
+	// first key that is not part of the range. A nil end means that the
+	// range is unbounded.
+	next []byte
+	end  []byte
+
+	// keys and values are the remaining records of the current leaf
+	keys   [][]byte
+	values []value
+
+	// key and value are the current record
+	key   []byte
+	value value
+
+	// done indicates that there are no more leaves to read
+	done bool
+
+	// err is the error that stopped the Cursor
+	err error
+}
+
+// Range returns a Cursor that iterates over the keys between start and end.
+// start is inclusive and end is exclusive. A nil start or end leaves the
+// range unbounded.
+func (s *Store) Range(start, end []byte) *Cursor {
+	return &Cursor{
+		s:    s,
+		next: append([]byte{}, start...),
+		end:  end,
+	}
+}
+
+// loadLeaf loads the records of the leaf that contains the smallest key
+// greater than or equal to c.next
+func (c *Cursor) loadLeaf() {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+	for {
+		// Find the leaf and remember the smallest key of the next subtree
+		var bound []byte
+		n, err := c.s.readNode(c.s.meta.root)
+		for err == nil && !n.leaf {
+			i := n.child(c.next)
+			if i < len(n.keys) {
+				bound = n.keys[i]
+			}
+			n, err = c.s.readNode(n.children[i])
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		// Keep the records that weren't returned yet
+		i, _ := n.search(c.next)
+		if i < len(n.keys) {
+			c.keys = n.keys[i:]
+			c.values = n.values[i:]
+			return
+		}
+
+		// Continue with the next leaf if the leaf doesn't contain such
+		// records
+		if bound == nil {
+			c.done = true
+			return
+		}
+		c.next = bound
+	}
+}
+
+// Next moves the Cursor to the next key. It returns false if there are no
+// more keys in the range or if reading a leaf failed.
+func (c *Cursor) Next() bool {
+	if c.err != nil || c.done {
+		return false
+	}
+	if len(c.keys) == 0 {
+		c.loadLeaf()
+		if c.err != nil || c.done {
+			return false
+		}
+	}
+	if c.end != nil && bytes.Compare(c.keys[0], c.end) >= 0 {
+		c.done = true
+		return false
+	}
+	c.key, c.value = c.keys[0], c.values[0]
+	c.keys, c.values = c.keys[1:], c.values[1:]
+
+	// The smallest key after the current key is the key followed by a 0
+	c.next = append(append([]byte{}, c.key...), 0)
+	return true
+}
+
+// Key returns the current key
+func (c *Cursor) Key() []byte {
+	return c.key
+}
+
+// Value reads the value of the current key
+func (c *Cursor) Value() ([]byte, error) {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+	return c.s.readValue(c.value)
+}
+
+// Err returns the error that stopped the Cursor. It is nil if the Cursor
+// reached the end of the range.
+func (c *Cursor) Err() error {
+	return c.err
+}
@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestCursor tests if a Cursor returns the keys of a range in order
+func TestCursor(t *testing.T) {
+	pm, s, _, err := newTestStore(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	defer s.Close()
+
+	// An empty Store doesn't contain any keys
+	if c := s.Range(nil, nil); c.Next() || c.Err() != nil {
+		t.Fatalf("cursor shouldn't return any keys: %v", c.Err())
+	}
+
+	// Insert keys and delete a large range of them to create empty leaves
+	numKeys := 3000
+	for _, i := range fastrand.Perm(numKeys) {
+		if err := s.Put(testKey(i), testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1000; i < 2000; i++ {
+		if err := s.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// checkRange checks if the cursor returns the keys from start to end
+	// skipping the deleted ones
+	checkRange := func(c *Cursor, start, end int) {
+		i := start
+		for ; c.Next(); i++ {
+			if i == 1000 {
+				i = 2000
+			}
+			if !bytes.Equal(c.Key(), testKey(i)) {
+				t.Fatalf("key should be %s but was %s", testKey(i), c.Key())
+			}
+			if value, err := c.Value(); err != nil || !bytes.Equal(value, testKey(i)) {
+				t.Fatalf("value of key %s doesn't match: %v", testKey(i), err)
+			}
+		}
+		if c.Err() != nil {
+			t.Fatal(c.Err())
+		}
+		if i != end {
+			t.Fatalf("cursor should stop at %v but stopped at %v", end, i)
+		}
+	}
+	checkRange(s.Range(nil, nil), 0, numKeys)
+	checkRange(s.Range(testKey(500), testKey(2500)), 500, 2500)
+	checkRange(s.Range(testKey(1500), nil), 2000, numKeys)
+	checkRange(s.Range([]byte("key-0005"), testKey(600)), 500, 600)
+}
@@ -0,0 +1,145 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/HopeThinkLab/pages"
+)
+
+type (
+	// journalRecord contains all the changes of a transaction. It is
+	// appended to the journal before the changes are applied to the tree.
+	// If the Store crashes while applying the changes, they are applied
+	// again when the Store is opened.
+	journalRecord struct {
+		// nodes are the new contents of the modified nodes sorted by their
+		// ids
+		nodes []journalNode
+
+		// deletes are the entries of large values that are no longer
+		// referenced by the tree
+		deletes []pages.Identifier
+	}
+
+	// journalNode is the content of a single node
+	journalNode struct {
+		id   uint64
+		data []byte
+	}
+)
+
+// marshal encodes the record. It starts with the 4 byte number of nodes and
+// the 4 byte number of deletes followed by the nodes and the deletes. Every
+// node is stored as its 8 byte id followed by its data.
+func (r journalRecord) marshal() []byte {
+	data := make([]byte, 8, 8+len(r.nodes)*(8+nodeSize)+len(r.deletes)*len(pages.Identifier{}))
+	binary.LittleEndian.PutUint32(data[:4], uint32(len(r.nodes)))
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(r.deletes)))
+	buf := make([]byte, 8)
+	for _, n := range r.nodes {
+		binary.LittleEndian.PutUint64(buf, n.id)
+		data = append(data, buf...)
+		data = append(data, n.data...)
+	}
+	for _, id := range r.deletes {
+		data = append(data, id[:]...)
+	}
+	return data
+}
+
+// unmarshalJournalRecord decodes a record
+func unmarshalJournalRecord(data []byte) (journalRecord, error) {
+	var r journalRecord
+	if len(data) < 8 {
+		return r, errors.New("journal record is too short")
+	}
+	numNodes := int(binary.LittleEndian.Uint32(data[:4]))
+	numDeletes := int(binary.LittleEndian.Uint32(data[4:8]))
+	idSize := len(pages.Identifier{})
+	if len(data) != 8+numNodes*(8+nodeSize)+numDeletes*idSize {
+		return r, fmt.Errorf("journal record has wrong size %v", len(data))
+	}
+	data = data[8:]
+	for i := 0; i < numNodes; i++ {
+		r.nodes = append(r.nodes, journalNode{
+			id:   binary.LittleEndian.Uint64(data),
+			data: data[8 : 8+nodeSize],
+		})
+		data = data[8+nodeSize:]
+	}
+	for i := 0; i < numDeletes; i++ {
+		var id pages.Identifier
+		copy(id[:], data[:idSize])
+		r.deletes = append(r.deletes, id)
+		data = data[idSize:]
+	}
+	return r, nil
+}
+
+// commit makes the changes of a record durable. The record is appended to
+// the journal before the nodes are written to the tree. Once the changes are
+// applied, the journal is cleared again.
+func (s *Store) commit(r journalRecord) error {
+	sort.Slice(r.nodes, func(i, j int) bool {
+		return r.nodes[i].id < r.nodes[j].id
+	})
+	if _, err := s.journal.Append(r.marshal()); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	if err := s.journalEntry.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	if err := s.apply(r); err != nil {
+		return err
+	}
+	return s.journal.Truncate(0)
+}
+
+// apply writes the nodes of a record to their pages and the meta node to the
+// Store's entry and deletes the entries that are no longer needed. Applying a
+// record multiple times has the same effect as applying it once.
+func (s *Store) apply(r journalRecord) error {
+	for _, n := range r.nodes {
+		var err error
+		if n.id == metaID {
+			_, err = s.tree.WriteAt(n.data, 0)
+		} else {
+			err = s.pm.WritePage(int64(n.id), n.data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write node %v: %w", n.id, err)
+		}
+	}
+	if err := s.tree.Sync(); err != nil {
+		return fmt.Errorf("failed to sync tree: %w", err)
+	}
+	for _, id := range r.deletes {
+		if err := s.pm.Delete(id); err != nil && !errors.Is(err, pages.ErrNotFound) {
+			return fmt.Errorf("failed to delete value %v: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// replayJournal applies the records that are left in the journal after a
+// crash. Records that weren't appended completely are discarded when the
+// journal is loaded.
+func (s *Store) replayJournal() error {
+	it := s.journal.Iterator(0)
+	for it.Next() {
+		r, err := unmarshalJournalRecord(it.Record())
+		if err != nil {
+			return fmt.Errorf("%w: %v", pages.ErrCorrupted, err)
+		}
+		if err := s.apply(r); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return s.journal.Truncate(0)
+}
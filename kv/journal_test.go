@@ -0,0 +1,93 @@
+package kv
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/HopeThinkLab/pages"
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestMarshalJournalRecord tests if journal records can be encoded and decoded
+func TestMarshalJournalRecord(t *testing.T) {
+	var id pages.Identifier
+	fastrand.Read(id[:])
+	r := journalRecord{
+		nodes: []journalNode{
+			{id: 1, data: fastrand.Bytes(nodeSize)},
+			{id: 5, data: fastrand.Bytes(nodeSize)},
+		},
+		deletes: []pages.Identifier{id},
+	}
+	decoded, err := unmarshalJournalRecord(r.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, decoded) {
+		t.Error("decoded record doesn't match")
+	}
+	if _, err := unmarshalJournalRecord(r.marshal()[:100]); err == nil {
+		t.Error("decoding a truncated record should fail")
+	}
+}
+
+// TestJournalRecovery tests if changes that were appended to the journal but
+// not applied to the tree are applied when the Store is opened
+func TestJournalRecovery(t *testing.T) {
+	pm, s, id, err := newTestStore(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put([]byte("a"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash after appending a transaction to the journal
+	tx := s.newTx()
+	if err := tx.put([]byte("a"), value{data: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.put([]byte("b"), value{data: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := tx.record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.journal.Append(r.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := s.Get([]byte("a")); err != nil || !bytes.Equal(value, []byte("old")) {
+		t.Fatalf("value shouldn't be changed yet: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the Store should apply the changes and clear the journal
+	pm, err = pages.New(filepath.Join(build.TempDir("kv", t.Name()), "data.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	s, err = Open(pm, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.journal.Size() != 0 {
+		t.Errorf("journal should be empty but had %v bytes", s.journal.Size())
+	}
+	if value, err := s.Get([]byte("a")); err != nil || !bytes.Equal(value, []byte("new")) {
+		t.Errorf("value should be replaced: %v", err)
+	}
+	if value, err := s.Get([]byte("b")); err != nil || !bytes.Equal(value, []byte("b")) {
+		t.Errorf("value should be inserted: %v", err)
+	}
+}
@@ -0,0 +1,553 @@
+// Package kv implements an ordered key-value store on top of a PageManager.
+// The keys are stored in a B+tree whose nodes are pages that are allocated
+// from the PageManager. Values that are too large to be stored within the tree
+// are stored in their own entries. All changes are written to a journal first
+// which makes the Store crash safe.
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/HopeThinkLab/pages"
+)
+
+const (
+	// metaMagic identifies the meta node of a Store
+	metaMagic = 0x3165726f7473766b // "kvstore1"
+
+	// metaID is the id of the meta node which is stored in the Store's
+	// entry. It can't be confused with the other nodes since 0 is never the
+	// offset of a page.
+	metaID = 0
+
+	// journalAttr is the attribute of the tree's entry that contains the
+	// Identifier of the journal
+	journalAttr = "kv.journal"
+)
+
+var (
+	// ErrKeyNotFound is returned if a key doesn't exist in the Store
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrKeyTooLarge is returned if a key is larger than MaxKeySize
+	ErrKeyTooLarge = errors.New("key exceeds MaxKeySize")
+
+	// errEmptyKey is returned when trying to store a value without a key
+	errEmptyKey = errors.New("key can't be empty")
+)
+
+type (
+	// Store is an ordered key-value store
+	Store struct {
+		// pm is the PageManager that stores the Store's entries
+		pm *pages.PageManager
+
+		// tree is the entry that identifies the Store. It contains the meta
+		// node and the Identifier of the journal.
+		tree *pages.Entry
+
+		// journal contains the changes that weren't applied to the tree
+		// yet. journalEntry is the entry that stores it.
+		journal      *pages.LogEntry
+		journalEntry *pages.Entry
+
+		// meta is the content of the meta node
+		meta meta
+
+		// mu protects the tree. Modifications need a write lock.
+		mu *sync.RWMutex
+	}
+
+	// meta is the content of the meta node
+	meta struct {
+		// root is the id of the root node
+		root uint64
+	}
+
+	// tx collects the changes of a single modification of the tree
+	tx struct {
+		s    *Store
+		meta meta
+
+		// nodes are the nodes that were modified
+		nodes map[uint64]*node
+
+		// allocated are the pages that were allocated for new nodes. They
+		// are freed again if the transaction fails before it is committed.
+		allocated []uint64
+
+		// freed are the nodes that were removed from the tree. Their pages
+		// are freed after the transaction was committed.
+		freed map[uint64]struct{}
+
+		// deletes are the entries of large values that were replaced or
+		// deleted
+		deletes []pages.Identifier
+	}
+)
+
+// marshal encodes the meta node
+func (m meta) marshal() []byte {
+	data := make([]byte, nodeSize)
+	binary.LittleEndian.PutUint64(data[0:], metaMagic)
+	binary.LittleEndian.PutUint64(data[8:], m.root)
+	return data
+}
+
+// unmarshalMeta decodes the meta node
+func unmarshalMeta(data []byte) (meta, error) {
+	if binary.LittleEndian.Uint64(data[0:]) != metaMagic {
+		return meta{}, fmt.Errorf("%w: entry doesn't contain a Store", pages.ErrCorrupted)
+	}
+	return meta{
+		root: binary.LittleEndian.Uint64(data[8:]),
+	}, nil
+}
+
+// Create creates a new Store. The returned Identifier can be used to open the
+// Store again.
+func Create(pm *pages.PageManager) (*Store, pages.Identifier, error) {
+	tree, id, err := pm.Create()
+	if err != nil {
+		return nil, pages.Identifier{}, err
+	}
+	journalEntry, journalID, err := pm.Create()
+	if err != nil {
+		tree.Close()
+		return nil, pages.Identifier{}, err
+	}
+	if err := tree.SetAttr(journalAttr, journalID[:]); err != nil {
+		tree.Close()
+		journalEntry.Close()
+		return nil, pages.Identifier{}, err
+	}
+	s, err := newStore(pm, tree, journalEntry)
+	if err != nil {
+		return nil, pages.Identifier{}, err
+	}
+
+	// The tree starts with an empty leaf as the root
+	t := s.newTx()
+	root, err := t.allocate()
+	if err != nil {
+		t.abort()
+		s.Close()
+		return nil, pages.Identifier{}, err
+	}
+	t.meta = meta{root: root}
+	t.nodes[root] = &node{id: root, leaf: true}
+	if err := t.commit(); err != nil {
+		s.Close()
+		return nil, pages.Identifier{}, err
+	}
+	return s, id, nil
+}
+
+// Open opens an existing Store. Changes that were interrupted by a crash are
+// completed.
+func Open(pm *pages.PageManager, id pages.Identifier) (*Store, error) {
+	tree, err := pm.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := tree.GetAttr(journalAttr)
+	if err != nil {
+		tree.Close()
+		return nil, fmt.Errorf("failed to get journal of Store: %w", err)
+	}
+	var journalID pages.Identifier
+	copy(journalID[:], b)
+	journalEntry, err := pm.Open(journalID)
+	if err != nil {
+		tree.Close()
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	s, err := newStore(pm, tree, journalEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the changes that are left in the journal and load the meta node
+	if err := s.replayJournal(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	data := make([]byte, nodeSize)
+	if _, err := s.tree.ReadAt(data, int64(metaID)*nodeSize); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to read meta node: %w", err)
+	}
+	if s.meta, err = unmarshalMeta(data); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newStore creates a Store from its entries
+func newStore(pm *pages.PageManager, tree, journalEntry *pages.Entry) (*Store, error) {
+	journal, err := pages.NewLogEntry(journalEntry)
+	if err != nil {
+		tree.Close()
+		journalEntry.Close()
+		return nil, fmt.Errorf("failed to load journal: %w", err)
+	}
+	return &Store{
+		pm:           pm,
+		tree:         tree,
+		journal:      journal,
+		journalEntry: journalEntry,
+		mu:           new(sync.RWMutex),
+	}, nil
+}
+
+// Close closes the entries of the Store
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tree.Close()
+	if err2 := s.journalEntry.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// checkKey checks if a key can be stored
+func checkKey(key []byte) error {
+	if len(key) == 0 {
+		return errEmptyKey
+	}
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// readNode reads a node of the tree
+func (s *Store) readNode(id uint64) (*node, error) {
+	if id == metaID {
+		return nil, fmt.Errorf("%w: node %v doesn't exist", pages.ErrCorrupted, id)
+	}
+	data := make([]byte, nodeSize)
+	if err := s.pm.ReadPage(int64(id), data); err != nil {
+		return nil, fmt.Errorf("failed to read node %v: %w", id, err)
+	}
+	return unmarshalNode(id, data)
+}
+
+// readValue returns the data of a value
+func (s *Store) readValue(v value) ([]byte, error) {
+	if !v.large {
+		return append([]byte{}, v.data...), nil
+	}
+	e, err := s.pm.Open(v.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open value: %w", err)
+	}
+	defer e.Close()
+	data := make([]byte, e.Size())
+	if _, err := e.ReadAt(data, 0); err != nil {
+		return nil, fmt.Errorf("failed to read value: %w", err)
+	}
+	return data, nil
+}
+
+// Get returns the value of a key
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Find the leaf that might contain the key
+	n, err := s.readNode(s.meta.root)
+	for err == nil && !n.leaf {
+		n, err = s.readNode(n.children[n.child(key)])
+	}
+	if err != nil {
+		return nil, err
+	}
+	i, found := n.search(key)
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return s.readValue(n.values[i])
+}
+
+// Put sets the value of a key. Values that are too large to be stored within
+// the tree are stored in their own entry.
+func (s *Store) Put(key, data []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	// Store large values in their own entry. If the Store crashes before the
+	// change is committed, the entry is leaked.
+	v := value{data: append([]byte{}, data...)}
+	if len(data) > maxInlineValueSize {
+		e, id, err := s.pm.Create()
+		if err != nil {
+			return err
+		}
+		_, err = e.WriteAt(data, 0)
+		if err2 := e.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			s.pm.Delete(id)
+			return fmt.Errorf("failed to write value: %w", err)
+		}
+		v = value{large: true, id: id}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.newTx()
+	if err := t.put(key, v); err != nil {
+		t.abort()
+		if v.large {
+			s.pm.Delete(v.id)
+		}
+		return err
+	}
+	return t.commit()
+}
+
+// Delete removes a key from the Store. Nodes are only freed once they are
+// empty. Nodes that are less than half full aren't merged with their
+// neighbours, so a Store whose keys were mostly deleted might use more pages
+// than necessary.
+func (s *Store) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.newTx()
+	if err := t.delete(key); err != nil {
+		t.abort()
+		return err
+	}
+	return t.commit()
+}
+
+// newTx creates a new transaction
+func (s *Store) newTx() *tx {
+	return &tx{
+		s:     s,
+		meta:  s.meta,
+		nodes: make(map[uint64]*node),
+		freed: make(map[uint64]struct{}),
+	}
+}
+
+// node returns a node of the tree including the changes of the transaction
+func (t *tx) node(id uint64) (*node, error) {
+	if n, ok := t.nodes[id]; ok {
+		return n, nil
+	}
+	if _, ok := t.freed[id]; ok {
+		return nil, fmt.Errorf("%w: node %v was freed", pages.ErrCorrupted, id)
+	}
+	return t.s.readNode(id)
+}
+
+// allocate allocates a page for a new node and returns its id. If the Store
+// crashes before the transaction is committed, the page is leaked.
+func (t *tx) allocate() (uint64, error) {
+	off, err := t.s.pm.AllocatePage()
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate node: %w", err)
+	}
+	t.allocated = append(t.allocated, uint64(off))
+	return uint64(off), nil
+}
+
+// free removes a node from the tree. Its page is freed once the transaction
+// was committed.
+func (t *tx) free(id uint64) {
+	delete(t.nodes, id)
+	t.freed[id] = struct{}{}
+}
+
+// abort frees the pages that were allocated by a transaction that won't be
+// committed
+func (t *tx) abort() {
+	for _, id := range t.allocated {
+		_ = t.s.pm.FreePage(int64(id))
+	}
+	t.allocated = nil
+}
+
+// put inserts or replaces a key
+func (t *tx) put(key []byte, v value) error {
+	right, splitKey, err := t.insert(t.meta.root, key, v)
+	if err != nil || right == nil {
+		return err
+	}
+
+	// The root was split and a new root is needed
+	id, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	t.nodes[id] = &node{
+		id:       id,
+		keys:     [][]byte{splitKey},
+		children: []uint64{t.meta.root, right.id},
+	}
+	t.meta.root = id
+	return nil
+}
+
+// insert inserts a key into the subtree of a node. If the node needs to be
+// split, the new node and the first key of its subtree are returned.
+func (t *tx) insert(id uint64, key []byte, v value) (*node, []byte, error) {
+	n, err := t.node(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n.leaf {
+		i, found := n.search(key)
+		if found {
+			if n.values[i].large {
+				t.deletes = append(t.deletes, n.values[i].id)
+			}
+			n.values[i] = v
+		} else {
+			n.keys = append(n.keys[:i], append([][]byte{append([]byte{}, key...)}, n.keys[i:]...)...)
+			n.values = append(n.values[:i], append([]value{v}, n.values[i:]...)...)
+		}
+	} else {
+		i := n.child(key)
+		right, splitKey, err := t.insert(n.children[i], key, v)
+		if err != nil || right == nil {
+			return nil, nil, err
+		}
+		n.keys = append(n.keys[:i], append([][]byte{splitKey}, n.keys[i:]...)...)
+		n.children = append(n.children[:i+1], append([]uint64{right.id}, n.children[i+1:]...)...)
+	}
+	t.nodes[id] = n
+	if n.size() <= nodeSize {
+		return nil, nil, nil
+	}
+
+	// Split the node
+	rightID, err := t.allocate()
+	if err != nil {
+		return nil, nil, err
+	}
+	right, splitKey := n.split(rightID)
+	t.nodes[rightID] = right
+	return right, splitKey, nil
+}
+
+// delete removes a key. Nodes that become empty are freed and the height of
+// the tree shrinks if the root only has a single child. Nodes are never
+// merged.
+func (t *tx) delete(key []byte) error {
+	found, _, err := t.remove(t.meta.root, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	for {
+		root, err := t.node(t.meta.root)
+		if err != nil {
+			return err
+		}
+		if root.leaf || len(root.children) > 1 {
+			return nil
+		}
+		if len(root.children) == 0 {
+			// All the leaves were removed
+			t.nodes[root.id] = &node{id: root.id, leaf: true}
+			return nil
+		}
+		t.free(root.id)
+		t.meta.root = root.children[0]
+	}
+}
+
+// remove removes a key from the subtree of a node. It returns whether the key
+// was found and whether the node was freed because it became empty.
+func (t *tx) remove(id uint64, key []byte) (bool, bool, error) {
+	n, err := t.node(id)
+	if err != nil {
+		return false, false, err
+	}
+	if n.leaf {
+		i, found := n.search(key)
+		if !found {
+			return false, false, nil
+		}
+		if n.values[i].large {
+			t.deletes = append(t.deletes, n.values[i].id)
+		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+	} else {
+		i := n.child(key)
+		found, freed, err := t.remove(n.children[i], key)
+		if err != nil || !freed {
+			return found, false, err
+		}
+
+		// Remove the freed child and the key that separates it from its
+		// neighbour
+		if len(n.keys) > 0 {
+			k := i - 1
+			if i == 0 {
+				k = 0
+			}
+			n.keys = append(n.keys[:k], n.keys[k+1:]...)
+		}
+		n.children = append(n.children[:i], n.children[i+1:]...)
+	}
+
+	// Free empty nodes except for the root
+	if id != t.meta.root && len(n.keys) == 0 && (n.leaf || len(n.children) == 0) {
+		t.free(id)
+		return true, true, nil
+	}
+	t.nodes[id] = n
+	return true, false, nil
+}
+
+// record returns the journalRecord that contains the changes of the
+// transaction
+func (t *tx) record() (journalRecord, error) {
+	r := journalRecord{deletes: t.deletes}
+	for id, n := range t.nodes {
+		data, err := n.marshal()
+		if err != nil {
+			return journalRecord{}, err
+		}
+		r.nodes = append(r.nodes, journalNode{id: id, data: data})
+	}
+	r.nodes = append(r.nodes, journalNode{id: metaID, data: t.meta.marshal()})
+	return r, nil
+}
+
+// commit writes the changes of the transaction to disk and applies them to
+// the Store. The pages of the freed nodes are freed afterwards. They are only
+// freed once the journal was cleared, since replaying the journal after they
+// were reused would overwrite other data. If the Store crashes before, they
+// are leaked.
+func (t *tx) commit() error {
+	r, err := t.record()
+	if err != nil {
+		t.abort()
+		return err
+	}
+	if err := t.s.commit(r); err != nil {
+		return err
+	}
+	t.s.meta = t.meta
+	for id := range t.freed {
+		if err := t.s.pm.FreePage(int64(id)); err != nil {
+			return fmt.Errorf("failed to free node %v: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HopeThinkLab/pages"
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// newTestStore is a helper function that creates a PageManager and a Store
+// for testing
+func newTestStore(name string) (*pages.PageManager, *Store, pages.Identifier, error) {
+	testdir := build.TempDir("kv", name)
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		return nil, nil, pages.Identifier{}, err
+	}
+	pm, err := pages.New(filepath.Join(testdir, "data.dat"))
+	if err != nil {
+		return nil, nil, pages.Identifier{}, err
+	}
+	s, id, err := Create(pm)
+	if err != nil {
+		pm.Close()
+		return nil, nil, pages.Identifier{}, err
+	}
+	return pm, s, id, nil
+}
+
+// testKey returns the key with index i
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%06d", i))
+}
+
+// TestStore tests if values can be stored, replaced and deleted and if they
+// are still available after recovering the Store
+func TestStore(t *testing.T) {
+	pm, s, id, err := newTestStore(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert enough keys in random order for the tree to grow multiple
+	// levels
+	numKeys := 5000
+	values := make(map[string][]byte)
+	for _, i := range fastrand.Perm(numKeys) {
+		value := fastrand.Bytes(fastrand.Intn(200))
+		if err := s.Put(testKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+		values[string(testKey(i))] = value
+	}
+	root, err := s.readNode(s.meta.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.leaf {
+		t.Fatal("root shouldn't be a leaf")
+	}
+
+	// Replace every third value and delete every other key
+	for i := 0; i < numKeys; i += 3 {
+		value := fastrand.Bytes(fastrand.Intn(100))
+		if err := s.Put(testKey(i), value); err != nil {
+			t.Fatal(err)
+		}
+		values[string(testKey(i))] = value
+	}
+	for i := 0; i < numKeys; i += 2 {
+		if err := s.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+		delete(values, string(testKey(i)))
+	}
+	if err := s.Delete(testKey(0)); err != ErrKeyNotFound {
+		t.Errorf("err should be %v but was %v", ErrKeyNotFound, err)
+	}
+
+	// checkValues checks if the Store contains the expected values
+	checkValues := func(s *Store) {
+		for i := 0; i < numKeys; i++ {
+			value, err := s.Get(testKey(i))
+			expected, exists := values[string(testKey(i))]
+			if !exists {
+				if err != ErrKeyNotFound {
+					t.Fatalf("err for key %v should be %v but was %v", i, ErrKeyNotFound, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(value, expected) {
+				t.Fatalf("value of key %v doesn't match", i)
+			}
+		}
+	}
+	checkValues(s)
+
+	// Recover the Store
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = pages.New(filepath.Join(build.TempDir("kv", t.Name()), "data.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	s, err = Open(pm, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkValues(s)
+}
+
+// TestStoreKeys tests if invalid keys are rejected
+func TestStoreKeys(t *testing.T) {
+	pm, s, _, err := newTestStore(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	defer s.Close()
+
+	if err := s.Put(nil, []byte{1}); err != errEmptyKey {
+		t.Errorf("err should be %v but was %v", errEmptyKey, err)
+	}
+	if err := s.Put(make([]byte, MaxKeySize+1), []byte{1}); err != ErrKeyTooLarge {
+		t.Errorf("err should be %v but was %v", ErrKeyTooLarge, err)
+	}
+
+	// The largest keys and inline values should fit
+	for i := 0; i < 100; i++ {
+		key := append(testKey(i), make([]byte, MaxKeySize-len(testKey(i)))...)
+		if err := s.Put(key, fastrand.Bytes(maxInlineValueSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Get(testKey(0)); err != ErrKeyNotFound {
+		t.Errorf("err should be %v but was %v", ErrKeyNotFound, err)
+	}
+}
+
+// TestStoreLargeValues tests if large values are stored in their own entries
+// and if those entries are deleted with the value
+func TestStoreLargeValues(t *testing.T) {
+	pm, s, _, err := newTestStore(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	defer s.Close()
+
+	value := fastrand.Bytes(3*pages.PageSize + 100)
+	if err := s.Put([]byte("large"), value); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get([]byte("large")); err != nil || !bytes.Equal(data, value) {
+		t.Fatalf("large value doesn't match: %v", err)
+	}
+	leaf, err := s.readNode(s.meta.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leaf.values[0].large {
+		t.Fatal("value should be stored in an entry")
+	}
+	valueID := leaf.values[0].id
+
+	// Replacing the value with a small one should delete the entry
+	if err := s.Put([]byte("large"), []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.Open(valueID); !errors.Is(err, pages.ErrNotFound) {
+		t.Errorf("err should be %v but was %v", pages.ErrNotFound, err)
+	}
+
+	// Deleting a large value should delete the entry
+	if err := s.Put([]byte("large"), value); err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err = s.readNode(s.meta.root); err != nil {
+		t.Fatal(err)
+	}
+	valueID = leaf.values[0].id
+	if err := s.Delete([]byte("large")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.Open(valueID); !errors.Is(err, pages.ErrNotFound) {
+		t.Errorf("err should be %v but was %v", pages.ErrNotFound, err)
+	}
+}
+
+// TestStoreFreeNodes tests if the nodes of deleted keys are reused
+func TestStoreFreeNodes(t *testing.T) {
+	pm, s, _, err := newTestStore(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	defer s.Close()
+
+	numKeys := 2000
+	for i := 0; i < numKeys; i++ {
+		if err := s.Put(testKey(i), fastrand.Bytes(100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filePages := pm.Stats().FilePages
+	freePages := pm.Stats().FreePages
+
+	// Delete all the keys. Only the root should be left.
+	for i := 0; i < numKeys; i++ {
+		if err := s.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := s.readNode(s.meta.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !root.leaf || len(root.keys) != 0 {
+		t.Fatalf("root should be an empty leaf but had %v keys", len(root.keys))
+	}
+
+	// The pages of the removed nodes are returned to the PageManager
+	if stats := pm.Stats(); stats.FreePages <= freePages {
+		t.Fatalf("there should be more than %v free pages but there were %v", freePages, stats.FreePages)
+	}
+
+	// Inserting the keys again reuses the freed pages
+	for i := 0; i < numKeys; i++ {
+		if err := s.Put(testKey(i), fastrand.Bytes(100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := pm.Stats(); stats.FilePages != filePages {
+		t.Errorf("file should have %v pages but had %v", filePages, stats.FilePages)
+	}
+}
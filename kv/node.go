@@ -0,0 +1,295 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/HopeThinkLab/pages"
+)
+
+const (
+	// nodeSize is the size of a node on disk. Every node occupies a single
+	// page that is allocated from the PageManager.
+	nodeSize = pages.PageSize
+
+	// nodeHeaderSize is the size of a node's header. It contains the 1 byte
+	// type of the node followed by the 2 byte number of keys.
+	nodeHeaderSize = 3
+
+	// MaxKeySize is the maximum size of a key
+	MaxKeySize = 512
+
+	// maxInlineValueSize is the maximum size of a value that is stored
+	// within a leaf. Larger values are stored in their own entry.
+	maxInlineValueSize = 512
+)
+
+const (
+	// nodeTypeLeaf marks a node that contains keys and their values. The
+	// types start at 1 to not mistake a zeroed page for a node.
+	nodeTypeLeaf = iota + 1
+
+	// nodeTypeInternal marks a node that contains keys and child nodes
+	nodeTypeInternal
+)
+
+const (
+	// valueInline marks a value that is stored within the leaf
+	valueInline = iota
+
+	// valueEntry marks a value that is stored in its own entry
+	valueEntry
+)
+
+var (
+	// errCorruptedNode is returned if a node can't be decoded
+	errCorruptedNode = fmt.Errorf("%w: node can't be decoded", pages.ErrCorrupted)
+)
+
+type (
+	// node is the decoded form of a node of the tree. Leaves contain keys
+	// and values. Internal nodes contain len(keys)+1 children. All the keys
+	// in children[i] are smaller than keys[i] and all the keys in
+	// children[i+1] are greater than or equal to keys[i].
+	node struct {
+		// id is the offset of the page that stores the node
+		id uint64
+
+		// leaf indicates if the node is a leaf or an internal node
+		leaf bool
+
+		// keys are the sorted keys of the node
+		keys [][]byte
+
+		// values are the values of a leaf
+		values []value
+
+		// children are the children of an internal node
+		children []uint64
+	}
+
+	// value is the value of a key. Small values are stored inline and large
+	// ones in an entry.
+	value struct {
+		// data is the data of an inline value
+		data []byte
+
+		// large indicates that the value is stored in the entry with the
+		// Identifier id
+		large bool
+		id    pages.Identifier
+	}
+)
+
+// size returns the number of bytes the value needs within a leaf
+func (v value) size() int {
+	if v.large {
+		return 1 + len(v.id)
+	}
+	return 1 + uvarintSize(len(v.data)) + len(v.data)
+}
+
+// uvarintSize returns the size of the uvarint encoding of n
+func uvarintSize(n int) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, uint64(n))
+}
+
+// size returns the number of bytes the encoded node requires
+func (n *node) size() int {
+	size := nodeHeaderSize
+	if !n.leaf {
+		size += 8 * len(n.children)
+	}
+	for i, key := range n.keys {
+		size += uvarintSize(len(key)) + len(key)
+		if n.leaf {
+			size += n.values[i].size()
+		}
+	}
+	return size
+}
+
+// search returns the index of the first key that is greater than or equal to
+// key and if the key at that index equals key
+func (n *node) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// child returns the index of the child that might contain key
+func (n *node) child(key []byte) int {
+	i, found := n.search(key)
+	if found {
+		i++
+	}
+	return i
+}
+
+// marshal encodes the node into a nodeSize buffer
+func (n *node) marshal() ([]byte, error) {
+	if n.size() > nodeSize {
+		return nil, fmt.Errorf("node %v requires %v bytes", n.id, n.size())
+	}
+	data := make([]byte, nodeHeaderSize, nodeSize)
+	data[0] = nodeTypeInternal
+	if n.leaf {
+		data[0] = nodeTypeLeaf
+	}
+	binary.LittleEndian.PutUint16(data[1:], uint16(len(n.keys)))
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	if !n.leaf {
+		data = append(data, buf[:8]...)
+		binary.LittleEndian.PutUint64(data[len(data)-8:], n.children[0])
+	}
+	for i, key := range n.keys {
+		data = append(data, buf[:binary.PutUvarint(buf, uint64(len(key)))]...)
+		data = append(data, key...)
+		if !n.leaf {
+			binary.LittleEndian.PutUint64(buf, n.children[i+1])
+			data = append(data, buf[:8]...)
+			continue
+		}
+		v := n.values[i]
+		if v.large {
+			data = append(data, valueEntry)
+			data = append(data, v.id[:]...)
+			continue
+		}
+		data = append(data, valueInline)
+		data = append(data, buf[:binary.PutUvarint(buf, uint64(len(v.data)))]...)
+		data = append(data, v.data...)
+	}
+	return data[:nodeSize], nil
+}
+
+// unmarshalNode decodes a node
+func unmarshalNode(id uint64, data []byte) (*node, error) {
+	if len(data) < nodeHeaderSize {
+		return nil, errCorruptedNode
+	}
+	n := &node{id: id}
+	switch data[0] {
+	case nodeTypeLeaf:
+		n.leaf = true
+	case nodeTypeInternal:
+	default:
+		return nil, fmt.Errorf("%w: node %v has type %v", errCorruptedNode, id, data[0])
+	}
+	numKeys := int(binary.LittleEndian.Uint16(data[1:]))
+	data = data[nodeHeaderSize:]
+
+	// readUvarint reads a length from the data
+	readUvarint := func() (int, bool) {
+		length, read := binary.Uvarint(data)
+		if read <= 0 || length > uint64(len(data)-read) {
+			return 0, false
+		}
+		data = data[read:]
+		return int(length), true
+	}
+	// readUint64 reads a child from the data
+	readUint64 := func() (uint64, bool) {
+		if len(data) < 8 {
+			return 0, false
+		}
+		child := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		return child, true
+	}
+
+	if !n.leaf {
+		child, ok := readUint64()
+		if !ok {
+			return nil, errCorruptedNode
+		}
+		n.children = append(n.children, child)
+	}
+	for i := 0; i < numKeys; i++ {
+		length, ok := readUvarint()
+		if !ok {
+			return nil, errCorruptedNode
+		}
+		n.keys = append(n.keys, append([]byte{}, data[:length]...))
+		data = data[length:]
+
+		if !n.leaf {
+			child, ok := readUint64()
+			if !ok {
+				return nil, errCorruptedNode
+			}
+			n.children = append(n.children, child)
+			continue
+		}
+		if len(data) == 0 {
+			return nil, errCorruptedNode
+		}
+		var v value
+		switch data[0] {
+		case valueInline:
+			data = data[1:]
+			length, ok := readUvarint()
+			if !ok {
+				return nil, errCorruptedNode
+			}
+			v.data = append([]byte{}, data[:length]...)
+			data = data[length:]
+		case valueEntry:
+			if len(data) < 1+len(v.id) {
+				return nil, errCorruptedNode
+			}
+			v.large = true
+			copy(v.id[:], data[1:])
+			data = data[1+len(v.id):]
+		default:
+			return nil, errCorruptedNode
+		}
+		n.values = append(n.values, v)
+	}
+	return n, nil
+}
+
+// split moves the upper half of the node's keys into a new node with the
+// given id. It returns the new node and the first key of the new node's
+// subtree.
+func (n *node) split(id uint64) (*node, []byte) {
+	// Find the index at which half the node's bytes are used
+	half := n.size() / 2
+	i, size := 0, nodeHeaderSize
+	for ; i < len(n.keys)-1; i++ {
+		size += uvarintSize(len(n.keys[i])) + len(n.keys[i])
+		if n.leaf {
+			size += n.values[i].size()
+		} else {
+			size += 8
+		}
+		if size >= half {
+			break
+		}
+	}
+	if i == 0 {
+		i = 1
+	}
+
+	right := &node{id: id, leaf: n.leaf}
+	if n.leaf {
+		right.keys = append(right.keys, n.keys[i:]...)
+		right.values = append(right.values, n.values[i:]...)
+		n.keys = n.keys[:i:i]
+		n.values = n.values[:i:i]
+		return right, right.keys[0]
+	}
+
+	// The key at i moves up to the parent
+	splitKey := n.keys[i]
+	right.keys = append(right.keys, n.keys[i+1:]...)
+	right.children = append(right.children, n.children[i+1:]...)
+	n.keys = n.keys[:i:i]
+	n.children = n.children[: i+1 : i+1]
+	return right, splitKey
+}
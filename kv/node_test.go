@@ -0,0 +1,93 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/HopeThinkLab/pages"
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestMarshalNode tests if nodes can be encoded and decoded
+func TestMarshalNode(t *testing.T) {
+	var id pages.Identifier
+	fastrand.Read(id[:])
+	leaf := &node{
+		id:   3,
+		leaf: true,
+		keys: [][]byte{[]byte("a"), []byte("b"), []byte("c")},
+		values: []value{
+			{data: fastrand.Bytes(100)},
+			{large: true, id: id},
+			{data: []byte{}},
+		},
+	}
+	internal := &node{
+		id:       4,
+		keys:     [][]byte{[]byte("b"), []byte("d")},
+		children: []uint64{1, 2, 3},
+	}
+	for _, n := range []*node{leaf, internal} {
+		data, err := n.marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != nodeSize {
+			t.Fatalf("encoded node should have %v bytes but had %v", nodeSize, len(data))
+		}
+		decoded, err := unmarshalNode(n.id, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(n, decoded) {
+			t.Errorf("decoded node doesn't match: %v != %v", n, decoded)
+		}
+	}
+
+	// Zeroed pages and garbage can't be decoded
+	if _, err := unmarshalNode(1, make([]byte, nodeSize)); !errors.Is(err, pages.ErrCorrupted) {
+		t.Errorf("err should be %v but was %v", pages.ErrCorrupted, err)
+	}
+	data := make([]byte, nodeSize)
+	data[0] = nodeTypeLeaf
+	data[1] = 10
+	copy(data[3:], bytes.Repeat([]byte{0xff}, 10))
+	if _, err := unmarshalNode(1, data); !errors.Is(err, pages.ErrCorrupted) {
+		t.Errorf("err should be %v but was %v", pages.ErrCorrupted, err)
+	}
+}
+
+// TestSplitNode tests if splitting a node distributes its keys correctly
+func TestSplitNode(t *testing.T) {
+	leaf := &node{id: 1, leaf: true}
+	internal := &node{id: 2, children: []uint64{100}}
+	for i := 0; i < 10; i++ {
+		leaf.keys = append(leaf.keys, testKey(i))
+		leaf.values = append(leaf.values, value{data: []byte{byte(i)}})
+		internal.keys = append(internal.keys, testKey(i))
+		internal.children = append(internal.children, uint64(101+i))
+	}
+
+	// The first key of the right leaf is copied into the parent
+	right, splitKey := leaf.split(3)
+	if len(leaf.keys)+len(right.keys) != 10 || len(leaf.keys) == 0 || len(right.keys) == 0 {
+		t.Fatalf("keys weren't distributed correctly: %v/%v", len(leaf.keys), len(right.keys))
+	}
+	if !bytes.Equal(splitKey, right.keys[0]) || len(right.values) != len(right.keys) {
+		t.Error("right leaf is invalid")
+	}
+
+	// The split key of an internal node moves into the parent
+	right, splitKey = internal.split(4)
+	if len(internal.keys)+len(right.keys) != 9 {
+		t.Fatalf("keys weren't distributed correctly: %v/%v", len(internal.keys), len(right.keys))
+	}
+	if len(internal.children) != len(internal.keys)+1 || len(right.children) != len(right.keys)+1 {
+		t.Fatal("internal nodes have the wrong number of children")
+	}
+	if !bytes.Equal(splitKey, testKey(len(internal.keys))) || right.children[0] != internal.children[len(internal.children)-1]+1 {
+		t.Error("split key doesn't separate the children")
+	}
+}
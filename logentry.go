@@ -0,0 +1,219 @@
+package pages
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
+)
+
+const (
+	// recordHeaderSize is the size of the header of a record. It contains the
+	// 4 byte length of the record followed by its 4 byte checksum.
+	recordHeaderSize = 8
+)
+
+var (
+	// errRecordTooLarge is returned when trying to append a record whose
+	// length doesn't fit into its header
+	errRecordTooLarge = errors.New("record is too large")
+
+	// recordTable is the table used to compute the checksums of records
+	recordTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type (
+	// LogEntry is an append-only log of records stored in an Entry. Every
+	// record is framed by its length and a checksum which makes it possible
+	// to detect and discard a torn record at the end of the log after a
+	// crash.
+	LogEntry struct {
+		// entry is the Entry that stores the records
+		entry *Entry
+
+		// size is the offset at which the next record is appended. Records
+		// beyond size are not visible to readers yet.
+		size int64
+
+		// mu serializes appends and protects size
+		mu *sync.Mutex
+	}
+
+	// LogIterator reads the records of a LogEntry in order
+	LogIterator struct {
+		// l is the LogEntry the records are read from
+		l *LogEntry
+
+		// off is the offset of the next record
+		off int64
+
+		// recordOff is the offset of the current record
+		recordOff int64
+
+		// record is the current record
+		record []byte
+
+		// err is the error that stopped the iterator
+		err error
+
+		// torn indicates that err was caused by a torn record at the end of
+		// the log. A record is torn if it is incomplete or if it fails the
+		// checksum and ends at the end of the log.
+		torn bool
+	}
+)
+
+// recordChecksum computes the checksum of a record and its length
+func recordChecksum(length []byte, record []byte) uint32 {
+	h := crc32.New(recordTable)
+	h.Write(length)
+	h.Write(record)
+	return h.Sum32()
+}
+
+// NewLogEntry creates a LogEntry using an Entry. The records of the entry are
+// checked and a torn record at the end of the entry is truncated. Other
+// corrupted records and read errors are returned. The Entry shouldn't be
+// modified directly afterwards.
+func NewLogEntry(e *Entry) (*LogEntry, error) {
+	l := &LogEntry{
+		entry: e,
+		size:  e.Size(),
+		mu:    new(sync.Mutex),
+	}
+
+	// Find the end of the last complete record
+	it := l.Iterator(0)
+	end := int64(0)
+	for it.Next() {
+		end = it.off
+	}
+	if it.err != nil && !it.torn {
+		return nil, extendErr("failed to read record", it.err)
+	}
+	if end < l.size {
+		if err := e.Truncate(end); err != nil {
+			return nil, extendErr("failed to truncate torn record", err)
+		}
+		l.size = end
+	}
+	return l, nil
+}
+
+// Append appends a record to the log and returns its offset. The offset can
+// be used to start iterating at the record.
+func (l *LogEntry) Append(record []byte) (int64, error) {
+	if uint64(len(record)) > math.MaxUint32 {
+		return 0, errRecordTooLarge
+	}
+
+	// Frame the record
+	frame := make([]byte, recordHeaderSize+len(record))
+	binary.LittleEndian.PutUint32(frame[:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(frame[4:8], recordChecksum(frame[:4], record))
+	copy(frame[recordHeaderSize:], record)
+
+	// Write it to the end of the log
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	off := l.size
+	if _, err := l.entry.WriteAt(frame, off); err != nil {
+		return 0, err
+	}
+	l.size += int64(len(frame))
+	return off, nil
+}
+
+// Truncate discards all records starting at off. off needs to be the offset
+// of a record or the size of the log.
+func (l *LogEntry) Truncate(off int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if off < 0 || off > l.size {
+		return errors.New("can't truncate log beyond its end")
+	}
+	if err := l.entry.Truncate(off); err != nil {
+		return err
+	}
+	l.size = off
+	return nil
+}
+
+// Size returns the size of the log in bytes
+func (l *LogEntry) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// Iterator returns an iterator that reads the records of the log starting
+// with the record at off
+func (l *LogEntry) Iterator(off int64) *LogIterator {
+	return &LogIterator{
+		l:   l,
+		off: off,
+	}
+}
+
+// Next reads the next record. It returns false if there are no more records
+// or if reading the record failed.
+func (it *LogIterator) Next() bool {
+	if it.err != nil || it.off >= it.l.Size() {
+		return false
+	}
+
+	// Read the header
+	if it.off+recordHeaderSize > it.l.Size() {
+		it.err = io.ErrUnexpectedEOF
+		it.torn = true
+		return false
+	}
+	header := make([]byte, recordHeaderSize)
+	if _, err := it.l.entry.ReadAt(header, it.off); err != nil {
+		it.err = err
+		return false
+	}
+	length := int64(binary.LittleEndian.Uint32(header[:4]))
+	end := it.off + recordHeaderSize + length
+	if end > it.l.Size() {
+		it.err = io.ErrUnexpectedEOF
+		it.torn = true
+		return false
+	}
+
+	// Read the record and check it
+	record := make([]byte, length)
+	if length > 0 {
+		if _, err := it.l.entry.ReadAt(record, it.off+recordHeaderSize); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	if recordChecksum(header[:4], record) != binary.LittleEndian.Uint32(header[4:]) {
+		it.err = pageError(ErrCorrupted, 0, "record at offset %v has an invalid checksum", it.off)
+		it.torn = end == it.l.Size()
+		return false
+	}
+	it.record = record
+	it.recordOff = it.off
+	it.off = end
+	return true
+}
+
+// Record returns the current record
+func (it *LogIterator) Record() []byte {
+	return it.record
+}
+
+// Offset returns the offset of the current record
+func (it *LogIterator) Offset() int64 {
+	return it.recordOff
+}
+
+// Err returns the error that stopped the iterator. It is nil if the iterator
+// reached the end of the log.
+func (it *LogIterator) Err() error {
+	return it.err
+}
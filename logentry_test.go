@@ -0,0 +1,239 @@
+package pages
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestLogEntry tests if records can be appended to a LogEntry and read again
+func TestLogEntry(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLogEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Append records of different sizes including empty ones and ones that
+	// span multiple pages
+	var records [][]byte
+	var offsets []int64
+	for i := 0; i < 20; i++ {
+		record := fastrand.Bytes(fastrand.Intn(3 * pageSize))
+		off, err := l.Append(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+		offsets = append(offsets, off)
+	}
+	if _, err := l.Append(nil); err != nil {
+		t.Fatal(err)
+	}
+	records = append(records, []byte{})
+
+	// Iterate over all the records
+	it := l.Iterator(0)
+	i := 0
+	for ; it.Next(); i++ {
+		if !bytes.Equal(it.Record(), records[i]) {
+			t.Fatalf("record %v doesn't match", i)
+		}
+		if i < len(offsets) && it.Offset() != offsets[i] {
+			t.Fatalf("offset of record %v should be %v but was %v", i, offsets[i], it.Offset())
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(records) {
+		t.Fatalf("there should be %v records but there were %v", len(records), i)
+	}
+
+	// Start iterating in the middle of the log
+	it = l.Iterator(offsets[10])
+	for i = 10; it.Next(); i++ {
+		if !bytes.Equal(it.Record(), records[i]) {
+			t.Fatalf("record %v doesn't match", i)
+		}
+	}
+	if i != len(records) || it.Err() != nil {
+		t.Fatalf("iterator should return %v records but returned %v: %v", len(records), i, it.Err())
+	}
+}
+
+// TestLogEntryRecovery tests if torn or corrupted records at the end of a log
+// are discarded when it is loaded again
+func TestLogEntryRecovery(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLogEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records [][]byte
+	for i := 0; i < 5; i++ {
+		record := fastrand.Bytes(1000)
+		if _, err := l.Append(record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	size := l.Size()
+
+	// checkRecords checks if the log contains the expected records
+	checkRecords := func(l *LogEntry) {
+		if l.Size() != size {
+			t.Fatalf("size should be %v but was %v", size, l.Size())
+		}
+		it := l.Iterator(0)
+		i := 0
+		for ; it.Next(); i++ {
+			if !bytes.Equal(it.Record(), records[i]) {
+				t.Fatalf("record %v doesn't match", i)
+			}
+		}
+		if i != len(records) || it.Err() != nil {
+			t.Fatalf("log should contain %v records but contained %v: %v", len(records), i, it.Err())
+		}
+	}
+
+	// Simulate a torn write by writing only part of a record
+	off, err := l.Append(fastrand.Bytes(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(off + 500); err != nil {
+		t.Fatal(err)
+	}
+	l, err = NewLogEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(l)
+
+	// Corrupt the data of the last record
+	off, err = l.Append(fastrand.Bytes(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := entry.ReadAt(b, off+recordHeaderSize+999); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.WriteAt([]byte{^b[0]}, off+recordHeaderSize+999); err != nil {
+		t.Fatal(err)
+	}
+	it := l.Iterator(off)
+	if it.Next() || !errors.Is(it.Err(), ErrCorrupted) {
+		t.Errorf("iterator should fail with %v but failed with %v", ErrCorrupted, it.Err())
+	}
+	l, err = NewLogEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(l)
+
+	// The log should be intact after recovering the PageManager
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(pt.pm.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err = NewLogEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(l)
+
+	// A corrupted record that isn't the last one isn't truncated
+	it = l.Iterator(0)
+	if !it.Next() || !it.Next() {
+		t.Fatal("failed to find the second record", it.Err())
+	}
+	off = it.Offset()
+	if _, err := entry.WriteAt([]byte{^records[1][0]}, off+recordHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLogEntry(entry); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("err should be %v but was %v", ErrCorrupted, err)
+	}
+	if entry.Size() != size {
+		t.Fatalf("the entry shouldn't have been truncated: size %v", entry.Size())
+	}
+}
+
+// TestLogEntryTruncate tests if records can be discarded from a LogEntry
+func TestLogEntryTruncate(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLogEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for i := 0; i < 3; i++ {
+		off, err := l.Append(fastrand.Bytes(pageSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, off)
+	}
+
+	// Remove the last record
+	if err := l.Truncate(offsets[2]); err != nil {
+		t.Fatal(err)
+	}
+	if l.Size() != offsets[2] || entry.Size() != offsets[2] {
+		t.Errorf("size should be %v but was %v", offsets[2], l.Size())
+	}
+
+	// Truncating beyond the end should fail
+	if err := l.Truncate(l.Size() + 1); err == nil {
+		t.Error("truncating beyond the end of the log should fail")
+	}
+
+	// Appending after truncating should work
+	if off, err := l.Append([]byte{1}); err != nil || off != offsets[2] {
+		t.Errorf("record should be appended at %v but was appended at %v: %v", offsets[2], off, err)
+	}
+	if err := l.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if it := l.Iterator(0); it.Next() {
+		t.Error("log should be empty")
+	}
+}
@@ -0,0 +1,423 @@
+package pages
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+const (
+	// leavesPerPage is the number of leaves stored in a page of merkleLeaves
+	leavesPerPage = pageSize / sha256.Size
+)
+
+type (
+	// Hash is a SHA-256 hash used for the Merkle tree of an entry
+	Hash [sha256.Size]byte
+
+	// MerkleProof proves that a page is part of an entry with a certain
+	// Merkle root
+	MerkleProof struct {
+		// PageIndex is the index of the proven page within the entry
+		PageIndex int64
+
+		// NumPages is the number of pages of the entry at the time the proof
+		// was created
+		NumPages int64
+
+		// Hashes are the hashes of the siblings on the path from the page's
+		// leaf to the root
+		Hashes []Hash
+	}
+
+	// merkleTree is a Merkle tree over the pages of an entry. It keeps all the
+	// levels of the tree in memory to support incremental updates. If the
+	// number of nodes of a level is odd, the last node is promoted to the next
+	// level unchanged.
+	merkleTree struct {
+		// levels contains the hashes of every level of the tree. levels[0]
+		// contains the leaves and the last level contains the root.
+		levels [][]Hash
+	}
+
+	// merkleLeaves stores the leaves of an entry's Merkle tree on disk which
+	// allows for loading the tree without hashing the entry's pages. It is a
+	// tieredPage whose pages store leavesPerPage leaves each. The leaf of a
+	// page that changed since it was hashed is stored as the zero hash. The
+	// PageManager's mu needs to be acquired to add or remove pages.
+	merkleLeaves struct {
+		// merkleLeaves is a tieredPage
+		*tieredPage
+
+		// reservedPages are pages that were allocated in advance for the
+		// leaves of pages that are added to the entry. They are used in
+		// order.
+		reservedPages []*physicalPage
+	}
+)
+
+var (
+	// errInvalidPageIndex is returned when trying to create a proof for a
+	// page that doesn't exist
+	errInvalidPageIndex = errors.New("page index is out of range")
+)
+
+// leafHash returns the hash of a leaf. Leaves and nodes are prefixed
+// differently to prevent second preimage attacks.
+func leafHash(data []byte) Hash {
+	return sha256.Sum256(append([]byte{0}, data...))
+}
+
+// nodeHash returns the hash of a node with two children
+func nodeHash(left, right Hash) Hash {
+	data := make([]byte, 1+2*len(left))
+	data[0] = 1
+	copy(data[1:], left[:])
+	copy(data[1+len(left):], right[:])
+	return sha256.Sum256(data)
+}
+
+// newMerkleTree creates a Merkle tree from its leaves
+func newMerkleTree(leaves []Hash) *merkleTree {
+	mt := &merkleTree{}
+	if len(leaves) == 0 {
+		return mt
+	}
+	nodes := append([]Hash{}, leaves...)
+	mt.levels = append(mt.levels, nodes)
+	for len(nodes) > 1 {
+		parents := make([]Hash, 0, (len(nodes)+1)/2)
+		for i := 0; i < len(nodes); i += 2 {
+			if i+1 < len(nodes) {
+				parents = append(parents, nodeHash(nodes[i], nodes[i+1]))
+			} else {
+				parents = append(parents, nodes[i])
+			}
+		}
+		mt.levels = append(mt.levels, parents)
+		nodes = parents
+	}
+	return mt
+}
+
+// root returns the root of the tree. The root of an empty tree is the zero
+// hash.
+func (mt *merkleTree) root() Hash {
+	if len(mt.levels) == 0 {
+		return Hash{}
+	}
+	return mt.levels[len(mt.levels)-1][0]
+}
+
+// numLeaves returns the number of leaves of the tree
+func (mt *merkleTree) numLeaves() int {
+	if len(mt.levels) == 0 {
+		return 0
+	}
+	return len(mt.levels[0])
+}
+
+// setLeaf sets the leaf at index and updates its path to the root. If index
+// is equal to the number of leaves, the leaf is appended.
+func (mt *merkleTree) setLeaf(index int, h Hash) error {
+	if mt.numLeaves() < index {
+		return critical("setting leaf %v would create a gap", index)
+	}
+
+	// Set the leaf and update the parents
+	for level := 0; ; level++ {
+		if level == len(mt.levels) {
+			mt.levels = append(mt.levels, nil)
+		}
+		if index == len(mt.levels[level]) {
+			mt.levels[level] = append(mt.levels[level], h)
+		} else {
+			mt.levels[level][index] = h
+		}
+
+		// Stop at the root
+		if level == len(mt.levels)-1 && len(mt.levels[level]) == 1 {
+			return nil
+		}
+
+		// Compute the parent
+		nodes := mt.levels[level]
+		index /= 2
+		if 2*index+1 < len(nodes) {
+			h = nodeHash(nodes[2*index], nodes[2*index+1])
+		} else {
+			h = nodes[2*index]
+		}
+	}
+}
+
+// truncate removes all but the first n leaves from the tree
+func (mt *merkleTree) truncate(n int) error {
+	if n == 0 {
+		mt.levels = nil
+		return nil
+	}
+	if n > mt.numLeaves() {
+		return critical("can't truncate tree with %v leaves to %v leaves", mt.numLeaves(), n)
+	}
+
+	// Shrink the levels
+	size := n
+	for level := range mt.levels {
+		mt.levels[level] = mt.levels[level][:size]
+		if size == 1 {
+			mt.levels = mt.levels[:level+1]
+			break
+		}
+		size = (size + 1) / 2
+	}
+
+	// The path of the last leaf might have changed
+	return mt.setLeaf(n-1, mt.levels[0][n-1])
+}
+
+// proof returns the hashes of the siblings on the path from a leaf to the
+// root
+func (mt *merkleTree) proof(index int) []Hash {
+	var hashes []Hash
+	for _, nodes := range mt.levels {
+		if len(nodes) == 1 {
+			break
+		}
+		if sibling := index ^ 1; sibling < len(nodes) {
+			hashes = append(hashes, nodes[sibling])
+		}
+		index /= 2
+	}
+	return hashes
+}
+
+// VerifyProof checks if data is the page at proof.PageIndex of an entry with
+// the given Merkle root
+func VerifyProof(root Hash, data []byte, proof MerkleProof) bool {
+	if proof.PageIndex < 0 || proof.PageIndex >= proof.NumPages {
+		return false
+	}
+
+	// Compute the root from the leaf and its siblings
+	h := leafHash(data)
+	hashes := proof.Hashes
+	for index, size := proof.PageIndex, proof.NumPages; size > 1; index, size = index/2, (size+1)/2 {
+		if sibling := index ^ 1; sibling < size {
+			if len(hashes) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				h = nodeHash(h, hashes[0])
+			} else {
+				h = nodeHash(hashes[0], h)
+			}
+			hashes = hashes[1:]
+		}
+	}
+	return len(hashes) == 0 && h == root
+}
+
+// readMerkleRoot reads the Merkle root of an entry from its entryPage. If
+// valid is false, the root needs to be recomputed from the entry's pages.
+func readMerkleRoot(pp *physicalPage) (root Hash, valid bool, err error) {
+	data := make([]byte, merkleRootSize)
+	if _, err = pp.readAt(data, merkleRootOff); err != nil {
+		return
+	}
+	valid = binary.LittleEndian.Uint64(data[:8]) == 1
+	copy(root[:], data[8:])
+	return
+}
+
+// writeMerkleRoot writes the Merkle root of an entry to its entryPage
+func writeMerkleRoot(pp *physicalPage, root Hash, valid bool) error {
+	data := make([]byte, merkleRootSize)
+	if valid {
+		binary.LittleEndian.PutUint64(data[:8], 1)
+		copy(data[8:], root[:])
+	}
+	_, err := pp.writeAt(data, merkleRootOff)
+	return err
+}
+
+// readMerkleLeavesOff reads the offset of the merkleLeaves of an entry from
+// its entryPage. It returns 0 if the leaves weren't stored yet.
+func readMerkleLeavesOff(pp *physicalPage) (int64, error) {
+	data := make([]byte, merkleLeavesSize)
+	if _, err := pp.readAt(data, merkleLeavesOff); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(data)), nil
+}
+
+// writeMerkleLeavesOff writes the offset of the merkleLeaves of an entry to
+// its entryPage
+func writeMerkleLeavesOff(pp *physicalPage, off int64) error {
+	data := make([]byte, merkleLeavesSize)
+	binary.LittleEndian.PutUint64(data, uint64(off))
+	_, err := pp.writeAt(data, merkleLeavesOff)
+	return err
+}
+
+// newMerkleLeaves creates empty merkleLeaves. The PageManager's mu needs to
+// be acquired.
+func newMerkleLeaves(pm *PageManager) (*merkleLeaves, error) {
+	pp, err := pm.allocatePage()
+	if err != nil {
+		return nil, extendErr("failed to allocate page for merkleLeaves", err)
+	}
+	root, err := newPageTable(0, nil, pm)
+	if err != nil {
+		return nil, extendErr("failed to create pageTable for merkleLeaves", err)
+	}
+	ml := &merkleLeaves{
+		tieredPage: &tieredPage{
+			pp:   pp,
+			pm:   pm,
+			root: root,
+			mu:   new(sync.RWMutex),
+		},
+	}
+	if err := writeTieredPageRoot(pp, 0, 0, root.pp.fileOff); err != nil {
+		return nil, extendErr("failed to initialize merkleLeaves", err)
+	}
+	return ml, nil
+}
+
+// loadMerkleLeaves loads the merkleLeaves whose tiered entries are stored in
+// the page at fileOff
+func loadMerkleLeaves(pm *PageManager, fileOff int64) (*merkleLeaves, error) {
+	pp := &physicalPage{
+		file:     pm.file,
+		fileOff:  fileOff,
+		usedSize: pageSize,
+	}
+	usedSize, rootOff, height, err := readTieredPageRoot(pp)
+	if err != nil {
+		return nil, extendErr("failed to read merkleLeaves entry", err)
+	}
+	ml := &merkleLeaves{
+		tieredPage: &tieredPage{
+			pp:       pp,
+			usedSize: usedSize,
+			pm:       pm,
+			mu:       new(sync.RWMutex),
+		},
+	}
+	if err := ml.recoverTree(context.Background(), rootOff, height); err != nil {
+		return nil, extendErr("failed to recover merkleLeaves", err)
+	}
+	return ml, nil
+}
+
+// numLeaves returns the number of stored leaves
+func (ml *merkleLeaves) numLeaves() int {
+	return int(ml.usedSize / sha256.Size)
+}
+
+// read returns the stored leaves
+func (ml *merkleLeaves) read() ([]Hash, error) {
+	leaves := make([]Hash, 0, ml.numLeaves())
+	data := make([]byte, pageSize)
+	for _, page := range ml.pages {
+		n, err := page.readAt(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		for off := 0; off+sha256.Size <= n; off += sha256.Size {
+			var h Hash
+			copy(h[:], data[off:])
+			leaves = append(leaves, h)
+		}
+	}
+	return leaves, nil
+}
+
+// write stores leaves starting at the leaf with index start. Pages are added
+// if the leaves don't fit into the existing ones. The PageManager's mu needs
+// to be acquired.
+func (ml *merkleLeaves) write(start int, leaves []Hash) error {
+	if start > ml.numLeaves() {
+		return critical("writing leaf %v would create a gap", start)
+	}
+	data := make([]byte, 0, len(leaves)*sha256.Size)
+	for _, h := range leaves {
+		data = append(data, h[:]...)
+	}
+
+	// Add the missing pages
+	height := ml.root.height
+	end := int64(start)*sha256.Size + int64(len(data))
+	for int64(len(ml.pages))*pageSize < end {
+		page, err := ml.allocatePage()
+		if err != nil {
+			return extendErr("failed to allocate merkleLeaves page", err)
+		}
+		if err := ml.insertPage(uint64(len(ml.pages)), page); err != nil {
+			return extendErr("failed to insert page", err)
+		}
+		ml.pages = append(ml.pages, page)
+	}
+
+	// Write the leaves
+	for off := int64(start) * sha256.Size; len(data) > 0; {
+		n, err := ml.pages[off/pageSize].writeAt(data, off%pageSize)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		off += int64(n)
+	}
+	if end <= ml.usedSize {
+		return nil
+	}
+	ml.usedSize = end
+	return ml.writeRoot(height)
+}
+
+// allocatePage returns the next reserved page or allocates a new one
+func (ml *merkleLeaves) allocatePage() (*physicalPage, error) {
+	if len(ml.reservedPages) > 0 {
+		pp := ml.reservedPages[0]
+		ml.reservedPages = ml.reservedPages[1:]
+		return pp, nil
+	}
+	return ml.pm.allocatePage()
+}
+
+// pagesNeeded returns the number of pages that need to be added to store the
+// leaves of numPages pages
+func (ml *merkleLeaves) pagesNeeded(numPages int) int {
+	n := (numPages+leavesPerPage-1)/leavesPerPage - len(ml.pages)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// truncate removes all but the first n leaves and frees the pages that are no
+// longer needed. The PageManager's mu needs to be acquired.
+func (ml *merkleLeaves) truncate(n int) error {
+	size := int64(n) * sha256.Size
+	if size >= ml.usedSize {
+		return nil
+	}
+	_, pagesToFree1, err := ml.recursiveTruncate(context.Background(), ml.root, size)
+	if err != nil {
+		return err
+	}
+	pagesToFree2, err := ml.defrag()
+	if err != nil {
+		return err
+	}
+	return ml.pm.bitmap.free(append(pagesToFree1, pagesToFree2...))
+}
+
+// allPages returns all the pages that are used by the merkleLeaves
+func (ml *merkleLeaves) allPages() []*physicalPage {
+	pages := append([]*physicalPage{ml.pp}, tablePages(ml.root)...)
+	return append(pages, ml.pages...)
+}
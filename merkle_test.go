@@ -0,0 +1,295 @@
+package pages
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// referenceRoot is a helper function that computes the Merkle root of a list
+// of leaves without any incremental updates
+func referenceRoot(leaves []Hash) Hash {
+	if len(leaves) == 0 {
+		return Hash{}
+	}
+	for len(leaves) > 1 {
+		var parents []Hash
+		for i := 0; i < len(leaves); i += 2 {
+			if i+1 < len(leaves) {
+				parents = append(parents, nodeHash(leaves[i], leaves[i+1]))
+			} else {
+				parents = append(parents, leaves[i])
+			}
+		}
+		leaves = parents
+	}
+	return leaves[0]
+}
+
+// referenceEntryRoot is a helper function that computes the Merkle root of an
+// entry's data
+func referenceEntryRoot(data []byte) Hash {
+	var leaves []Hash
+	for len(data) > 0 {
+		n := pageSize
+		if len(data) < n {
+			n = len(data)
+		}
+		leaves = append(leaves, leafHash(data[:n]))
+		data = data[n:]
+	}
+	return referenceRoot(leaves)
+}
+
+// TestMerkleTree tests if incrementally updating the merkleTree results in the
+// same root as computing it from scratch and if proofs can be verified
+func TestMerkleTree(t *testing.T) {
+	mt := &merkleTree{}
+	var leaves []Hash
+	var data [][]byte
+
+	// Append leaves and compare the roots
+	for i := 0; i < 33; i++ {
+		data = append(data, fastrand.Bytes(10))
+		leaves = append(leaves, leafHash(data[i]))
+		mt.setLeaf(i, leaves[i])
+		if mt.root() != referenceRoot(leaves) {
+			t.Fatalf("Root doesn't match after appending leaf %v", i)
+		}
+	}
+
+	// Modify some leaves
+	for _, i := range []int{0, 7, 32} {
+		data[i] = fastrand.Bytes(10)
+		leaves[i] = leafHash(data[i])
+		mt.setLeaf(i, leaves[i])
+		if mt.root() != referenceRoot(leaves) {
+			t.Fatalf("Root doesn't match after modifying leaf %v", i)
+		}
+	}
+
+	// Every leaf should be provable
+	root := mt.root()
+	for i := range leaves {
+		proof := MerkleProof{
+			PageIndex: int64(i),
+			NumPages:  int64(len(leaves)),
+			Hashes:    mt.proof(i),
+		}
+		if !VerifyProof(root, data[i], proof) {
+			t.Errorf("Failed to verify proof for leaf %v", i)
+		}
+		if VerifyProof(root, fastrand.Bytes(10), proof) {
+			t.Errorf("Proof for leaf %v was verified with the wrong data", i)
+		}
+	}
+
+	// Truncate the tree
+	for _, n := range []int{17, 16, 5, 1, 0} {
+		mt.truncate(n)
+		leaves = leaves[:n]
+		if mt.root() != referenceRoot(leaves) {
+			t.Fatalf("Root doesn't match after truncating to %v leaves", n)
+		}
+	}
+}
+
+// TestEntryMerkleRoot tests if the Merkle root of an entry is kept up to date
+// and if it is persisted correctly
+func TestEntryMerkleRoot(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create an entry and write some data to it
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(10*pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	root, err := entry.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Merkle root doesn't match the data")
+	}
+
+	// Overwrite some data and truncate the entry
+	newData := fastrand.Bytes(pageSize)
+	if _, err := entry.WriteAt(newData, 2000); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[2000:], newData)
+	if err := entry.Truncate(int64(len(data) - 2*pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	data = data[:len(data)-2*pageSize]
+	root, err = entry.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Merkle root doesn't match the data")
+	}
+
+	// Create a proof for every page and verify it
+	for i := int64(0); i < int64(len(entry.ep.pages)); i++ {
+		proof, err := entry.Proof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageData := make([]byte, pageSize)
+		n, err := entry.ReadAt(pageData, i*PageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyProof(root, pageData[:n], proof) {
+			t.Errorf("Failed to verify proof for page %v", i)
+		}
+	}
+	if _, err := entry.Proof(int64(len(entry.ep.pages))); err != errInvalidPageIndex {
+		t.Errorf("err should be %v but was %v", errInvalidPageIndex, err)
+	}
+
+	// The root should be stored on disk
+	storedRoot, valid, err := readMerkleRoot(entry.ep.pp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid || storedRoot != root {
+		t.Error("Stored Merkle root doesn't match")
+	}
+
+	// Recover the entry from disk. The stored root should be used.
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.merkleTree != nil || !entry.ep.merkleRootValid {
+		t.Fatal("Merkle tree shouldn't be loaded but the root should be valid")
+	}
+	if root, err = entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Recovered Merkle root doesn't match the data")
+	}
+
+	// Writing to the entry invalidates the stored root until it's recomputed
+	if _, err := entry.WriteAt(newData, 0); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, newData)
+	if _, valid, err = readMerkleRoot(entry.ep.pp); err != nil || valid {
+		t.Fatalf("Stored Merkle root should be invalid: %v", err)
+	}
+	if root, err = entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Recomputed Merkle root doesn't match the data")
+	}
+	if storedRoot, valid, err = readMerkleRoot(entry.ep.pp); err != nil || !valid || storedRoot != root {
+		t.Errorf("Recomputed Merkle root wasn't stored: %v", err)
+	}
+	if bytes.Compare(storedRoot[:], root[:]) != 0 {
+		t.Error("Stored root doesn't match")
+	}
+}
+
+// TestEntryMerkleLeaves tests if the leaves of an entry's Merkle tree are
+// stored and only the pages that changed are hashed again after the entry is
+// loaded from disk
+func TestEntryMerkleLeaves(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	// Writing to a new entry shouldn't hash its pages
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(5*pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if entry.ep.merkleTree != nil || entry.ep.merkleLeaves != nil {
+		t.Fatal("Merkle tree shouldn't be loaded before the root is requested")
+	}
+	root, err := entry.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Merkle root doesn't match the data")
+	}
+	if n := entry.ep.merkleLeaves.numLeaves(); n != len(entry.ep.pages) {
+		t.Fatalf("%v leaves should be stored but %v were", len(entry.ep.pages), n)
+	}
+
+	// Reload the entry and overwrite a page
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	newData := fastrand.Bytes(100)
+	if _, err := entry.WriteAt(newData, pageSize+10); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[pageSize+10:], newData)
+
+	// Change another page behind the entry's back. Its stored leaf should
+	// still be used which means it isn't read again.
+	if _, err := entry.ep.pages[3].writeAt(fastrand.Bytes(10), 0); err != nil {
+		t.Fatal(err)
+	}
+	if root, err = entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Merkle root should be computed from the stored leaves")
+	}
+
+	// Truncating and extending the entry should keep the leaves up to date
+	if err := entry.Truncate(2*pageSize + 10); err != nil {
+		t.Fatal(err)
+	}
+	data = data[:2*pageSize+10]
+	if _, err := entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+	newData = fastrand.Bytes(2 * pageSize)
+	if _, err := entry.WriteAt(newData, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, newData...)
+	if root, err = entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if root != referenceEntryRoot(data) {
+		t.Error("Merkle root doesn't match the data")
+	}
+	leaves, err := entry.ep.merkleLeaves.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaves) != len(entry.ep.pages) || referenceRoot(leaves) != root {
+		t.Errorf("stored leaves don't match the root")
+	}
+}
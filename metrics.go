@@ -0,0 +1,239 @@
+package pages
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The names of the metrics reported to a MetricsHook. They follow the naming
+// conventions of Prometheus.
+const (
+	// MetricPagesAllocatedFresh counts the pages that were appended to the
+	// file
+	MetricPagesAllocatedFresh = "pages_allocated_fresh_total"
+
+	// MetricPagesAllocatedRecycled counts the free pages that were reused
+	MetricPagesAllocatedRecycled = "pages_allocated_recycled_total"
+
+	// MetricPagesFreed counts the pages that were freed
+	MetricPagesFreed = "pages_freed_total"
+
+	// MetricBytesRead counts the bytes read from entries
+	MetricBytesRead = "pages_read_bytes_total"
+
+	// MetricBytesWritten counts the bytes written to entries
+	MetricBytesWritten = "pages_written_bytes_total"
+
+	// MetricReadDuration is the latency of reading from an entry
+	MetricReadDuration = "pages_read_duration_seconds"
+
+	// MetricWriteDuration is the latency of writing to an entry
+	MetricWriteDuration = "pages_write_duration_seconds"
+
+	// MetricTruncateDuration is the latency of truncating an entry
+	MetricTruncateDuration = "pages_truncate_duration_seconds"
+
+	// MetricEntryLockWait is the time spent waiting for the lock of an
+	// entry's tieredPage
+	MetricEntryLockWait = "pages_entry_lock_wait_seconds"
+
+	// MetricManagerLockWait is the time spent waiting for the lock of the
+	// PageManager
+	MetricManagerLockWait = "pages_manager_lock_wait_seconds"
+
+	// MetricCacheHits counts the entries that were opened while they were
+	// already loaded
+	MetricCacheHits = "pages_entry_cache_hits_total"
+
+	// MetricCacheMisses counts the entries that had to be loaded from disk
+	// when they were opened
+	MetricCacheMisses = "pages_entry_cache_misses_total"
+
+	// MetricSyncDuration is the duration of syncing the file
+	MetricSyncDuration = "pages_fsync_duration_seconds"
+)
+
+// histogramBuckets are the upper bounds of the buckets of the histograms of
+// Metrics in seconds. They range from 1µs to about 16s.
+var histogramBuckets = func() []float64 {
+	buckets := make([]float64, 13)
+	for i := range buckets {
+		buckets[i] = 1e-6 * math.Pow(4, float64(i))
+	}
+	return buckets
+}()
+
+type (
+	// MetricsHook receives the metrics of a PageManager. It can be used to
+	// report the metrics to any metrics system. Its methods are called
+	// concurrently and shouldn't block.
+	MetricsHook interface {
+		// AddCounter increases the counter with the given name by delta
+		AddCounter(name string, delta int64)
+
+		// ObserveDuration adds a duration to the histogram with the given
+		// name
+		ObserveDuration(name string, d time.Duration)
+	}
+
+	// Metrics is a MetricsHook that keeps the metrics in memory. It
+	// implements expvar.Var and can be published using expvar.Publish.
+	Metrics struct {
+		counters   map[string]int64
+		histograms map[string]*Histogram
+		mu         *sync.Mutex
+	}
+
+	// Histogram is a snapshot of a histogram of Metrics. Like a histogram of
+	// Prometheus, the count of a bucket includes the counts of the buckets
+	// with lower bounds.
+	Histogram struct {
+		// Buckets are the upper bounds of the buckets in seconds
+		Buckets []float64
+
+		// Counts are the cumulative counts of the buckets
+		Counts []uint64
+
+		// Count is the number of observed durations and Sum is their sum in
+		// seconds
+		Count uint64
+		Sum   float64
+	}
+
+	// metrics reports the metrics of a PageManager to its MetricsHook. A nil
+	// metrics discards them.
+	metrics struct {
+		hook MetricsHook
+	}
+)
+
+// NewMetrics creates an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:   make(map[string]int64),
+		histograms: make(map[string]*Histogram),
+		mu:         new(sync.Mutex),
+	}
+}
+
+// AddCounter increases the counter with the given name by delta
+func (m *Metrics) AddCounter(name string, delta int64) {
+	m.mu.Lock()
+	m.counters[name] += delta
+	m.mu.Unlock()
+}
+
+// ObserveDuration adds a duration to the histogram with the given name
+func (m *Metrics) ObserveDuration(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, exists := m.histograms[name]
+	if !exists {
+		h = &Histogram{
+			Buckets: histogramBuckets,
+			Counts:  make([]uint64, len(histogramBuckets)),
+		}
+		m.histograms[name] = h
+	}
+	seconds := d.Seconds()
+	for i := sort.SearchFloat64s(h.Buckets, seconds); i < len(h.Counts); i++ {
+		h.Counts[i]++
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+// Counter returns the value of the counter with the given name
+func (m *Metrics) Counter(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+// Histogram returns a snapshot of the histogram with the given name
+func (m *Metrics) Histogram(name string) Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, exists := m.histograms[name]
+	if !exists {
+		return Histogram{
+			Buckets: histogramBuckets,
+			Counts:  make([]uint64, len(histogramBuckets)),
+		}
+	}
+	snapshot := *h
+	snapshot.Counts = append([]uint64(nil), h.Counts...)
+	return snapshot
+}
+
+// String returns the metrics as a JSON object. The buckets of histograms are
+// keyed by their upper bounds.
+func (m *Metrics) String() string {
+	type histogramJSON struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}
+	m.mu.Lock()
+	histograms := make(map[string]histogramJSON, len(m.histograms))
+	for name, h := range m.histograms {
+		buckets := make(map[string]uint64, len(h.Buckets)+1)
+		for i, bound := range h.Buckets {
+			buckets[strconv.FormatFloat(bound, 'g', -1, 64)] = h.Counts[i]
+		}
+		buckets["+Inf"] = h.Count
+		histograms[name] = histogramJSON{buckets, h.Count, h.Sum}
+	}
+	data, err := json.Marshal(struct {
+		Counters   map[string]int64         `json:"counters"`
+		Histograms map[string]histogramJSON `json:"histograms"`
+	}{m.counters, histograms})
+	m.mu.Unlock()
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// newMetrics creates the metrics of a PageManager using a MetricsHook. It
+// returns nil if there is no hook.
+func newMetrics(hook MetricsHook) *metrics {
+	if hook == nil {
+		return nil
+	}
+	return &metrics{hook: hook}
+}
+
+// add increases a counter
+func (m *metrics) add(name string, delta int64) {
+	if m != nil && delta != 0 {
+		m.hook.AddCounter(name, delta)
+	}
+}
+
+// start returns the time at which an observed operation starts
+func (m *metrics) start() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// observe adds the duration of an operation that started at start to a
+// histogram
+func (m *metrics) observe(name string, start time.Time) {
+	if m != nil {
+		m.hook.ObserveDuration(name, time.Since(start))
+	}
+}
+
+// wait acquires a lock and records the time spent waiting for it
+func (m *metrics) wait(name string, lock func()) {
+	start := m.start()
+	lock()
+	m.observe(name, start)
+}
@@ -0,0 +1,118 @@
+package pages
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestMetrics tests if the operations of a PageManager are reported to its
+// MetricsHook
+func TestMetrics(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetrics()
+	pm, err := NewWithOptions(path, Options{Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Write, read and truncate an entry
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadAt(make([]byte, pageSize), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Counter(MetricBytesWritten) != 3*pageSize || m.Counter(MetricBytesRead) != pageSize {
+		t.Fatalf("wrong byte counters %v %v", m.Counter(MetricBytesWritten), m.Counter(MetricBytesRead))
+	}
+	if m.Counter(MetricPagesAllocatedFresh) == 0 || m.Counter(MetricPagesFreed) != 2 {
+		t.Fatalf("wrong page counters %v %v", m.Counter(MetricPagesAllocatedFresh), m.Counter(MetricPagesFreed))
+	}
+
+	// Freed pages are recycled
+	if _, err := entry.WriteAt(fastrand.Bytes(pageSize), pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if m.Counter(MetricPagesAllocatedRecycled) != 1 {
+		t.Fatalf("1 page should have been recycled but %v were", m.Counter(MetricPagesAllocatedRecycled))
+	}
+
+	// Opening the entry again is a cache hit
+	entry2, err := pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+	entry2.Close()
+	if _, err := pm.Open(id); err != nil {
+		t.Fatal(err)
+	}
+	if m.Counter(MetricCacheHits) != 1 || m.Counter(MetricCacheMisses) != 1 {
+		t.Fatalf("wrong cache counters %v %v", m.Counter(MetricCacheHits), m.Counter(MetricCacheMisses))
+	}
+
+	// Check the histograms
+	for _, name := range []string{MetricReadDuration, MetricWriteDuration, MetricTruncateDuration,
+		MetricEntryLockWait, MetricManagerLockWait, MetricSyncDuration} {
+		if h := m.Histogram(name); h.Count == 0 || h.Counts[len(h.Counts)-1] > h.Count {
+			t.Errorf("wrong histogram %v: %+v", name, h)
+		}
+	}
+
+	// The metrics can be published using expvar
+	expvar.Publish(t.Name(), m)
+	var decoded struct {
+		Counters   map[string]int64 `json:"counters"`
+		Histograms map[string]struct {
+			Buckets map[string]uint64 `json:"buckets"`
+			Count   uint64            `json:"count"`
+		} `json:"histograms"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(t.Name()).String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Counters[MetricBytesWritten] != 4*pageSize {
+		t.Errorf("counter should be %v but was %v", 4*pageSize, decoded.Counters[MetricBytesWritten])
+	}
+	if h := decoded.Histograms[MetricWriteDuration]; h.Count != 2 || h.Buckets["+Inf"] != 2 {
+		t.Errorf("wrong histogram %+v", h)
+	}
+}
+
+// TestHistogram tests if durations are added to the right buckets
+func TestHistogram(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveDuration("test", 3*time.Microsecond)
+	m.ObserveDuration("test", time.Minute)
+	h := m.Histogram("test")
+	if h.Count != 2 || h.Sum != 60.000003 {
+		t.Fatalf("wrong count or sum %v %v", h.Count, h.Sum)
+	}
+	for i, bound := range h.Buckets {
+		expected := uint64(0)
+		if bound >= 3e-6 {
+			expected = 1
+		}
+		if h.Counts[i] != expected {
+			t.Errorf("bucket %v should have %v observations but had %v", bound, expected, h.Counts[i])
+		}
+	}
+}
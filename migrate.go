@@ -0,0 +1,392 @@
+package pages
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+var (
+	// errEntriesV1 is returned when reading the root of a tieredPage without
+	// any valid entries. The entries of such a tieredPage still use the
+	// version 1 format.
+	errEntriesV1 = fmt.Errorf("%w: tieredPage has no valid entries", ErrCorrupted)
+)
+
+// LegacyIdentifier returns the Identifier of an entry of a version 1 file.
+// Version 1 files identified entries by the offset of their entryPage. The
+// entry is migrated and added to the idTable when it is opened for the first
+// time. Afterwards the legacy Identifier is looked up in the idTable. Since
+// version 1 entryPages contain no nonce, a legacy Identifier can't detect
+// that the page of a deleted entry was reused.
+func LegacyIdentifier(fileOff int64) Identifier {
+	return newIdentifier(legacyIndex, uint64(fileOff))
+}
+
+// maxPages return the number of pages a tree with a certain height can
+// contain if every pageTable stores numPageEntries individual pages. The
+// height starts at 0. Version 1 files mark the entries of previous roots by
+// storing this number of pages as their used size.
+func maxPages(height int64) uint64 {
+	return uint64(math.Pow(numPageEntries, float64(height+1)))
+}
+
+// readEntryPageEntryV1 reads an entry of a tieredPage of a version 1 file
+func readEntryPageEntryV1(pp *physicalPage, index int64) (usedBytes int64, pageOff int64, err error) {
+	// Read the data from disk
+	entryData := make([]byte, tieredPageEntrySize)
+	_, err = pp.readAt(entryData, index*tieredPageEntrySize)
+	if err != nil {
+		return
+	}
+
+	// Unmarshal the usedBytes
+	var bytesRead int
+	if usedBytes, bytesRead = binary.Varint(entryData[0:8]); (usedBytes == 0 && bytesRead <= 0) || usedBytes < 0 || usedBytes > maxFileSize {
+		err = pageError(ErrCorrupted, pp.fileOff, "failed to unmarshal usedBytes of entry %v", index)
+		return
+	}
+
+	// Unmarshal the pageOff
+	if pageOff, bytesRead = binary.Varint(entryData[8:]); (pageOff == 0 && bytesRead <= 0) || pageOff < 0 || pageOff >= maxFileSize {
+		err = pageError(ErrCorrupted, pp.fileOff, "failed to unmarshal pageOff of entry %v", index)
+		return
+	}
+	return
+}
+
+// readTieredPageRootV1 reads the entries of a tieredPage of a version 1 file
+// and returns the usedSize, the offset and the height of the tree's root. The
+// root is stored in the first entry that isn't full yet. A tree that is
+// exactly full is followed by an unused entry which is skipped.
+func readTieredPageRootV1(pp *physicalPage) (usedSize, rootOff, height int64, err error) {
+	for i := int64(0); i < maxTieredEntries; i++ {
+		entryUsed, entryOff, err := readEntryPageEntryV1(pp, i)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if i > 0 && entryUsed == 0 && entryOff == 0 {
+			break
+		}
+		usedSize, rootOff, height = entryUsed, entryOff, i
+		if uint64(usedSize) < maxPages(height)*pageSize {
+			break
+		}
+	}
+	return
+}
+
+// unmarshalPageTableV1 unmarshals a pageTable of a version 1 file. It starts
+// with the number of its entries followed by a varint per entry.
+func unmarshalPageTableV1(data []byte) (entries []int64, err error) {
+	// The data should be at least 8 bytes long
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%w: pageTable is too short", ErrCorrupted)
+	}
+
+	// Unmarshal the number of entries in the table
+	numEntries := binary.LittleEndian.Uint64(data[:8])
+	off := 8
+
+	// Sanity check numEntries and the remaining data length
+	if numEntries > numPageEntries {
+		return nil, fmt.Errorf("%w: numEntries(%v) > numPageEntries(%v)",
+			ErrCorrupted, numEntries, numPageEntries)
+	}
+	if uint64(len(data[off:])) < numEntries*8 {
+		return nil, fmt.Errorf("%w: %v < %v", ErrCorrupted, len(data[off:]), numEntries*8)
+	}
+
+	// Unmarshal the entries
+	for i := uint64(0); i < numEntries; i++ {
+		offset, bytesRead := binary.Varint(data[off : off+8])
+		if (offset == 0 && bytesRead <= 0) || offset < 0 {
+			return nil, fmt.Errorf("%w: failed to unmarshal offset %v", ErrCorrupted, i)
+		}
+		off += 8
+		entries = append(entries, offset)
+	}
+	return
+}
+
+// loadTreeV1 loads the pageTable tree of a tieredPage whose entries use the
+// version 1 format. Version 1 files don't rewrite a leaf table after it was
+// truncated, which means that it might still point to pages past the used
+// size. Those pages are removed from the tree. Empty trees might point to the
+// tieredPage itself instead of a pageTable in which case tp.root is nil.
+func (tp *tieredPage) loadTreeV1(ctx context.Context) error {
+	usedSize, rootOff, height, err := readTieredPageRootV1(tp.pp)
+	if err != nil {
+		return extendErr("failed to read tieredPage entry", err)
+	}
+	tp.usedSize = usedSize
+	if rootOff == tp.pp.fileOff && usedSize == 0 && height == 0 {
+		return nil
+	}
+	if err := tp.recoverTree(ctx, rootOff, height); err != nil {
+		return extendErr("failed to recover tree", err)
+	}
+	numPages := (usedSize + pageSize - 1) / pageSize
+	for int64(len(tp.pages)) > numPages {
+		tp.leafPageTable(uint64(len(tp.pages) - 1)).removeLastPage()
+		tp.pages = tp.pages[:len(tp.pages)-1]
+	}
+	return tp.checkTreeV1()
+}
+
+// checkTreeV1 makes sure that the pageTables and pages of a tree loaded from
+// a version 1 file are distinct pages of the data area
+func (tp *tieredPage) checkTreeV1() error {
+	f := tp.pp.file
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return ioError(err, tp.pp.fileOff)
+	}
+	used := map[int64]struct{}{tp.pp.fileOff: {}}
+	for _, pp := range append(tablePages(tp.root), tp.pages...) {
+		if pp.fileOff < f.dataOff() || pp.fileOff%f.slotSize() != 0 || pp.fileOff >= size {
+			return pageError(ErrCorrupted, pp.fileOff, "tree of tieredPage %v points outside of the data area", tp.pp.fileOff)
+		}
+		if _, exists := used[pp.fileOff]; exists {
+			return pageError(ErrCorrupted, pp.fileOff, "page is used twice by tree of tieredPage %v", tp.pp.fileOff)
+		}
+		used[pp.fileOff] = struct{}{}
+	}
+	return nil
+}
+
+// migrateTreeV1 rewrites the pageTables and the entries of a tieredPage that
+// was loaded using loadTreeV1. The pageTables are rewritten first since they
+// can be read in both formats. The entries are replaced with a single write
+// afterwards that also writes meta behind them. Trees without a root get a
+// new one. The PageManager's mu needs to be acquired.
+func (p *PageManager) migrateTreeV1(tp *tieredPage, meta []byte) error {
+	if tp.root == nil {
+		root, err := newPageTable(0, nil, p)
+		if err != nil {
+			return extendErr("failed to create root pageTable", err)
+		}
+		tp.root = root
+	}
+	if err := writePageTables(tp.root); err != nil {
+		return extendErr("failed to rewrite pageTables", err)
+	}
+	data := make([]byte, maxTieredEntries*tieredPageEntrySize, maxTieredEntries*tieredPageEntrySize+len(meta))
+	copy(data[tp.root.height*tieredPageEntrySize:], marshalTieredPageEntry(tp.usedSize, tp.root.pp.fileOff|entryRootFlag))
+	if _, err := tp.pp.writeAt(append(data, meta...), 0); err != nil {
+		return extendErr("failed to rewrite tieredPage entries", err)
+	}
+	return nil
+}
+
+// legacyEntry returns the Identifier of the entry whose entryPage is at
+// fileOff. The entryPages of version 1 files are migrated and added to the
+// idTable when they are opened for the first time. Before anything is
+// written, the entry's tree is loaded and checked. The entry header, an
+// invalid Merkle root and empty attributes are written together with the new
+// entries. If the migration is interrupted before the entry is added to the
+// idTable, it is added the next time. The p.mu lock needs to be acquired.
+func (p *PageManager) legacyEntry(ctx context.Context, fileOff int64) (Identifier, error) {
+	// Make sure the offset points to an allocated page of the data area
+	size, err := p.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Identifier{}, ioError(err, fileOff)
+	}
+	if fileOff < p.file.dataOff() || fileOff%p.file.slotSize() != 0 || fileOff >= size ||
+		!p.bitmap.isSet(p.bitmap.index(fileOff)) {
+		return Identifier{}, pageError(ErrNotFound, fileOff, "no entryPage at offset %v", fileOff)
+	}
+	pp := &physicalPage{
+		file:     p.file,
+		fileOff:  fileOff,
+		usedSize: pageSize,
+	}
+
+	// Entries that were migrated already are looked up in the idTable. Other
+	// pages with valid entries belong to the metadata of the file.
+	tag, nonce, err := readEntryHeader(pp)
+	if err != nil {
+		return Identifier{}, extendErr("failed to read entry header", err)
+	}
+	if _, _, _, err := readTieredPageRoot(pp); err == nil && tag == entryPageTag {
+		for index, off := range p.ids.offsets {
+			if off == fileOff {
+				return newIdentifier(uint64(index), nonce), nil
+			}
+		}
+		index, err := p.ids.add(fileOff)
+		if err != nil {
+			return Identifier{}, extendErr("failed to add entry to idTable", err)
+		}
+		return newIdentifier(index, nonce), nil
+	} else if err == nil {
+		return Identifier{}, pageError(ErrNotFound, fileOff, "page at offset %v isn't an entryPage", fileOff)
+	}
+
+	// Migrate the entryPage
+	tp := &tieredPage{
+		pp: pp,
+		pm: p,
+		mu: new(sync.RWMutex),
+	}
+	if err := tp.loadTreeV1(ctx); err != nil {
+		return Identifier{}, extendErr("failed to load entry", err)
+	}
+	nonce = newEntryNonce()
+	meta := make([]byte, attrsOff+attrsLenSize-merkleRootOff)
+	binary.LittleEndian.PutUint64(meta[entryHeaderOff-merkleRootOff:], entryPageTag)
+	binary.LittleEndian.PutUint64(meta[entryHeaderOff-merkleRootOff+8:], nonce)
+	if err := p.migrateTreeV1(tp, meta); err != nil {
+		return Identifier{}, extendErr("failed to migrate entry", err)
+	}
+	index, err := p.ids.add(fileOff)
+	if err != nil {
+		return Identifier{}, extendErr("failed to add entry to idTable", err)
+	}
+	return newIdentifier(index, nonce), nil
+}
+
+// migrateV1 migrates a version 1 file to version 3. Version 1 files start
+// with the recyclingPage which is directly followed by the data area. The
+// free pages are loaded and checked before anything is written. The idTable
+// is created on new pages and recorded in the layout of the version 3
+// header. The entries are migrated when they are opened.
+func (p *PageManager) migrateV1() error {
+	layout := fileLayout{dataPage: 1}
+	p.file.setLayout(layout)
+
+	// Load the free pages
+	rp := &tieredPage{
+		pp: &physicalPage{
+			file:     p.file,
+			fileOff:  freeOff,
+			usedSize: pageSize,
+		},
+		pm: p,
+		mu: new(sync.RWMutex),
+	}
+	if err := rp.loadTreeV1(context.Background()); err != nil {
+		return extendErr("failed to load recyclingPage", err)
+	}
+	free := rp.pages
+	if rp.root != nil {
+		free = append(free, tablePages(rp.root)...)
+	}
+
+	// Create the idTable
+	pp, err := p.appendPage()
+	if err != nil {
+		return extendErr("failed to allocate page for idTable", err)
+	}
+	layout.idTablePage = pp.fileOff / p.file.slotSize()
+	p.file.setLayout(layout)
+	if _, err := newIDTable(p); err != nil {
+		return extendErr("failed to create idTable", err)
+	}
+	return p.replaceFreePages(free, layout)
+}
+
+// writePageTables writes all the pageTables of a tree to disk
+func writePageTables(pt *pageTable) error {
+	for _, child := range pt.childTables {
+		if err := writePageTables(child); err != nil {
+			return err
+		}
+	}
+	return pt.writeToDisk()
+}
+
+// migrateFreeSpace replaces the recyclingPage of a version 2 file with a
+// bitmap. The pages of the recyclingPage and its pageTables are free.
+func (p *PageManager) migrateFreeSpace(h formatHeader) error {
+	// Load the free pages
+	pp := &physicalPage{
+		file:     p.file,
+		fileOff:  freeOff,
+		usedSize: pageSize,
+	}
+	usedSize, rootOff, height, err := readTieredPageRoot(pp)
+	if err != nil {
+		return extendErr("failed to read recyclingPage entry", err)
+	}
+	rp := &tieredPage{
+		pp:       pp,
+		usedSize: usedSize,
+		pm:       p,
+		mu:       new(sync.RWMutex),
+	}
+	if err := rp.recoverTree(context.Background(), rootOff, height); err != nil {
+		return extendErr("failed to recover recyclingPage", err)
+	}
+	return p.replaceFreePages(append(rp.pages, tablePages(rp.root)...), h.layout)
+}
+
+// replaceFreePages replaces the recyclingPage at the beginning of the file
+// with a bitmap in which the given pages are free and all the other pages of
+// the data area are allocated. The bitmap is created on new pages and its
+// entries are written to a temporary page. They are copied to the first page
+// together with the version 3 header in a single write. Until then the file
+// remains a valid file of its previous version. Pages that were appended by
+// an interrupted migration are considered allocated when it is continued.
+func (p *PageManager) replaceFreePages(free []*physicalPage, layout fileLayout) error {
+	// Create the bitmap. All existing pages are marked as allocated to make
+	// sure the pages of the recyclingPage aren't overwritten.
+	tmp, err := p.appendPage()
+	if err != nil {
+		return extendErr("failed to allocate temporary page", err)
+	}
+	tmp.usedSize = pageSize
+	b := &bitmap{
+		tieredPage: &tieredPage{
+			pp: tmp,
+			pm: p,
+			mu: new(sync.RWMutex),
+		},
+	}
+	b.numPages = b.index(tmp.fileOff) + 1
+	for i := int64(0); i < b.numPages; i++ {
+		b.setBit(i, true)
+	}
+	b.hint = b.numPages
+	if err := b.create(); err != nil {
+		return err
+	}
+	p.bitmap = nil
+	if err := b.free(append(free, tmp)); err != nil {
+		return extendErr("failed to free pages", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return ioError(err, tmp.fileOff)
+	}
+
+	// Replace the recyclingPage
+	pp := &physicalPage{
+		file:     p.file,
+		fileOff:  freeOff,
+		usedSize: pageSize,
+	}
+	data := make([]byte, formatHeaderOff+formatHeaderSize)
+	if _, err := tmp.readAt(data[:formatHeaderOff], 0); err != nil {
+		return err
+	}
+	copy(data[formatHeaderOff:], formatHeader{version: formatVersion3, layout: layout}.marshal())
+	if _, err := pp.writeAt(data, 0); err != nil {
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		return ioError(err, freeOff)
+	}
+	return nil
+}
+
+// tablePages returns the pages of all the pageTables of a tree
+func tablePages(pt *pageTable) []*physicalPage {
+	pages := []*physicalPage{pt.pp}
+	for _, child := range pt.childTables {
+		pages = append(pages, tablePages(child)...)
+	}
+	return pages
+}
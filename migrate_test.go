@@ -0,0 +1,337 @@
+package pages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// The version 1 file in testdata was created using the baseline version of
+// the package. It contains 4 entries that were created one after another:
+//   - A at page 2 contains baselineDataA
+//   - B at page 8 was never written
+//   - D at page 10 contained 4 pages and was truncated to 0 afterwards
+//   - C at page 12 contains "c" and reuses 3 of the pages that D freed
+//
+// The recyclingPage at page 0 contains page 15. Its root table at page 1 and
+// the root table of D at page 11 still point to the pages that are used by C.
+const (
+	baselineEntryA = 2 * pageSize
+	baselineEntryB = 8 * pageSize
+	baselineEntryD = 10 * pageSize
+	baselineEntryC = 12 * pageSize
+	baselinePages  = 16
+)
+
+// baselineDataA returns the data of entry A of the version 1 file
+func baselineDataA() []byte {
+	data := make([]byte, 3*pageSize+10)
+	for i := range data {
+		data[i] = byte(i%251) ^ 1
+	}
+	return data
+}
+
+// copyBaselineFile is a helper function that copies the version 1 file from
+// testdata to a new test directory and returns its path
+func copyBaselineFile(name string) (string, error) {
+	path, err := newTestDataFilePath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile("testdata/baseline.dat")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0600)
+}
+
+// checkBaselineEntry is a helper function that opens an entry of the version 1
+// file and compares its data
+func checkBaselineEntry(t *testing.T, pm *PageManager, fileOff int64, data []byte) {
+	t.Helper()
+	entry, err := pm.Open(LegacyIdentifier(fileOff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	readData := make([]byte, entry.Size())
+	if len(readData) == 0 && len(data) == 0 {
+		return
+	}
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data) {
+		t.Errorf("data of entry at %v doesn't match", fileOff)
+	}
+}
+
+// TestMigrateV1 tests if a file of the baseline format is migrated when it is
+// opened and if its entries are migrated when they are opened
+func TestMigrateV1(t *testing.T) {
+	path, err := copyBaselineFile(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := readFormatHeader(pm.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.version != formatVersion3 || h.layout.dataPage != 1 || h.layout.idTablePage < baselinePages {
+		t.Fatalf("wrong header %+v", h)
+	}
+
+	// Only the page of the recyclingPage and its root table should be free
+	// within the original file
+	for i := int64(1); i < baselinePages; i++ {
+		free := !pm.bitmap.isSet(pm.bitmap.index(i * pageSize))
+		if free != (i == 1 || i == 15) {
+			t.Errorf("page %v should be free: %v", i, !free)
+		}
+	}
+
+	// Open the entries
+	checkBaselineEntry(t, pm, baselineEntryA, baselineDataA())
+	checkBaselineEntry(t, pm, baselineEntryB, []byte{})
+	checkBaselineEntry(t, pm, baselineEntryD, []byte{})
+	checkBaselineEntry(t, pm, baselineEntryC, []byte("c"))
+	if s := pm.Stats(); s.Entries != 4 {
+		t.Fatalf("there should be 4 entries but there were %v", s.Entries)
+	}
+
+	// Writing to the migrated entries shouldn't overwrite other entries
+	var ids []Identifier
+	for _, fileOff := range []int64{baselineEntryB, baselineEntryD} {
+		entry, err := pm.Open(LegacyIdentifier(fileOff))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(bytes.Repeat([]byte{0xff}, 2*pageSize)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, entry.identifier())
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBaselineEntry(t, pm, baselineEntryA, baselineDataA())
+	checkBaselineEntry(t, pm, baselineEntryC, []byte("c"))
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the file. Both the legacy and the new Identifiers should work.
+	pm, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	checkBaselineEntry(t, pm, baselineEntryA, baselineDataA())
+	checkBaselineEntry(t, pm, baselineEntryD, bytes.Repeat([]byte{0xff}, 2*pageSize))
+	checkBaselineEntry(t, pm, baselineEntryC, []byte("c"))
+	entry, err := pm.Open(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Size() != 2*pageSize {
+		t.Errorf("size should be %v but was %v", 2*pageSize, entry.Size())
+	}
+	entry.Close()
+	if s := pm.Stats(); s.Entries != 4 {
+		t.Fatalf("there should be 4 entries but there were %v", s.Entries)
+	}
+
+	// Deleting an entry using its legacy Identifier removes it from the
+	// idTable
+	if err := pm.Delete(LegacyIdentifier(baselineEntryC)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.Open(LegacyIdentifier(baselineEntryC)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err should be %v but was %v", ErrNotFound, err)
+	}
+}
+
+// TestMigrateV1Interrupted tests if interrupted migrations of a file and its
+// entries are continued
+func TestMigrateV1Interrupted(t *testing.T) {
+	path, err := copyBaselineFile(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages appended by an interrupted migration of the file are ignored
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, 3*pageSize), baselinePages*pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend that the migration of an entry was interrupted before it was
+	// added to the idTable
+	entry, err := pm.Open(LegacyIdentifier(baselineEntryA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := entry.identifier()
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.ids.set(id.index(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	checkBaselineEntry(t, pm, baselineEntryA, baselineDataA())
+	if s := pm.Stats(); s.Entries != 1 {
+		t.Fatalf("there should be 1 entry but there were %v", s.Entries)
+	}
+}
+
+// TestMigrateV1Corrupted tests if corrupted version 1 files are rejected
+// before anything is written
+func TestMigrateV1Corrupted(t *testing.T) {
+	// corrupt is a helper function that writes data to a copy of the version
+	// 1 file
+	corrupt := func(name string, data []byte, off int64) (string, []byte) {
+		path, err := copyBaselineFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt(data, off); err != nil {
+			t.Fatal(err)
+		}
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, fileData
+	}
+
+	// The free pages point past the end of the file
+	table := make([]byte, 16)
+	binary.LittleEndian.PutUint64(table, 1)
+	binary.PutVarint(table[8:], 100*pageSize)
+	path, data := corrupt(t.Name()+"Free", table, pageSize)
+	if _, err := New(path); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("err should be %v but was %v", ErrCorrupted, err)
+	}
+	if fileData, err := os.ReadFile(path); err != nil || !bytes.Equal(fileData, data) {
+		t.Fatal("file was modified", err)
+	}
+
+	// Entry A uses a page twice
+	table = make([]byte, 24)
+	binary.LittleEndian.PutUint64(table, 2)
+	binary.PutVarint(table[8:], 4*pageSize)
+	binary.PutVarint(table[16:], 4*pageSize)
+	path, data = corrupt(t.Name()+"Entry", table, 3*pageSize)
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if _, err := pm.Open(LegacyIdentifier(baselineEntryA)); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("err should be %v but was %v", ErrCorrupted, err)
+	}
+	entryPage := make([]byte, pageSize)
+	if _, err := pm.file.ReadAt(entryPage, baselineEntryA); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(entryPage, data[baselineEntryA:baselineEntryA+pageSize]) {
+		t.Fatal("entryPage was modified")
+	}
+
+	// Free pages and pages outside of the data area aren't entries
+	for _, fileOff := range []int64{0, 15 * pageSize, 100 * pageSize, pageSize + 1} {
+		if _, err := pm.Open(LegacyIdentifier(fileOff)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("err for offset %v should be %v but was %v", fileOff, ErrNotFound, err)
+		}
+	}
+}
+
+// TestReadV1 tests if the structures of version 1 files are read correctly
+func TestReadV1(t *testing.T) {
+	offsets := []int64{0, 3 * pageSize, 5 * pageSize}
+	data := make([]byte, 8*(len(offsets)+1))
+	binary.LittleEndian.PutUint64(data, uint64(len(offsets)))
+	for i, off := range offsets {
+		binary.PutVarint(data[8*(i+1):], off)
+	}
+	entries, err := unmarshalPageTableV1(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range offsets {
+		if entries[i] != offsets[i] {
+			t.Fatalf("entry %v should be %v but was %v", i, offsets[i], entries[i])
+		}
+	}
+	if _, err := unmarshalPageTable(make([]byte, 16), pageSize); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("version 1 table should be rejected: %v", err)
+	}
+
+	// The root is the first entry that isn't full
+	pm, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	pp, err := pm.pm.allocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryData := make([]byte, 2*tieredPageEntrySize)
+	binary.PutVarint(entryData[0:], int64(maxPages(0)*pageSize))
+	binary.PutVarint(entryData[8:], 3*pageSize)
+	binary.PutVarint(entryData[16:], int64(maxPages(0)*pageSize)+1)
+	binary.PutVarint(entryData[24:], 7*pageSize)
+	if _, err := pp.writeAt(entryData, 0); err != nil {
+		t.Fatal(err)
+	}
+	usedSize, rootOff, height, err := readTieredPageRootV1(pp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usedSize != int64(maxPages(0)*pageSize)+1 || rootOff != 7*pageSize || height != 1 {
+		t.Errorf("wrong root %v %v %v", usedSize, rootOff, height)
+	}
+
+	// A tree that is exactly full is followed by an unused entry
+	if _, err := pp.writeAt(make([]byte, tieredPageEntrySize), tieredPageEntrySize); err != nil {
+		t.Fatal(err)
+	}
+	usedSize, rootOff, height, err = readTieredPageRootV1(pp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usedSize != int64(maxPages(0)*pageSize) || rootOff != 3*pageSize || height != 0 {
+		t.Errorf("wrong root %v %v %v", usedSize, rootOff, height)
+	}
+}
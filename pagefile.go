@@ -0,0 +1,250 @@
+package pages
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+type (
+	// pageFile is the file on which the pages are stored. If encryption is
+	// enabled every page is sealed individually before it is written to disk.
+	// A sealed page starts with a header containing its write counter and the
+	// length of the sealed data, followed by the ciphertext and the tag.
+	pageFile struct {
+		*os.File
+
+		// aead is used to seal and open pages. If it is nil, pages are stored
+		// in plaintext.
+		aead cipher.AEAD
+
+		// previousAEADs are used to open pages that were sealed with a
+		// previous key. They are needed to continue an interrupted key
+		// rotation.
+		previousAEADs []cipher.AEAD
+
+		// idTablePage is the index of the page that contains the idTable's
+		// entryPage
+		idTablePage int64
+
+		// dataPage is the index of the first page of the data area
+		dataPage int64
+	}
+)
+
+var (
+	// errNotEncrypted is returned when trying to rotate the key of a file
+	// that isn't encrypted
+	errNotEncrypted = errors.New("the file is not encrypted")
+)
+
+// newAEAD creates the AEAD used to seal pages from a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, extendErr("failed to create cipher", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// newPageFile wraps a file and configures the encryption of its pages
+func newPageFile(file *os.File, key []byte, previousKeys [][]byte) (*pageFile, error) {
+	pf := &pageFile{
+		File:        file,
+		idTablePage: idTableOff / pageSize,
+		dataPage:    dataOff / pageSize,
+	}
+	if key == nil {
+		return pf, nil
+	}
+
+	// Create the ciphers
+	var err error
+	if pf.aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+	for _, previousKey := range previousKeys {
+		aead, err := newAEAD(previousKey)
+		if err != nil {
+			return nil, err
+		}
+		pf.previousAEADs = append(pf.previousAEADs, aead)
+	}
+	return pf, nil
+}
+
+// encrypted returns true if the pages of the file are encrypted
+func (f *pageFile) encrypted() bool {
+	return f.aead != nil
+}
+
+// slotSize returns the number of bytes a page occupies on disk
+func (f *pageFile) slotSize() int64 {
+	if f.encrypted() {
+		return pageSize + sealOverhead
+	}
+	return pageSize
+}
+
+// dataOff returns the offset of the data relative to the start of the file
+func (f *pageFile) dataOff() int64 {
+	return f.dataPage * f.slotSize()
+}
+
+// idTableOff returns the offset of the idTable's entryPage relative to the
+// start of the file
+func (f *pageFile) idTableOff() int64 {
+	return f.idTablePage * f.slotSize()
+}
+
+// setLayout uses the layout recorded in the format header of the file. Files
+// that don't record a layout use idTableOff and dataOff.
+func (f *pageFile) setLayout(l fileLayout) {
+	f.idTablePage, f.dataPage = idTableOff/pageSize, dataOff/pageSize
+	if l.idTablePage != 0 {
+		f.idTablePage = l.idTablePage
+	}
+	if l.dataPage != 0 {
+		f.dataPage = l.dataPage
+	}
+}
+
+// sealNonce creates the nonce for sealing a page. It consists of the lower 6
+// bytes of the page's offset followed by the lower 6 bytes of its write
+// counter which guarantees that a nonce is never reused for the same key.
+func sealNonce(fileOff int64, counter uint64) []byte {
+	nonce := make([]byte, 16)
+	binary.LittleEndian.PutUint64(nonce[:8], uint64(fileOff))
+	binary.LittleEndian.PutUint64(nonce[6:14], counter)
+	return nonce[:12]
+}
+
+// readPageData reads length bytes of data from the beginning of the page at
+// fileOff. If the file is encrypted, the page is opened and the returned data
+// might be shorter than length if less data was written to the page.
+func (f *pageFile) readPageData(fileOff int64, length int64) ([]byte, uint64, error) {
+	if !f.encrypted() {
+		data := make([]byte, length)
+		if n, err := f.ReadAt(data, fileOff); int64(n) != length {
+			return nil, 0, ioError(err, fileOff)
+		}
+		return data, 0, nil
+	}
+
+	slot := make([]byte, f.slotSize())
+	if _, err := f.ReadAt(slot, fileOff); err != nil {
+		return nil, 0, ioError(err, fileOff)
+	}
+	return f.openPage(slot, fileOff)
+}
+
+// openPage opens a sealed page and returns its data and its write counter.
+// Pages that were never sealed have a counter of 0 and no data.
+func (f *pageFile) openPage(slot []byte, fileOff int64) ([]byte, uint64, error) {
+	// Decode the header
+	counter := binary.LittleEndian.Uint64(slot[:8]) & maxSealCounter
+	length := int64(binary.LittleEndian.Uint16(slot[6:8]))
+	if counter == 0 {
+		return nil, 0, nil
+	}
+	if length > pageSize {
+		return nil, 0, pageError(ErrCorrupted, fileOff, "sealed page has invalid length %v", length)
+	}
+
+	// Try to open the page using the current key first
+	nonce := sealNonce(fileOff, counter)
+	sealed := slot[sealHeaderSize : sealHeaderSize+length+sealTagSize]
+	data, err := f.aead.Open(nil, nonce, sealed, slot[:sealHeaderSize])
+	for i := 0; err != nil && i < len(f.previousAEADs); i++ {
+		data, err = f.previousAEADs[i].Open(nil, nonce, sealed, slot[:sealHeaderSize])
+	}
+	if err != nil {
+		return nil, 0, pageError(ErrCorrupted, fileOff, "failed to open page: %v", err)
+	}
+	return data, counter, nil
+}
+
+// writePageData writes data to the beginning of the page at fileOff. If the
+// file is encrypted, the page is sealed using its incremented write counter.
+func (f *pageFile) writePageData(fileOff int64, data []byte) error {
+	if !f.encrypted() {
+		_, err := f.WriteAt(data, fileOff)
+		return ioError(err, fileOff)
+	}
+
+	// Get the current write counter of the page
+	header := make([]byte, sealHeaderSize)
+	if _, err := f.ReadAt(header, fileOff); err != nil {
+		return ioError(err, fileOff)
+	}
+	counter := binary.LittleEndian.Uint64(header) & maxSealCounter
+	return f.sealPage(f.aead, fileOff, counter+1, data)
+}
+
+// sealPage seals data using aead and writes it to the page at fileOff
+func (f *pageFile) sealPage(aead cipher.AEAD, fileOff int64, counter uint64, data []byte) error {
+	if counter > maxSealCounter {
+		return pageError(errors.New("write counter overflowed"), fileOff, "")
+	}
+	if len(data) > pageSize {
+		return critical("can't seal %v bytes", len(data))
+	}
+
+	// Create the header
+	header := make([]byte, sealHeaderSize)
+	binary.LittleEndian.PutUint64(header, counter)
+	binary.LittleEndian.PutUint16(header[6:8], uint16(len(data)))
+
+	// Seal the data and write it to disk
+	slot := make([]byte, sealHeaderSize, int64(len(data))+sealOverhead)
+	copy(slot, header)
+	slot = aead.Seal(slot, sealNonce(fileOff, counter), data, header)
+	_, err := f.WriteAt(slot, fileOff)
+	return ioError(err, fileOff)
+}
+
+// rekey seals every page of the file using a new key
+func (f *pageFile) rekey(key []byte) error {
+	if !f.encrypted() {
+		return errNotEncrypted
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	// Get the size of the file
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// Reseal all the pages
+	slot := make([]byte, f.slotSize())
+	for fileOff := int64(0); fileOff+f.slotSize() <= size; fileOff += f.slotSize() {
+		if _, err := f.ReadAt(slot, fileOff); err != nil {
+			return ioError(err, fileOff)
+		}
+		data, counter, err := f.openPage(slot, fileOff)
+		if err != nil {
+			return err
+		}
+		if counter == 0 {
+			continue
+		}
+		if err := f.sealPage(aead, fileOff, counter+1, data); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// All the pages are sealed with the new key now
+	f.aead = aead
+	f.previousAEADs = nil
+	return nil
+}
@@ -0,0 +1,259 @@
+package pages
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestSealPage tests if sealing and opening pages works as expected
+func TestSealPage(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(dataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := newPageFile(file, fastrand.Bytes(32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+
+	// Reading a page that was never written should return no data
+	if _, err := pf.WriteAt(make([]byte, 2*pf.slotSize()), 0); err != nil {
+		t.Fatal(err)
+	}
+	data, counter, err := pf.readPageData(pf.slotSize(), pageSize)
+	if err != nil {
+		t.Fatalf("Failed to read empty page: %v", err)
+	}
+	if len(data) != 0 || counter != 0 {
+		t.Errorf("Empty page should have no data and counter 0 but had %v bytes and counter %v",
+			len(data), counter)
+	}
+
+	// Write some data to the page and read it again
+	pageData := fastrand.Bytes(100)
+	for i := uint64(1); i <= 3; i++ {
+		if err := pf.writePageData(pf.slotSize(), pageData); err != nil {
+			t.Fatalf("Failed to write page: %v", err)
+		}
+		data, counter, err = pf.readPageData(pf.slotSize(), pageSize)
+		if err != nil {
+			t.Fatalf("Failed to read page: %v", err)
+		}
+		if bytes.Compare(data, pageData) != 0 {
+			t.Error("Read data doesn't match written data")
+		}
+		if counter != i {
+			t.Errorf("counter should be %v but was %v", i, counter)
+		}
+	}
+
+	// The data shouldn't be stored in plaintext
+	raw := make([]byte, pf.slotSize())
+	if _, err := pf.ReadAt(raw, pf.slotSize()); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, pageData) {
+		t.Error("Page was stored in plaintext")
+	}
+
+	// Tampering with the page should be detected
+	if _, err := pf.WriteAt([]byte{raw[sealHeaderSize] + 1}, pf.slotSize()+sealHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pf.readPageData(pf.slotSize(), pageSize); err == nil {
+		t.Error("Reading a modified page should fail")
+	}
+}
+
+// TestEncryptedEntry tests if data written to an encrypted PageManager can be
+// recovered using the right key and if rotating the key works as expected
+func TestEncryptedEntry(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := fastrand.Bytes(32)
+
+	// Create an encrypted PageManager
+	pm, err := NewWithOptions(dataFilePath, Options{EncryptionKey: key, Compression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, identifier, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write some data, free some pages and close the PageManager
+	data := append(compressibleData(10*pageSize), fastrand.Bytes(10*pageSize)...)
+	if _, err := entry.Write(append(data, data...)); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+	if err := entry.Truncate(int64(len(data))); err != nil {
+		t.Fatalf("Failed to truncate entry: %v", err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file shouldn't contain the data in plaintext
+	fileData, err := ioutil.ReadFile(dataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(fileData, data[:100]) || bytes.Contains(fileData, data[len(data)-100:]) {
+		t.Error("File contains plaintext data")
+	}
+
+	// Recovering without the right key should fail
+	if _, err := NewWithOptions(dataFilePath, Options{EncryptionKey: fastrand.Bytes(32)}); err == nil {
+		t.Error("Recovering with the wrong key should fail")
+	}
+
+	// Recover with the right key and compare the data
+	readEntry := func(pm *PageManager) {
+		entry, err := pm.Open(identifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readData := make([]byte, len(data))
+		if _, err := entry.ReadAt(readData, 0); err != nil {
+			t.Fatalf("Failed to read data: %v", err)
+		}
+		if bytes.Compare(data, readData) != 0 {
+			t.Error("Read data doesn't match written data")
+		}
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pm, err = NewWithOptions(dataFilePath, Options{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readEntry(pm)
+
+	// The data should still be readable while a new key is used with the old
+	// key as one of the previous keys
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	newKey := fastrand.Bytes(32)
+	pm, err = NewWithOptions(dataFilePath, Options{EncryptionKey: newKey, PreviousKeys: [][]byte{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readEntry(pm)
+
+	// Rotate the key
+	if err := pm.Rekey(newKey); err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+	readEntry(pm)
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old key shouldn't work anymore
+	if _, err := NewWithOptions(dataFilePath, Options{EncryptionKey: key}); err == nil {
+		t.Error("Recovering with the old key should fail")
+	}
+	pm, err = NewWithOptions(dataFilePath, Options{EncryptionKey: newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	readEntry(pm)
+}
+
+// TestRekeyConcurrentWrites tests if keys can be rotated while entries are
+// written concurrently
+func TestRekeyConcurrentWrites(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := fastrand.Bytes(32)
+	pm, err := NewWithOptions(dataFilePath, Options{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Grow some entries while the key is rotated repeatedly
+	numEntries := 4
+	ids := make([]Identifier, numEntries)
+	data := make([][]byte, numEntries)
+	var wg sync.WaitGroup
+	for i := range ids {
+		var entry *Entry
+		entry, ids[i], err = pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer entry.Close()
+			for j := 0; j < 20; j++ {
+				b := fastrand.Bytes(pageSize / 2)
+				if _, err := entry.Write(b); err != nil {
+					t.Error(err)
+					return
+				}
+				data[i] = append(data[i], b...)
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			key = fastrand.Bytes(32)
+			if err := pm.Rekey(key); err != nil {
+				t.Error(err)
+				break
+			}
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("writes and Rekey deadlocked")
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The data should be readable using the last key
+	pm, err = NewWithOptions(dataFilePath, Options{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	for i, id := range ids {
+		entry, err := pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readData := make([]byte, len(data[i]))
+		if _, err := entry.ReadAt(readData, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[i], readData) {
+			t.Fatalf("data of entry %v doesn't match", i)
+		}
+		entry.Close()
+	}
+}
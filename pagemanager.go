@@ -1,54 +1,190 @@
 package pages
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io"
-	"math"
 	"os"
+	"sort"
 	"sync"
+)
 
-	"github.com/NebulousLabs/Sia/build"
+var (
+	// ErrEntryOpen is returned when trying to delete an entry that is still
+	// open
+	ErrEntryOpen = errors.New("entry is still open")
 )
 
-// Identifier is a helper type that can be used to reopen a previously created
-// entry
-type Identifier int64
+// Options are used to configure a PageManager
+type Options struct {
+	// Compression enables transparent compression of data pages. Every page
+	// is compressed individually to preserve random access. Pages that were
+	// compressed before remain readable if Compression is disabled.
+	Compression bool
+
+	// EncryptionKey is the AES key used to encrypt every page of the file
+	// including the metadata. It needs to be 16, 24 or 32 bytes long. If it
+	// is nil, the file is not encrypted.
+	EncryptionKey []byte
+
+	// PreviousKeys are keys that are used to decrypt pages which were
+	// encrypted before the key was rotated using Rekey. They are only needed
+	// to recover from an interrupted key rotation.
+	PreviousKeys [][]byte
+
+	// SyncPolicy determines when written data is synced to disk. The default
+	// is SyncNever.
+	SyncPolicy SyncPolicy
+
+	// Allocator selects the free pages that are reused for new pages. The
+	// default is AllocateLIFO.
+	Allocator Allocator
+
+	// Metrics receives the metrics of the PageManager. Metrics can't be
+	// collected if it is nil.
+	Metrics MetricsHook
+
+	// Tracer receives spans around the operations of the PageManager. No
+	// spans are created if it is nil.
+	Tracer Tracer
+
+	// MaxFileSize is the maximum size of the file in bytes. Allocating pages
+	// that would grow the file beyond it fails with ErrQuotaExceeded. The
+	// size isn't limited if it is 0.
+	MaxFileSize int64
+}
 
 // PageManager blabla
 type PageManager struct {
-	// file is the underlying file to which data is written
-	file *os.File
+	// atomicDataPages is the number of data pages of all the entries. It is
+	// the first field to make sure it's 64-bit aligned.
+	atomicDataPages int64
 
-	// freePages contains the pages that can be reused for new data
-	freePages *recyclingPage
+	// file is the underlying file to which data is written
+	file *pageFile
 
-	// TODO find a better way to do this
-	// recyclePages is used to indicate if it is safe to reuse free pages. This
-	// is used as a workaround to disable page recycling while pages are being
-	// inserted into freePages
-	recyclePages bool
+	// bitmap keeps track of the pages that can be reused for new data
+	bitmap *bitmap
 
 	// mu is a mutex to lock the PageManager's ressources
 	mu *sync.Mutex
 
 	// entryPages keeps track of all the entryPages
 	entryPages map[Identifier]*entryPage
+
+	// ids maps the Identifiers of entries to their entryPages
+	ids *idTable
+
+	// watchers are the subscribers to the events of entries. They are
+	// protected by watchMu.
+	watchers map[Identifier]map[*watcher]struct{}
+	watchMu  *sync.Mutex
+
+	// opts are the options the PageManager was created with
+	opts Options
+
+	// syncer syncs the file according to the SyncPolicy
+	syncer *syncer
+
+	// metrics reports the metrics of the PageManager
+	metrics *metrics
+
+	// closed is set once the PageManager was closed. Afterwards its methods
+	// and the methods of its entries return ErrClosed. It is protected by
+	// closeMu.
+	closed  bool
+	closeMu *sync.Mutex
+
+	// inFlight counts the operations that are running. Close waits for them
+	// to finish.
+	inFlight *sync.WaitGroup
 }
 
 // allocatePage either returns a free page or allocates a page and adds
 // it to the pages map.
 func (p *PageManager) allocatePage() (*physicalPage, error) {
+	return p.allocatePageAfter(-1)
+}
+
+// allocatePageAfter allocates a page that follows the page at prev within an
+// entry. The Allocator decides which free page is used. prev is -1 if the
+// page doesn't follow another page.
+func (p *PageManager) allocatePageAfter(prev int64) (page *physicalPage, err error) {
+	span := p.startSpan(SpanAllocatePage, SpanInfo{PageOffset: prev, Bytes: pageSize})
+	defer func() {
+		info := SpanInfo{PageOffset: -1}
+		if page != nil {
+			info = SpanInfo{PageOffset: page.fileOff, Bytes: pageSize}
+		}
+		span.End(info, err)
+	}()
+
 	// If there are free pages available return one of those
-	var newPage *physicalPage
-	if p.recyclePages && p.freePages != nil && p.freePages.availablePages() > 0 {
-		removedPage, err := p.freePages.freePage()
-		if err != nil {
-			return nil, build.ExtendErr("Failed to reuse free page", err)
+	if p.bitmap != nil && p.bitmap.Len() > 0 {
+		if off, ok := p.allocator().Allocate(p.bitmap, prev); ok {
+			page, err := p.bitmap.take(off)
+			if err != nil {
+				return nil, extendErr("Failed to reuse free page", err)
+			}
+			p.metrics.add(MetricPagesAllocatedRecycled, 1)
+			return page, nil
+		}
+	}
+	return p.appendPage()
+}
+
+// allocatePagesAfter allocates n pages that follow the page at prev within an
+// entry. If the Allocator is a RunAllocator, it might select a run of n
+// contiguous free pages. Otherwise the pages are allocated one by one.
+func (p *PageManager) allocatePagesAfter(n int, prev int64) (pages []*physicalPage, err error) {
+	// Free the allocated pages if not all of them could be allocated. The
+	// original error is returned even if that fails.
+	defer func() {
+		if err != nil && len(pages) > 0 {
+			_ = p.bitmap.free(pages)
+			pages = nil
+		}
+	}()
+
+	// Make sure all the pages can be allocated
+	if !p.fitsQuota(int64(n)) {
+		return nil, ErrQuotaExceeded
+	}
+
+	// Try to find a run of free pages
+	if ra, ok := p.allocator().(RunAllocator); ok && n > 1 && p.bitmap.Len() >= n {
+		if off, ok := ra.AllocateRun(p.bitmap, prev, n); ok {
+			span := p.startSpan(SpanAllocatePage, SpanInfo{PageOffset: prev, Bytes: int64(n) * pageSize})
+			defer func() {
+				span.End(SpanInfo{PageOffset: off, Bytes: int64(len(pages)) * pageSize}, err)
+			}()
+			for i := 0; i < n; i++ {
+				page, err := p.bitmap.take(off + int64(i)*p.file.slotSize())
+				if err != nil {
+					return pages, extendErr("Failed to reuse free page", err)
+				}
+				pages = append(pages, page)
+			}
+			p.metrics.add(MetricPagesAllocatedRecycled, int64(n))
+			return pages, nil
 		}
-		return removedPage, nil
+	}
 
+	// Allocate the pages one by one
+	for len(pages) < n {
+		page, err := p.allocatePageAfter(prev)
+		if err != nil {
+			return pages, err
+		}
+		pages = append(pages, page)
+		prev = page.fileOff
 	}
+	return pages, nil
+}
 
+// appendPage appends a new page to the end of the file and marks it as
+// allocated
+func (p *PageManager) appendPage() (*physicalPage, error) {
 	// Get the fileOff for the page
 	fileOff, err := p.file.Seek(0, io.SeekEnd)
 	if err != nil {
@@ -57,68 +193,139 @@ func (p *PageManager) allocatePage() (*physicalPage, error) {
 
 	// The last page might not have pageSize yet so we might have to adjust the
 	// offset a bit
-	if fileOff%pageSize != 0 {
-		fileOff += (pageSize - fileOff%pageSize)
+	slotSize := p.file.slotSize()
+	if fileOff%slotSize != 0 {
+		fileOff += (slotSize - fileOff%slotSize)
 	}
 
 	// Don't start before dataOff
-	if fileOff < dataOff {
-		fileOff = dataOff
+	if fileOff < p.file.dataOff() {
+		fileOff = p.file.dataOff()
+	}
+
+	// Make sure the offset can be stored in a pageTable
+	if fileOff+slotSize > maxFileSize {
+		return nil, pageError(ErrOutOfSpace, fileOff, "reached maximum file size")
+	}
+	if p.opts.MaxFileSize > 0 && fileOff+slotSize > p.opts.MaxFileSize {
+		return nil, pageError(ErrQuotaExceeded, fileOff, "reached MaxFileSize")
 	}
 
 	// Create the new page and write it to disk
-	newPage = &physicalPage{
+	newPage := &physicalPage{
 		file:    p.file,
 		fileOff: fileOff,
 	}
 
 	// TODO maybe remove this but if we do we have to fix the way we calculate
 	// the fileOff for new pages
-	n, err := newPage.file.WriteAt(make([]byte, pageSize, pageSize), newPage.fileOff)
-	if n != pageSize || err != nil {
-		return nil, fmt.Errorf("couldn't write new page wrote %v bytes %v", n, err)
+	if _, err := newPage.file.WriteAt(make([]byte, slotSize, slotSize), newPage.fileOff); err != nil {
+		return nil, extendErr("couldn't write new page", ioError(err, newPage.fileOff))
 	}
 
+	// Mark the page as allocated
+	if p.bitmap != nil {
+		if err := p.bitmap.allocate(newPage.fileOff); err != nil {
+			return nil, extendErr("failed to mark page as allocated", err)
+		}
+	}
+	p.metrics.add(MetricPagesAllocatedFresh, 1)
 	return newPage, nil
 }
 
-// Close closes open handles and frees ressources
-func (p PageManager) Close() error {
+// Close waits for the running operations of the PageManager and its entries
+// to finish, syncs the file and closes it. Afterwards the methods of the
+// PageManager and its entries return ErrClosed. The metadata of the file is
+// written when it changes, so it is complete once the file is synced.
+func (p *PageManager) Close() error {
+	p.closeMu.Lock()
+	if p.closed {
+		p.closeMu.Unlock()
+		return ErrClosed
+	}
+	p.closed = true
+	p.closeMu.Unlock()
+	p.inFlight.Wait()
+
+	// Close the watchers and forget the open entries
+	p.watchMu.Lock()
+	ids := make([]Identifier, 0, len(p.watchers))
+	for id := range p.watchers {
+		ids = append(ids, id)
+	}
+	p.watchMu.Unlock()
+	for _, id := range ids {
+		p.closeWatchers(id, nil)
+	}
+	p.lock()
+	p.entryPages = make(map[Identifier]*entryPage)
+	p.mu.Unlock()
+
+	if err := p.syncer.close(); err != nil {
+		p.file.Close()
+		return extendErr("failed to sync file", err)
+	}
 	return p.file.Close()
 }
 
 // Create creates a new Entry and returns an identifier for it
 func (p *PageManager) Create() (*Entry, Identifier, error) {
-	p.mu.Lock()
+	if err := p.enter(); err != nil {
+		return nil, Identifier{}, err
+	}
+	defer p.exit()
+	p.lock()
 	defer p.mu.Unlock()
 
 	// Allocate a page for the table
 	pp, err := p.allocatePage()
 	if err != nil {
-		return nil, 0, build.ExtendErr("failed to allocate page for new entryPage", err)
+		return nil, Identifier{}, extendErr("failed to allocate page for new entryPage", err)
 	}
 
 	// Create the first pageTable
 	root, err := newPageTable(0, nil, p)
 	if err != nil {
-		return nil, 0, build.ExtendErr("Couldn't create new pageTable", err)
+		return nil, Identifier{}, extendErr("Couldn't create new pageTable", err)
 	}
 
 	// Create the entryPage
 	ep := &entryPage{
-		&tieredPage{
+		tieredPage: &tieredPage{
 			pp:   pp,
 			pm:   p,
 			root: root,
 			mu:   new(sync.RWMutex),
 		},
-		0,
+		attrs:           make(map[string][]byte),
+		merkleRootValid: true,
+		merkleMu:        new(sync.Mutex),
 	}
 
 	// Initialize entryPage
-	if err := writeTieredPageEntry(pp, 0, 0, ep.pp.fileOff); err != nil {
-		return nil, 0, err
+	if err := writeTieredPageRoot(pp, 0, 0, ep.root.pp.fileOff); err != nil {
+		return nil, Identifier{}, err
+	}
+	if err := writeMerkleRoot(pp, Hash{}, true); err != nil {
+		return nil, Identifier{}, err
+	}
+	if err := writeMerkleLeavesOff(pp, 0); err != nil {
+		return nil, Identifier{}, err
+	}
+	nonce := newEntryNonce()
+	if err := writeEntryHeader(pp, nonce); err != nil {
+		return nil, Identifier{}, err
+	}
+	if err := writeAttrs(pp, ep.attrs); err != nil {
+		return nil, Identifier{}, err
+	}
+
+	// Add the entryPage to the idTable
+	index, err := p.ids.add(pp.fileOff)
+	if err != nil {
+		return nil, Identifier{}, extendErr("failed to add entry to idTable", err)
 	}
+	ep.id = newIdentifier(index, nonce)
 
 	// Create a new entry
 	newEntry := &Entry{
@@ -127,136 +334,280 @@ func (p *PageManager) Create() (*Entry, Identifier, error) {
 	}
 
 	// Increment the entryPage's counter and add it to the map
-	id := Identifier(ep.pp.fileOff)
-	p.entryPages[id] = ep
+	p.entryPages[ep.id] = ep
 	ep.instanceCounter++
 
-	return newEntry, id, nil
+	return newEntry, ep.id, nil
 }
 
-// loadFreePagesFromDisk loads the offsets of free pages from the first page of
-// the file.
-func (p *PageManager) loadFreePagesFromDisk() error {
-	// Create the physicalPage object using the identifier. We don't know
-	// usedSize yet but for the entryPage we can just set it to pageSize
-	pp := &physicalPage{
-		file:     p.file,
-		fileOff:  freeOff,
-		usedSize: pageSize,
-	}
-
-	// Read all the entries from the entryPage and remember the root and usedSize
-	rootOff := int64(0)
-	usedSize := int64(0)
-	height := int64(0)
-	var err error
-	for i := 0; i < pageSize/tieredPageEntrySize; i++ {
-		usedSize, rootOff, err = readEntryPageEntry(pp, int64(i))
-		if err != nil {
-			return build.ExtendErr("Failed to read entry", err)
-		}
-		// Remember the reached height
-		height = int64(i)
+// managedAllocatePage either returns a free page or allocates a page that
+// follows the page at prev.
+func (p *PageManager) managedAllocatePage(prev int64) (*physicalPage, error) {
+	p.lock()
+	defer p.mu.Unlock()
+	return p.allocatePageAfter(prev)
+}
 
-		// Stop if we find a root that isn't full yet
-		numPages := int64(math.Pow(float64(numPageEntries), float64(i+1)))
-		if usedSize < numPages*pageSize {
-			break
-		}
+// managedAllocatePages allocates n pages that follow the page at prev. If
+// reserve isn't nil, it is called with the allocated pages before the lock is
+// released to allocate the pages that storing them requires. If it fails, the
+// pages are freed again and its error is returned even if that fails.
+func (p *PageManager) managedAllocatePages(n int, prev int64, reserve func([]*physicalPage) error) ([]*physicalPage, error) {
+	p.lock()
+	defer p.mu.Unlock()
+	pages, err := p.allocatePagesAfter(n, prev)
+	if err != nil || reserve == nil {
+		return pages, err
 	}
-
-	// Create the entryPage object and recover the tree.
-	ep := &recyclingPage{
-		&tieredPage{
-			pp:       pp,
-			usedSize: usedSize,
-			pm:       p,
-			mu:       new(sync.RWMutex),
-		},
-		nil,
+	if err := reserve(pages); err != nil {
+		_ = p.bitmap.free(pages)
+		return nil, err
 	}
+	return pages, nil
+}
 
-	// Recover the tree to get the pages of the entry
-	if err := ep.recoverTree(rootOff, height); err != nil {
-		return build.ExtendErr("Failed to recover tree", err)
-	}
+// managedFreePages marks pages as free so they can be reused
+func (p *PageManager) managedFreePages(pages []*physicalPage) error {
+	p.lock()
+	defer p.mu.Unlock()
+	return p.bitmap.free(pages)
+}
 
-	p.freePages = ep
+// enter registers a running operation. It returns ErrClosed if the
+// PageManager was closed. Every successful call needs to be followed by a call
+// to exit once the operation is done.
+func (p *PageManager) enter() error {
+	p.closeMu.Lock()
+	defer p.closeMu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.inFlight.Add(1)
 	return nil
+}
 
+// exit marks an operation that was registered with enter as done
+func (p *PageManager) exit() {
+	p.inFlight.Done()
 }
 
-// managedAllocatePage either returns a free page or allocates a page and adds
-// it to the pages map.
-func (p *PageManager) managedAllocatePage() (*physicalPage, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.allocatePage()
+// lock acquires the PageManager's mu and records the time spent waiting for
+// it
+func (p *PageManager) lock() {
+	p.metrics.wait(MetricManagerLockWait, p.mu.Lock)
+}
+
+// syncFile syncs the file and records the duration of the sync
+func (p *PageManager) syncFile() error {
+	start := p.metrics.start()
+	err := p.file.Sync()
+	p.metrics.observe(MetricSyncDuration, start)
+	return err
+}
+
+// allocator returns the Allocator of the PageManager
+func (p *PageManager) allocator() Allocator {
+	if p.opts.Allocator == nil {
+		return AllocateLIFO
+	}
+	return p.opts.Allocator
 }
 
 // New creates a PageManager or recovers an existing one
 func New(filePath string) (*PageManager, error) {
+	return NewWithOptions(filePath, Options{})
+}
+
+// NewWithOptions creates a PageManager with custom options or recovers an
+// existing one
+func NewWithOptions(filePath string, opts Options) (*PageManager, error) {
 	// Create the page manager object
 	pm := &PageManager{
-		mu:           new(sync.Mutex),
-		entryPages:   make(map[Identifier]*entryPage),
-		recyclePages: true,
+		mu:         new(sync.Mutex),
+		entryPages: make(map[Identifier]*entryPage),
+		watchers:   make(map[Identifier]map[*watcher]struct{}),
+		watchMu:    new(sync.Mutex),
+		opts:       opts,
+		metrics:    newMetrics(opts.Metrics),
+		closeMu:    new(sync.Mutex),
+		inFlight:   new(sync.WaitGroup),
 	}
 
 	// Try to open the database file
 	file, err := os.OpenFile(filePath, os.O_RDWR, 0600)
 	if err == nil {
 		// There is a file that can be recovered
-		pm.file = file
+		pm.file, err = newPageFile(file, opts.EncryptionKey, opts.PreviousKeys)
+		if err != nil {
+			file.Close()
+			return nil, extendErr("failed to set up encryption", err)
+		}
+
+		// Migrate files using an older format
+		h, err := readFormatHeader(pm.file)
+		if err != nil {
+			file.Close()
+			return nil, extendErr("failed to read format header", err)
+		}
+		pm.file.setLayout(h.layout)
+		if h.version == formatVersion1 {
+			if err := pm.migrateV1(); err != nil {
+				file.Close()
+				return nil, extendErr("failed to migrate file", err)
+			}
+		} else if h.version == formatVersion2 {
+			if err := pm.migrateFreeSpace(h); err != nil {
+				file.Close()
+				return nil, extendErr("failed to migrate free pages", err)
+			}
+		}
 
-		// Load the freePages
-		if err := pm.loadFreePagesFromDisk(); err != nil {
-			return nil, build.ExtendErr("failed to read free pages", err)
+		// Load the bitmap and the idTable
+		if pm.bitmap, err = loadBitmap(pm); err != nil {
+			file.Close()
+			return nil, extendErr("failed to read bitmap", err)
 		}
+		if pm.ids, err = loadIDTable(pm); err != nil {
+			file.Close()
+			return nil, extendErr("failed to read idTable", err)
+		}
+		if err := pm.countDataPages(); err != nil {
+			file.Close()
+			return nil, extendErr("failed to count data pages", err)
+		}
+		pm.syncer = newSyncer(pm.syncFile, opts.SyncPolicy)
 		return pm, nil
 	} else if !os.IsNotExist(err) {
 		// The file exists but cannot be opened
-		return nil, build.ExtendErr("Failed to open existing database file", err)
+		return nil, extendErr("Failed to open existing database file", err)
 	}
 
 	// If the file doesn't exist create a new one
 	file, err = os.Create(filePath)
 	if err != nil {
-		return nil, build.ExtendErr("Failed to create the database file: %v", err)
+		return nil, extendErr("Failed to create the database file", err)
 	}
-	pm.file = file
-
-	// Create the pageEntry for the free pages.
-	root, err := newPageTable(0, nil, pm)
+	pm.file, err = newPageFile(file, opts.EncryptionKey, opts.PreviousKeys)
 	if err != nil {
-		return nil, build.ExtendErr("Failed to create pageTable for recycling page", err)
+		file.Close()
+		return nil, extendErr("failed to set up encryption", err)
 	}
-	rp := &recyclingPage{
-		&tieredPage{
-			pm:   pm,
-			root: root,
-			mu:   new(sync.RWMutex),
-			pp: &physicalPage{
-				file:     pm.file,
-				fileOff:  freeOff,
-				usedSize: pageSize,
-			},
-		},
-		nil,
+
+	// Create the bitmap for the free pages
+	if _, err := newBitmap(pm); err != nil {
+		file.Close()
+		return nil, extendErr("Failed to create bitmap", err)
+	}
+	if err := writeFormatHeader(pm.file, formatHeader{version: formatVersion3}); err != nil {
+		file.Close()
+		return nil, extendErr("Failed to write format header", err)
+	}
+
+	// Create the idTable
+	if pm.ids, err = newIDTable(pm); err != nil {
+		file.Close()
+		return nil, err
 	}
-	pm.freePages = rp
 
+	pm.syncer = newSyncer(pm.syncFile, opts.SyncPolicy)
 	return pm, nil
 }
 
+// Rekey encrypts all the pages of the file using a new key. If rotating the
+// key is interrupted, the PageManager needs to be recovered with the new
+// EncryptionKey and the old key as one of its PreviousKeys before calling
+// Rekey again.
+func (p *PageManager) Rekey(key []byte) error {
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.exit()
+
+	// Make sure no entries are accessed while the pages are rewritten
+	eps := p.lockOpenEntries()
+	defer p.mu.Unlock()
+	for _, ep := range eps {
+		defer ep.mu.Unlock()
+	}
+	p.bitmap.mu.Lock()
+	defer p.bitmap.mu.Unlock()
+
+	return p.file.rekey(key)
+}
+
+// lockOpenEntries acquires the write locks of all the open entries and p.mu.
+// Operations on entries acquire p.mu while holding the entry's lock, so the
+// entries are locked first and sorted by their offsets to make sure
+// concurrent callers lock them in the same order. If the open entries change
+// before p.mu is acquired, the locks are released and it starts over.
+func (p *PageManager) lockOpenEntries() []*entryPage {
+	for {
+		p.lock()
+		eps := make([]*entryPage, 0, len(p.entryPages))
+		for _, ep := range p.entryPages {
+			eps = append(eps, ep)
+		}
+		p.mu.Unlock()
+		sort.Slice(eps, func(i, j int) bool {
+			return eps[i].pp.fileOff < eps[j].pp.fileOff
+		})
+		for _, ep := range eps {
+			ep.lock()
+		}
+
+		// Check if the entries are still the open ones
+		p.lock()
+		unchanged := len(eps) == len(p.entryPages)
+		for _, ep := range eps {
+			unchanged = unchanged && p.entryPages[ep.id] == ep
+		}
+		if unchanged {
+			return eps
+		}
+		p.mu.Unlock()
+		for _, ep := range eps {
+			ep.mu.Unlock()
+		}
+	}
+}
+
 // Open loads a previously created entry
 func (p *PageManager) Open(id Identifier) (*Entry, error) {
-	p.mu.Lock()
+	return p.OpenContext(context.Background(), id)
+}
+
+// OpenContext loads a previously created entry. If id doesn't point to an
+// existing entry, ErrNotFound is returned. Loading the pageTables of a large
+// entry can take a while. If ctx is cancelled before the entry is loaded,
+// ctx.Err() is returned. Entries of version 1 files are opened using
+// LegacyIdentifier.
+func (p *PageManager) OpenContext(ctx context.Context, id Identifier) (entry *Entry, err error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.exit()
+	p.lock()
 	defer p.mu.Unlock()
 
+	span := p.startSpan(SpanOpen, SpanInfo{ID: id, PageOffset: -1})
+	info := SpanInfo{ID: id, PageOffset: -1}
+	defer func() {
+		span.End(info, err)
+	}()
+
+	// Look up the Identifier of a legacy entry
+	if id.index() == legacyIndex {
+		legacyID := id
+		if id, err = p.legacyEntry(ctx, int64(id.nonce())); err != nil {
+			return nil, withIdentifier(err, legacyID)
+		}
+	}
+
 	// Check if the identifier was opened before
 	if ep, exists := p.entryPages[id]; exists {
 		// Increase the instance counter of the entryPage
+		p.metrics.add(MetricCacheHits, 1)
+		info.PageOffset = ep.pp.fileOff
 		ep.instanceCounter++
 		return &Entry{
 			pm: p,
@@ -264,49 +615,86 @@ func (p *PageManager) Open(id Identifier) (*Entry, error) {
 		}, nil
 	}
 
-	// Create the physicalPage object using the identifier. We don't know
-	// usedSize yet but for the entryPage we can just set it to pageSize
+	// Look up the entryPage of the entry
+	p.metrics.add(MetricCacheMisses, 1)
+	fileOff, err := p.entryPageOffset(id)
+	if err != nil {
+		return nil, withIdentifier(err, id)
+	}
+
+	// Create the physicalPage object using the offset. We don't know usedSize
+	// yet but for the entryPage we can just set it to pageSize
 	pp := &physicalPage{
 		file:     p.file,
-		fileOff:  int64(id),
+		fileOff:  fileOff,
 		usedSize: pageSize,
 	}
 
-	// Read all the entries from the entryPage and remember the root and usedSize
-	rootOff := int64(0)
-	usedSize := int64(0)
-	height := int64(0)
-	var err error
-	for i := 0; i < pageSize/tieredPageEntrySize; i++ {
-		usedSize, rootOff, err = readEntryPageEntry(pp, int64(i))
-		if err != nil {
-			return nil, build.ExtendErr("Failed to read entry", err)
-		}
+	// Read all the entries from the entryPage and remember the root and usedSize.
+	// If the entries still use the version 1 format, the tree is migrated.
+	usedSize, rootOff, height, err := readTieredPageRoot(pp)
+	legacy := errors.Is(err, errEntriesV1)
+	if err != nil && !legacy {
+		return nil, extendErr("Failed to read entry", withIdentifier(err, id))
+	}
+	info.PageOffset, info.Bytes = fileOff, usedSize
 
-		// Remember the reached height
-		height = int64(i)
+	// Check if the stored Merkle root is still valid
+	_, merkleRootValid, err := readMerkleRoot(pp)
+	if err != nil {
+		return nil, extendErr("Failed to read Merkle root", withIdentifier(err, id))
+	}
 
-		// Stop if we find a root that isn't full yet
-		numPages := int64(math.Pow(float64(numPageEntries), float64(i+1)))
-		if usedSize < numPages*pageSize {
-			break
-		}
+	// Read the attributes
+	attrs, err := readAttrs(pp)
+	if err != nil {
+		return nil, extendErr("Failed to read attributes", withIdentifier(err, id))
 	}
 
 	// Create the entryPage object and recover the tree.
 	ep := &entryPage{
-		&tieredPage{
+		tieredPage: &tieredPage{
 			pp:       pp,
 			usedSize: usedSize,
 			pm:       p,
+			id:       id,
 			mu:       new(sync.RWMutex),
 		},
-		0,
+		attrs:           attrs,
+		merkleRootValid: merkleRootValid,
+		merkleMu:        new(sync.Mutex),
 	}
 
 	// Recover the tree to get the pages of the entry
-	if err := ep.recoverTree(rootOff, height); err != nil {
-		return nil, build.ExtendErr("Failed to recover tree", err)
+	if legacy {
+		err = ep.loadTreeV1(ctx)
+		if err == nil {
+			err = p.migrateTreeV1(ep.tieredPage, nil)
+		}
+		info.Bytes = ep.usedSize
+	} else {
+		err = ep.recoverTree(ctx, rootOff, height)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, extendErr("Failed to recover tree", withIdentifier(err, id))
+	}
+	if p.opts.Compression {
+		ep.compressPages(ep.root)
+	}
+
+	// Load the stored leaves of the Merkle tree
+	leavesOff, err := readMerkleLeavesOff(pp)
+	if err != nil {
+		return nil, extendErr("Failed to read Merkle leaves offset", withIdentifier(err, id))
+	}
+	if leavesOff != 0 {
+		ep.merkleLeaves, err = loadMerkleLeaves(p, leavesOff)
+		if err != nil {
+			return nil, extendErr("Failed to load Merkle leaves", withIdentifier(err, id))
+		}
 	}
 
 	// Create the entry
@@ -321,3 +709,101 @@ func (p *PageManager) Open(id Identifier) (*Entry, error) {
 
 	return newEntry, nil
 }
+
+// lookupIdentifier returns the offset of the entryPage an Identifier points
+// to. It returns ErrInvalidIdentifier for the zero Identifier and ErrNotFound
+// if the idTable doesn't contain the Identifier. The p.mu lock needs to be
+// acquired.
+func (p *PageManager) lookupIdentifier(id Identifier) (int64, error) {
+	if id.index() == 0 {
+		return 0, pageError(ErrInvalidIdentifier, 0, "index 0 is never used")
+	}
+	fileOff, exists := p.ids.lookup(id.index())
+	if !exists {
+		return 0, pageError(ErrNotFound, 0, "no entry with identifier %v", id)
+	}
+
+	// Make sure the offset points to a page within the file
+	if fileOff < p.file.dataOff() || fileOff%p.file.slotSize() != 0 {
+		return 0, pageError(ErrCorrupted, fileOff, "idTable entry %v is not the offset of a page", id.index())
+	}
+	size, err := p.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, ioError(err, fileOff)
+	}
+	if fileOff+p.file.slotSize() > size {
+		return 0, pageError(ErrCorrupted, fileOff, "idTable entry %v points past the end of the file", id.index())
+	}
+	return fileOff, nil
+}
+
+// entryPageOffset returns the offset of the entryPage of an existing entry.
+// It returns ErrNotFound if the Identifier doesn't point to an entryPage with
+// the Identifier's nonce. The p.mu lock needs to be acquired.
+func (p *PageManager) entryPageOffset(id Identifier) (int64, error) {
+	fileOff, err := p.lookupIdentifier(id)
+	if err != nil {
+		return 0, err
+	}
+	tag, nonce, err := readEntryHeader(&physicalPage{
+		file:     p.file,
+		fileOff:  fileOff,
+		usedSize: pageSize,
+	})
+	if err != nil {
+		return 0, extendErr("Failed to read entry header", err)
+	}
+	if tag != entryPageTag || nonce != id.nonce() {
+		return 0, pageError(ErrNotFound, fileOff, "no entry with identifier %v", id)
+	}
+	return fileOff, nil
+}
+
+// Delete removes an entry and frees its pages. All instances of the entry
+// need to be closed before, otherwise ErrEntryOpen is returned. Watchers of
+// the entry receive a Deleted event.
+func (p *PageManager) Delete(id Identifier) error {
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.exit()
+	entry, err := p.Open(id)
+	if err != nil {
+		return err
+	}
+	ep := entry.ep
+	id = ep.id
+
+	// Remove the entry from the idTable and clear the header of its
+	// entryPage to make sure it can't be opened anymore
+	p.lock()
+	if ep.instanceCounter > 1 {
+		ep.instanceCounter--
+		p.mu.Unlock()
+		return ErrEntryOpen
+	}
+	delete(p.entryPages, id)
+	err = p.ids.set(id.index(), 0)
+	if err == nil {
+		_, err = ep.pp.writeAt(make([]byte, entryHeaderSize), entryHeaderOff)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return extendErr("failed to remove entry", withIdentifier(err, id))
+	}
+	p.closeWatchers(id, Deleted{})
+
+	// Free the pages of the entry
+	if err := entry.truncate(context.Background(), 0, nil); err != nil {
+		return extendErr("failed to free pages of entry", withIdentifier(err, id))
+	}
+	if err := p.managedFreePages([]*physicalPage{ep.root.pp, ep.pp}); err != nil {
+		return extendErr("failed to free entryPage", withIdentifier(err, id))
+	}
+	if ep.merkleLeaves != nil {
+		if err := p.managedFreePages(ep.merkleLeaves.allPages()); err != nil {
+			return extendErr("failed to free Merkle leaves", withIdentifier(err, id))
+		}
+	}
+	return p.syncer.afterWrite()
+}
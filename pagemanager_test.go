@@ -2,6 +2,8 @@ package pages
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -35,6 +37,50 @@ func totalPages(pt *pageTable) uint64 {
 	return sum
 }
 
+// totalTables is a helper function that counts the pageTables of a tree
+func totalTables(pt *pageTable) uint64 {
+	sum := uint64(1)
+	for _, child := range pt.childTables {
+		sum += totalTables(child)
+	}
+	return sum
+}
+
+// writeFragmented is a helper function that writes data to an entry one page
+// at a time. Every page is followed by a page of another entry on disk which
+// prevents the entry's pages from being merged into extents.
+func writeFragmented(pm *PageManager, entry *Entry, data []byte) error {
+	filler, _, err := pm.Create()
+	if err != nil {
+		return err
+	}
+	defer filler.Close()
+	for len(data) > 0 {
+		n := len(data)
+		if n > pageSize {
+			n = pageSize
+		}
+		if _, err := entry.Write(data[:n]); err != nil {
+			return err
+		}
+		if _, err := filler.Write(make([]byte, pageSize)); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// newTestDataFilePath is a helper function that creates a test directory and
+// returns the path of a data file within it
+func newTestDataFilePath(name string) (string, error) {
+	testdir := build.TempDir("paging", name)
+	if err := os.MkdirAll(testdir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(testdir, "data.dat"), nil
+}
+
 // newPagingTester returns a ready-to-rock pagingTester
 func newPagingTester(name string) (*pagingTester, error) {
 	// Create temp dir
@@ -66,7 +112,7 @@ func TestAllocatePage(t *testing.T) {
 	numPages := 10
 	pages := make([]*physicalPage, numPages)
 	for i := 0; i < numPages; i++ {
-		page, err := pt.pm.managedAllocatePage()
+		page, err := pt.pm.managedAllocatePage(-1)
 		if err != nil {
 			t.Errorf("Failed to allocate page number %v: %v", i, err)
 		}
@@ -79,16 +125,17 @@ func TestAllocatePage(t *testing.T) {
 		t.Fatalf("Failed to get file stats: %v", err)
 	}
 
-	// Check filesize afterwards
-	if stats.Size() != int64(numPages*pageSize+dataOff+pageSize) {
-		t.Errorf("Filesize should be %v, but was %v", numPages*pageSize+dataOff, stats.Size())
+	// Check filesize afterwards. The first pages after dataOff contain the
+	// root and the page of the bitmap and the root of the idTable
+	if stats.Size() != int64(numPages*pageSize+dataOff+3*pageSize) {
+		t.Errorf("Filesize should be %v, but was %v", numPages*pageSize+dataOff+3*pageSize, stats.Size())
 	}
 
 	// Check if fields were set correctly
 	for i := 0; i < numPages; i++ {
-		if pages[i].fileOff != int64(i*pageSize+dataOff+pageSize) {
+		if pages[i].fileOff != int64(i*pageSize+dataOff+3*pageSize) {
 			t.Fatalf("Page %v has wrong offset. Was %v, but should be %v",
-				i, pages[i].fileOff, i*pageSize+dataOff+pageSize)
+				i, pages[i].fileOff, i*pageSize+dataOff+3*pageSize)
 		}
 	}
 }
@@ -114,30 +161,28 @@ func TestReadWriteFreePagesToDisk(t *testing.T) {
 	}
 
 	// Truncate file to 0 bytes
+	tables := totalTables(entry.ep.root)
 	if err := entry.Truncate(0); err != nil {
 		t.Fatalf("Failed to truncate file to 0 bytes")
 	}
 
 	// Check number of free pages. There should be numPages pages plus the
 	// pageTables that were allocated and are no longer needed.
-	expectedPages := numPages + uint64(numPages/numPageEntries+1)
-	if pt.pm.freePages.nextIndex() != expectedPages {
+	expectedPages := int(numPages + tables - totalTables(entry.ep.root))
+	if pt.pm.bitmap.Len() != expectedPages {
 		t.Errorf("There should be %v free pages but there were %v",
-			expectedPages, pt.pm.freePages.nextIndex())
+			expectedPages, pt.pm.bitmap.Len())
 	}
 
-	// Delete them from memory
-	pt.pm.freePages = nil
-
 	// Load them again
-	if err := pt.pm.loadFreePagesFromDisk(); err != nil {
+	if pt.pm.bitmap, err = loadBitmap(pt.pm); err != nil {
 		t.Errorf("Failed to load free pages: %v", err)
 	}
 
 	// Compare them
-	if pt.pm.freePages.nextIndex() != expectedPages {
+	if pt.pm.bitmap.Len() != expectedPages {
 		t.Errorf("There should be %v free pages but there were %v",
-			expectedPages, pt.pm.freePages.nextIndex())
+			expectedPages, pt.pm.bitmap.Len())
 	}
 }
 
@@ -256,3 +301,182 @@ func TestInstanceCounter(t *testing.T) {
 		t.Errorf("length of entryPages should be 0 but was %v", pt.pm.entryPages)
 	}
 }
+
+// TestOpenContext tests if opening an entry can be cancelled
+func TestOpenContext(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(10 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the entry with a cancelled context should fail
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pt.pm.OpenContext(ctx, identifier); err != context.Canceled {
+		t.Errorf("err should be %v but was %v", context.Canceled, err)
+	}
+	if len(pt.pm.entryPages) != 0 {
+		t.Errorf("length of entryPages should be 0 but was %v", len(pt.pm.entryPages))
+	}
+
+	// Opening it without a context should work
+	if _, err := pt.pm.OpenContext(context.Background(), identifier); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDelete tests if deleting an entry frees all its pages and makes its
+// Identifier invalid
+func TestDelete(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create an entry that requires multiple pageTables
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFragmented(pt.pm, entry, fastrand.Bytes((numPageEntries+10)*pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	freePages := pt.pm.bitmap.Len()
+	if entry.ep.root.height != 1 {
+		t.Fatalf("height of the root should be 1 but was %v", entry.ep.root.height)
+	}
+
+	// The data pages, the pageTables and the entryPage should be freed
+	numPages := len(entry.ep.pages) + len(entry.ep.root.childTables) + 2
+
+	// Open entries can't be deleted
+	if err := pt.pm.Delete(identifier); err != ErrEntryOpen {
+		t.Fatalf("err should be %v but was %v", ErrEntryOpen, err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Delete(identifier); err != nil {
+		t.Fatal(err)
+	}
+
+	// All the pages should be free and the entry shouldn't exist anymore
+	if available := pt.pm.bitmap.Len(); available < freePages+numPages {
+		t.Errorf("at least %v pages should be free but only %v were", freePages+numPages, available)
+	}
+	if _, err := pt.pm.Open(identifier); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err should be %v but was %v", ErrNotFound, err)
+	}
+	if err := pt.pm.Delete(identifier); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err should be %v but was %v", ErrNotFound, err)
+	}
+
+	// The entry should still be gone after recovering the PageManager
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(pt.pm.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if _, err := pm.Open(identifier); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err should be %v but was %v", ErrNotFound, err)
+	}
+}
+
+// TestClose tests if Close waits for running operations, persists their data
+// and invalidates open entries
+func TestClose(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start writing concurrently and close the PageManager in the meantime.
+	// Every write either finishes or fails with ErrClosed.
+	data := fastrand.Bytes(8 * pageSize)
+	errs := make(chan error, len(data)/pageSize)
+	for i := 0; i < len(data)/pageSize; i++ {
+		go func(i int) {
+			result := entry.WriteRanges([]Range{{Off: 0, Data: data[:(i+1)*pageSize]}})[0]
+			if result.Err != nil {
+				errs <- result.Err
+				return
+			}
+			errs <- entry.Sync()
+		}(i)
+	}
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil && !errors.Is(err, ErrClosed) {
+			t.Fatal(err)
+		}
+	}
+
+	// All the methods should return ErrClosed now
+	if _, err := entry.Write([]byte{1}); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if _, err := entry.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if err := entry.Truncate(0); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if _, err := entry.MerkleRoot(); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if err := entry.SetAttr("key", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if err := entry.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if _, _, err := pt.pm.Create(); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if _, err := pt.pm.Open(identifier); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+	if err := pt.pm.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("err should be %v but was %v", ErrClosed, err)
+	}
+
+	// The data of the finished writes should be there after recovering the
+	// PageManager
+	pm, err := New(pt.pm.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	entry, err = pm.Open(identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, entry.Size())
+	if _, err := entry.ReadAt(readData, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if entry.Size()%pageSize != 0 || !bytes.Equal(readData, data[:entry.Size()]) {
+		t.Fatalf("data doesn't match, size %v", entry.Size())
+	}
+}
@@ -2,8 +2,6 @@ package pages
 
 import (
 	"encoding/binary"
-
-	"github.com/NebulousLabs/Sia/build"
 )
 
 type (
@@ -26,6 +24,21 @@ type (
 
 		// pp is the physical page on which the pageTable is stored
 		pp *physicalPage
+
+		// firstPage is the index of the first page within the pageTable's
+		// subtree
+		firstPage uint64
+
+		// extents are the runs of adjacent childPages of a pageTable with
+		// height 0. They are stored on disk instead of the individual pages.
+		extents []extent
+	}
+
+	// extent is a run of pages that are adjacent on disk. Only uncompressed
+	// pages are merged into extents of multiple pages.
+	extent struct {
+		first *physicalPage
+		count int64
 	}
 )
 
@@ -34,10 +47,16 @@ func newPageTable(height int64, parent *pageTable, pm *PageManager) (*pageTable,
 	// Allocate a page for the table
 	pp, err := pm.allocatePage()
 	if err != nil {
-		return nil, build.ExtendErr("failed to allocate page for new pageTable", err)
+		return nil, extendErr("failed to allocate page for new pageTable", err)
 	}
+	return newPageTableAt(height, parent, pp)
+}
 
-	// Create and return the table
+// newPageTableAt creates a pageTable that is stored in the already allocated
+// page pp
+func newPageTableAt(height int64, parent *pageTable, pp *physicalPage) (*pageTable, error) {
+	// Create the table and write it to disk since the page might still
+	// contain old data
 	pt := pageTable{
 		parent:      parent,
 		height:      height,
@@ -45,46 +64,54 @@ func newPageTable(height int64, parent *pageTable, pm *PageManager) (*pageTable,
 		childPages:  make(map[uint64]*physicalPage),
 		childTables: make(map[uint64]*pageTable),
 	}
+	if err := pt.writeToDisk(); err != nil {
+		return nil, extendErr("failed to write new pageTable", err)
+	}
 	return &pt, nil
 }
 
 // extendPageTableTree extends the pageTable tree by creating a new root,
 // adding the current root as the first child and creating the rest of the tree
 // structure
-func extendPageTableTree(root *pageTable, pm *PageManager) (*pageTable, error) {
+func extendPageTableTree(root *pageTable, tp *tieredPage) (*pageTable, error) {
 	if root.parent != nil {
 		// This should only ever be called on the root node
-		panic("Sanity check failed. Pt is not the root node")
+		return nil, critical("pt is not the root node")
 	}
 
 	// Create a new root pageTable
-	newRoot, err := newPageTable(root.height+1, nil, pm)
+	newRoot, err := tp.newPageTable(root.height+1, nil)
 	if err != nil {
-		return nil, build.ExtendErr("Failed to create new pageTable to extend the tree", err)
+		return nil, extendErr("Failed to create new pageTable to extend the tree", err)
 	}
 
-	// Set the previous root pageTable to be the child of the new one
+	// Set the previous root pageTable to be the child of the new one. The
+	// new root is written to disk once the next child is added.
 	newRoot.childTables[0] = root
 	root.parent = newRoot
 
 	return newRoot, nil
 }
 
-// marshal serializes a pageTable to be able to write it to disk
+// marshal serializes a pageTable to be able to write it to disk. pageTables
+// with height 0 store the extents of their pages. The number of extents is
+// marked with extentTableFlag to distinguish them from tables that point to
+// other tables.
 func (pt pageTable) marshal() ([]byte, error) {
-	// Get the number of entries and the offsets of the entries
-	var numEntries uint64
-	var offsets []int64
 	if pt.height == 0 {
-		numEntries = uint64(len(pt.childPages))
-		for i := uint64(0); i < numEntries; i++ {
-			offsets = append(offsets, pt.childPages[uint64(i)].fileOff)
-		}
-	} else {
-		numEntries = uint64(len(pt.childTables))
-		for i := uint64(0); i < numEntries; i++ {
-			offsets = append(offsets, pt.childTables[uint64(i)].pp.fileOff)
+		data := make([]byte, 8*(len(pt.extents)+1))
+		binary.LittleEndian.PutUint64(data, uint64(len(pt.extents))|pageTableFlag|extentTableFlag)
+		for i, e := range pt.extents {
+			binary.LittleEndian.PutUint64(data[8*(i+1):], encodeExtent(e))
 		}
+		return data, nil
+	}
+
+	// Get the number of entries and the offsets of the entries
+	numEntries := uint64(len(pt.childTables))
+	var offsets []int64
+	for i := uint64(0); i < numEntries; i++ {
+		offsets = append(offsets, pt.childTables[uint64(i)].pp.fileOff)
 	}
 
 	// off is an offset used for marshalling the data
@@ -94,30 +121,68 @@ func (pt pageTable) marshal() ([]byte, error) {
 	data := make([]byte, (numEntries+1)*8)
 
 	// Write the number of entries
-	binary.LittleEndian.PutUint64(data[off:8], numEntries)
+	binary.LittleEndian.PutUint64(data[off:8], numEntries|pageTableFlag)
 	off += 8
 
 	// Write the offsets of the entries
 	for _, offset := range offsets {
-		binary.PutVarint(data[off:off+8], offset)
+		binary.LittleEndian.PutUint64(data[off:off+8], uint64(offset)|slotValidFlag)
 		off += 8
 	}
 
 	return data, nil
 }
 
+// canMerge returns true if pp can be added to the last extent of the
+// pageTable
+func (pt *pageTable) canMerge(pp *physicalPage) bool {
+	if len(pt.extents) == 0 {
+		return false
+	}
+	last := pt.extents[len(pt.extents)-1]
+	return last.count < maxExtentPages &&
+		!last.first.compress && last.first.storedSize == 0 &&
+		!pp.compress && pp.storedSize == 0 &&
+		pp.fileOff == last.first.fileOff+last.count*pp.file.slotSize()
+}
+
+// full returns true if a pageTable with height 0 can't store pp
+func (pt *pageTable) full(pp *physicalPage) bool {
+	return len(pt.extents) == numPageEntries && !pt.canMerge(pp)
+}
+
+// appendPage adds a page to the end of a pageTable with height 0
+func (pt *pageTable) appendPage(pp *physicalPage) {
+	pt.childPages[uint64(len(pt.childPages))] = pp
+	if pt.canMerge(pp) {
+		pt.extents[len(pt.extents)-1].count++
+		return
+	}
+	pt.extents = append(pt.extents, extent{first: pp, count: 1})
+}
+
+// removeLastPage removes the last page of a pageTable with height 0
+func (pt *pageTable) removeLastPage() {
+	delete(pt.childPages, uint64(len(pt.childPages)-1))
+	last := &pt.extents[len(pt.extents)-1]
+	last.count--
+	if last.count == 0 {
+		pt.extents = pt.extents[:len(pt.extents)-1]
+	}
+}
+
 // writeToDisk marshals a pageTable and writes it to disk
 func (pt pageTable) writeToDisk() error {
 	// Marshal the pageTable
 	data, err := pt.marshal()
 	if err != nil {
-		return build.ExtendErr("Failed to marshal pageTable", err)
+		return extendErr("Failed to marshal pageTable", err)
 	}
 
 	// Write it to disk
 	_, err = pt.pp.writeAt(data, 0)
 	if err != nil {
-		return build.ExtendErr("Failed to write pageTable to disk", err)
+		return extendErr("Failed to write pageTable to disk", err)
 	}
 	return nil
 }
@@ -135,3 +200,29 @@ func (pt pageTable) Size() uint32 {
 	}
 	return 4 + 4 + 8*children
 }
+
+// encodeSlot encodes the offset of a page and the on-disk size of its
+// compressed data into a single pageTable slot. A storedSize of 0 indicates
+// that the page is not compressed.
+func encodeSlot(fileOff int64, storedSize int64) int64 {
+	return fileOff | storedSize<<storedSizeShift
+}
+
+// encodeExtent encodes an extent into a single pageTable slot. The number of
+// pages minus one is stored above the slot of the first page.
+func encodeExtent(e extent) uint64 {
+	slot := encodeSlot(e.first.fileOff, e.first.storedSize)
+	return uint64(slot) | uint64(e.count-1)<<extentCountShift
+}
+
+// decodeExtent decodes a pageTable slot into the slot of the extent's first
+// page and the number of pages in the extent.
+func decodeExtent(slot uint64) (firstSlot int64, count int64) {
+	return int64(slot & (1<<extentCountShift - 1)), int64(slot>>extentCountShift) + 1
+}
+
+// decodeSlot decodes a pageTable slot into the offset of a page and the
+// on-disk size of its compressed data.
+func decodeSlot(slot int64) (fileOff int64, storedSize int64) {
+	return slot & (maxFileSize - 1), slot >> storedSizeShift
+}
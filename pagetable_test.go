@@ -1,6 +1,8 @@
 package pages
 
 import (
+	"bytes"
+	"encoding/binary"
 	"testing"
 
 	"github.com/NebulousLabs/fastrand"
@@ -37,7 +39,7 @@ func TestMarshalLoad(t *testing.T) {
 	}
 
 	// Unmarshal the data and compare
-	entries, err := unmarshalPageTable(data)
+	entries, err := unmarshalPageTable(data, pageSize)
 	if err != nil {
 		t.Errorf("Failed to unmarshal pageTable: %v", err)
 	}
@@ -52,3 +54,147 @@ func TestMarshalLoad(t *testing.T) {
 		}
 	}
 }
+
+// TestExtents tests if adjacent pages are stored as extents and if the
+// extents are recovered when the entry is opened again
+func TestExtents(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write more pages than a pageTable could store individually
+	numPages := int(4 * numPageEntries)
+	data := fastrand.Bytes(numPages * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	root := entry.ep.root
+	if root.height != 0 {
+		t.Fatalf("height of the root should be 0 but was %v", root.height)
+	}
+	maxExtents := numPages/maxExtentPages + 1
+	if len(root.extents) > maxExtents {
+		t.Fatalf("there should be at most %v extents but there were %v", maxExtents, len(root.extents))
+	}
+	numExtents := len(root.extents)
+
+	// Reopen the entry and compare the extents and the data
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.ep.root.extents) != numExtents {
+		t.Errorf("there should be %v extents but there were %v", numExtents, len(entry.ep.root.extents))
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.Read(readData); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Error("data doesn't match")
+	}
+}
+
+// TestLegacyPageTable tests if the pageTables and the tieredPage entries of an
+// entry that still use the version 1 format are loaded and migrated. Version 1
+// leaf tables might contain stale slots after truncations.
+func TestLegacyPageTable(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + 10)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	other, otherID, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherData := fastrand.Bytes(pageSize)
+	if _, err := other.Write(otherData); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite the root and the entries using the legacy format. The root
+	// gets a stale slot that points to a page of the other entry.
+	root := entry.ep.root
+	slots := len(root.childPages) + 1
+	legacy := make([]byte, 8*(slots+1))
+	binary.LittleEndian.PutUint64(legacy, uint64(slots))
+	for i := 0; i < len(root.childPages); i++ {
+		binary.PutVarint(legacy[8*(i+1):], root.childPages[uint64(i)].fileOff)
+	}
+	binary.PutVarint(legacy[8*slots:], other.ep.pages[0].fileOff)
+	if _, err := root.pp.writeAt(legacy, 0); err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]byte, maxTieredEntries*tieredPageEntrySize)
+	binary.PutVarint(entries[0:], entry.ep.usedSize)
+	binary.PutVarint(entries[8:], root.pp.fileOff)
+	if _, err := entry.ep.pp.writeAt(entries, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the entry and append to it
+	for i := 0; i < 2; i++ {
+		entry, err = pt.pm.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readData := make([]byte, len(data))
+		if _, err := entry.Read(readData); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, readData) {
+			t.Fatal("data doesn't match")
+		}
+		appended := fastrand.Bytes(pageSize)
+		if _, err := entry.Write(appended); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, appended...)
+		if err := entry.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The entries should be migrated and the other entry shouldn't change
+	if _, _, _, err := readTieredPageRoot(entry.ep.pp); err != nil {
+		t.Fatal(err)
+	}
+	other, err = pt.pm.Open(otherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	readData := make([]byte, len(otherData))
+	if _, err := other.Read(readData); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(otherData, readData) {
+		t.Error("data of the other entry doesn't match")
+	}
+}
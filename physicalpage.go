@@ -2,16 +2,14 @@ package pages
 
 import (
 	"errors"
-	"fmt"
 	"io"
-	"os"
 )
 
 type (
 	// physicalPage is a helper struct to easily write/read pages to/from disk
 	physicalPage struct {
 		// file is the file on which the page is stored
-		file *os.File
+		file *pageFile
 
 		// fileOff is the offset of the page to the beginning of the file
 		fileOff int64
@@ -19,6 +17,14 @@ type (
 		// usedSize is the amount of bytes of the page that are currently in
 		// use
 		usedSize int64
+
+		// compress indicates that data written to the page should be
+		// compressed before it is written to disk
+		compress bool
+
+		// storedSize is the size of the compressed data on disk. A storedSize
+		// of 0 means that the page is stored uncompressed.
+		storedSize int64
 	}
 )
 
@@ -39,15 +45,39 @@ func (p *physicalPage) readAt(b []byte, off int64) (n int, err error) {
 		length = p.usedSize - off
 	}
 
-	data := make([]byte, length)
-	n, err = p.file.ReadAt(data, p.fileOff+off)
+	// Compressed and encrypted pages need to be read as a whole
+	if p.wholePageIO() {
+		data, err := p.readPage()
+		if err != nil {
+			return 0, err
+		}
+		return copy(b, data[off:off+length]), nil
+	}
+
+	n, err = p.file.ReadAt(b[:length], p.fileOff+off)
 	if int64(n) != length {
-		panic(fmt.Sprintf("Sanity Check: ReadAt should have read %v bytes instead of %v",
-			length, n))
+		return 0, ioError(err, p.fileOff)
 	}
+	return n, nil
+}
 
-	copy(b, data)
-	return
+// dataAt returns the data of the page starting at off. Pages that can be read
+// partially are read into buf which needs to be at least pageSize bytes long.
+// The data of compressed and encrypted pages is returned without copying it
+// into buf.
+func (p *physicalPage) dataAt(buf []byte, off int64) ([]byte, error) {
+	if off >= p.usedSize {
+		return nil, io.EOF
+	}
+	if !p.wholePageIO() {
+		n, err := p.readAt(buf, off)
+		return buf[:n], err
+	}
+	data, err := p.readPage()
+	if err != nil {
+		return nil, err
+	}
+	return data[off:], nil
 }
 
 // writeAt writes data to a physical page starting from a specific offset.
@@ -66,15 +96,87 @@ func (p *physicalPage) writeAt(b []byte, off int64) (n int, err error) {
 		length = pageSize - off
 	}
 
+	// Compressed and encrypted pages need to be rewritten as a whole
+	if p.wholePageIO() {
+		return p.writePageAt(b[:length], off)
+	}
+
 	n, err = p.file.WriteAt(b[:length], p.fileOff+off)
+	if err != nil {
+		return 0, ioError(err, p.fileOff)
+	}
 
 	// Update the usedSize if necessary
 	if off+length > p.usedSize {
 		p.usedSize = off + length
 	}
+	return
+}
+
+// wholePageIO returns true if the page can't be accessed partially because it
+// is compressed or encrypted. Such pages need to be read and written as a
+// whole.
+func (p *physicalPage) wholePageIO() bool {
+	return p.compress || p.storedSize > 0 || p.file.encrypted()
+}
+
+// readPage reads the page from disk, decrypts and decompresses it if
+// necessary and returns the first usedSize bytes of its data.
+func (p *physicalPage) readPage() ([]byte, error) {
+	// Get the data as it is stored on disk
+	storedSize := p.usedSize
+	if p.storedSize > 0 {
+		storedSize = p.storedSize
+	}
+	stored, _, err := p.file.readPageData(p.fileOff, storedSize)
+	if err != nil {
+		return nil, err
+	}
+	if p.storedSize > 0 {
+		return decompressPage(stored, p.usedSize)
+	}
 
-	if int64(n) != length {
-		panic(fmt.Sprintf("Sanity Check: WriteAt should have written %v bytes", length))
+	// Pages that were never written completely are padded with zeros
+	data := make([]byte, p.usedSize)
+	copy(data, stored)
+	return data, nil
+}
+
+// writePageAt writes b to the page starting at off. Since compressed and
+// encrypted pages can't be modified in place, the page's data is read,
+// modified and written back to disk. If the page doesn't compress well it will
+// be stored uncompressed. Callers need to update the page's pageTable if
+// storedSize changed.
+func (p *physicalPage) writePageAt(b []byte, off int64) (int, error) {
+	// Get the current data of the page
+	usedSize := p.usedSize
+	if off+int64(len(b)) > usedSize {
+		usedSize = off + int64(len(b))
 	}
-	return
+	data := make([]byte, usedSize)
+	if p.usedSize > 0 {
+		current, err := p.readPage()
+		if err != nil {
+			return 0, err
+		}
+		copy(data, current)
+	}
+	copy(data[off:], b)
+
+	// Compress the data if possible
+	storedSize := int64(0)
+	if p.compress {
+		if compressed, ok := compressPage(data); ok {
+			data = compressed
+			storedSize = int64(len(compressed))
+		}
+	}
+
+	// Write the page to disk
+	if err := p.file.writePageData(p.fileOff, data); err != nil {
+		return 0, err
+	}
+	p.usedSize = usedSize
+	p.storedSize = storedSize
+	return len(b), nil
 }
@@ -0,0 +1,218 @@
+package pages
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// maxSizeAttr is the reserved attribute that stores the maximum size of an
+// entry as an 8 byte little-endian integer. It is set using Entry.SetMaxSize.
+const maxSizeAttr = reservedAttrPrefix + "max-size"
+
+var (
+	// ErrQuotaExceeded is returned if a write would grow an entry beyond its
+	// maximum size or the file beyond the MaxFileSize of the PageManager
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// errNegativeMaxSize is returned when trying to set a negative maximum
+	// size
+	errNegativeMaxSize = errors.New("maximum size can't be negative")
+)
+
+// CreateWithMaxSize creates a new Entry whose size is limited to maxSize
+// bytes and returns an identifier for it
+func (p *PageManager) CreateWithMaxSize(maxSize int64) (*Entry, Identifier, error) {
+	if maxSize < 0 {
+		return nil, Identifier{}, errNegativeMaxSize
+	}
+	entry, id, err := p.Create()
+	if err != nil {
+		return nil, Identifier{}, err
+	}
+	if err := entry.SetMaxSize(maxSize); err != nil {
+		entry.Close()
+		if delErr := p.Delete(id); delErr != nil {
+			return nil, Identifier{}, extendErr("failed to delete entry", delErr)
+		}
+		return nil, Identifier{}, err
+	}
+	return entry, id, nil
+}
+
+// MaxSize returns the maximum size of the entry or 0 if the size isn't
+// limited. Like Size it remains valid after the PageManager was closed.
+func (e *Entry) MaxSize() int64 {
+	e.ep.rLock()
+	defer e.ep.mu.RUnlock()
+	return e.ep.maxSize()
+}
+
+// SetMaxSize limits the size of the entry to maxSize bytes. Writes that would
+// grow the entry beyond maxSize fail with ErrQuotaExceeded. A maxSize of 0
+// removes the limit. If the entry is already larger than maxSize,
+// ErrQuotaExceeded is returned.
+func (e *Entry) SetMaxSize(maxSize int64) error {
+	if err := e.enter(); err != nil {
+		return err
+	}
+	defer e.pm.exit()
+	if maxSize < 0 {
+		return errNegativeMaxSize
+	}
+	e.ep.lock()
+	defer e.ep.mu.Unlock()
+	if maxSize > 0 && e.ep.usedSize > maxSize {
+		return withIdentifier(ErrQuotaExceeded, e.identifier())
+	}
+	var value []byte
+	if maxSize > 0 {
+		value = make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, uint64(maxSize))
+	}
+	if err := e.ep.updateAttrs(map[string][]byte{maxSizeAttr: value}); err != nil {
+		return withIdentifier(err, e.identifier())
+	}
+	return e.pm.syncer.afterWrite()
+}
+
+// maxSize returns the maximum size of the entry stored in its attributes or 0
+// if there is none
+func (ep *entryPage) maxSize() int64 {
+	value := ep.attrs[maxSizeAttr]
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(value))
+}
+
+// checkQuota returns ErrQuotaExceeded if growing the entry to end bytes would
+// exceed its maximum size. Whether the pages fit into the MaxFileSize of the
+// PageManager is checked when they are allocated. The ep.mu write lock needs
+// to be held.
+func (e *Entry) checkQuota(end int64) error {
+	if end <= e.ep.usedSize {
+		return nil
+	}
+	if maxSize := e.ep.maxSize(); maxSize > 0 && end > maxSize {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// allocatePages allocates the pages that are needed to grow the entry to end
+// bytes and appends them to its pages. It is called before anything is
+// written to make sure a failed allocation doesn't leave a partial write
+// behind. The pageTables and Merkle leaves for the new pages are reserved at
+// the same time, so either all the pages that the write needs are allocated
+// or none are. New pages that precede the page at cursorPage are marked as
+// full. It returns the new pages and the increase of the entry's size. The
+// ep.mu write lock needs to be held and releaseReserved needs to be called
+// once the pages were added.
+func (e *Entry) allocatePages(end, cursorPage int64) ([]*physicalPage, int64, error) {
+	numPages := (end+pageSize-1)/pageSize - int64(len(e.ep.pages))
+	if numPages <= 0 {
+		return nil, 0, nil
+	}
+	prev := int64(-1)
+	if len(e.ep.pages) > 0 {
+		prev = e.ep.pages[len(e.ep.pages)-1].fileOff
+	}
+	newPages, err := e.pm.managedAllocatePages(int(numPages), prev, e.reservePages)
+	if err != nil {
+		return nil, 0, err
+	}
+	var byteIncrease int64
+	for _, newPage := range newPages {
+		e.ep.pages = append(e.ep.pages, newPage)
+
+		// Pages before the cursor are skipped by the write and are full
+		if cursorPage >= int64(len(e.ep.pages)) {
+			newPage.usedSize = pageSize
+			byteIncrease += pageSize
+		}
+	}
+	return newPages, byteIncrease, nil
+}
+
+// reservePages allocates the pageTables that adding pages to the entry
+// creates and the pages and pageTables for their Merkle leaves. They are
+// used by addPages and invalidateMerkleLeaves afterwards. If an allocation
+// fails, the reserved pages are freed again. The PageManager's mu and the
+// ep.mu write lock need to be held.
+func (e *Entry) reservePages(pages []*physicalPage) (err error) {
+	for _, pp := range pages {
+		pp.compress = e.pm.opts.Compression
+	}
+	var reserved []*physicalPage
+	defer func() {
+		if err != nil && len(reserved) > 0 {
+			_ = e.pm.bitmap.free(reserved)
+		}
+	}()
+	tables, err := e.pm.allocatePagesAfter(e.ep.tablesNeeded(pages), -1)
+	if err != nil {
+		return err
+	}
+	reserved = append(reserved, tables...)
+
+	// Reserve the pages for the Merkle leaves if they are stored
+	ml := e.ep.merkleLeaves
+	if ml != nil {
+		leaves, err := e.pm.allocatePagesAfter(ml.pagesNeeded(len(e.ep.pages)+len(pages)), -1)
+		if err != nil {
+			return err
+		}
+		reserved = append(reserved, leaves...)
+		leafTables, err := e.pm.allocatePagesAfter(ml.tablesNeeded(leaves), -1)
+		if err != nil {
+			return err
+		}
+		ml.reservedPages = leaves
+		ml.reservedTables = leafTables
+	}
+	e.ep.reservedTables = tables
+	return nil
+}
+
+// releaseReserved frees the reserved pages that weren't used. The ep.mu write
+// lock needs to be held.
+func (ep *entryPage) releaseReserved() error {
+	pages := ep.reservedTables
+	ep.reservedTables = nil
+	if ml := ep.merkleLeaves; ml != nil {
+		pages = append(pages, ml.reservedPages...)
+		pages = append(pages, ml.reservedTables...)
+		ml.reservedPages, ml.reservedTables = nil, nil
+	}
+	if len(pages) == 0 {
+		return nil
+	}
+	return ep.pm.managedFreePages(pages)
+}
+
+// freeReserved frees the reserved pages that a write didn't use. If that
+// fails and err doesn't contain an error yet, the error is stored in err.
+func (e *Entry) freeReserved(err *error) {
+	if freeErr := e.ep.releaseReserved(); freeErr != nil && *err == nil {
+		*err = extendErr("failed to free reserved pages", freeErr)
+	}
+}
+
+// fitsQuota returns true if n pages can be allocated without exceeding the
+// MaxFileSize of the PageManager. Free pages are only counted if the
+// Allocator reuses them.
+func (p *PageManager) fitsQuota(n int64) bool {
+	if p.opts.MaxFileSize <= 0 || p.bitmap == nil {
+		return true
+	}
+	slotSize := p.file.slotSize()
+	fileSize := p.file.dataOff() + p.bitmap.numPages*slotSize
+	var available int64
+	if reusesFreePages(p.allocator()) {
+		available = int64(p.bitmap.Len())
+	}
+	if p.opts.MaxFileSize > fileSize {
+		available += (p.opts.MaxFileSize - fileSize) / slotSize
+	}
+	return n <= available
+}
@@ -0,0 +1,277 @@
+package pages
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestEntryQuota tests if writes can't grow an entry beyond its maximum size
+func TestEntryQuota(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Create an entry that can hold 2.5 pages
+	maxSize := int64(2*pageSize + pageSize/2)
+	entry, id, err := pm.CreateWithMaxSize(maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(int(maxSize))
+	if _, err := entry.Write(data[:pageSize]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes that would exceed the maximum size fail without writing
+	// anything
+	if _, err := entry.WriteAt(fastrand.Bytes(2*pageSize), pageSize-10); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(int(maxSize))); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	if entry.Size() != pageSize || len(entry.ep.pages) != 1 {
+		t.Fatalf("the entry shouldn't have changed: size %v, %v pages", entry.Size(), len(entry.ep.pages))
+	}
+
+	// Filling the entry up to its maximum size works
+	if _, err := entry.WriteAt(data[pageSize:], pageSize); err != nil {
+		t.Fatal(err)
+	}
+
+	// The maximum size is persisted
+	entry.Close()
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.MaxSize() != maxSize {
+		t.Fatalf("max size should be %v but was %v", maxSize, entry.MaxSize())
+	}
+	if _, err := entry.WriteAt([]byte{1}, maxSize); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	readData := make([]byte, maxSize)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("data doesn't match")
+	}
+
+	// The maximum size can't be changed or seen using the attributes API
+	if err := entry.SetAttr(maxSizeAttr, make([]byte, 8)); err != errReservedAttrKey {
+		t.Fatalf("expected %v but was %v", errReservedAttrKey, err)
+	}
+	if err := entry.DeleteAttr(maxSizeAttr); err != errReservedAttrKey {
+		t.Fatalf("expected %v but was %v", errReservedAttrKey, err)
+	}
+	if _, err := entry.WriteAtAttrs(nil, 0, map[string][]byte{maxSizeAttr: nil}); err != errReservedAttrKey {
+		t.Fatalf("expected %v but was %v", errReservedAttrKey, err)
+	}
+	if _, err := entry.GetAttr(maxSizeAttr); err != ErrAttrNotFound {
+		t.Fatalf("expected %v but was %v", ErrAttrNotFound, err)
+	}
+	if keys := entry.ListAttrs(); len(keys) != 0 {
+		t.Fatal("the reserved attribute shouldn't be listed", keys)
+	}
+	if size := entry.MaxSize(); size != maxSize {
+		t.Fatalf("max size should be %v but was %v", maxSize, size)
+	}
+
+	// The maximum size can't be smaller than the entry
+	if err := entry.SetMaxSize(pageSize); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+
+	// Removing the limit allows the entry to grow again
+	if err := entry.SetMaxSize(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.WriteAt([]byte{1}, maxSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := entry.ep.attrs[maxSizeAttr]; exists {
+		t.Fatal("the attribute should have been removed")
+	}
+}
+
+// TestFileQuota tests if the file can't grow beyond the MaxFileSize of the
+// PageManager
+func TestFileQuota(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewWithOptions(path, Options{MaxFileSize: dataOff + 16*pageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Fill most of the file
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(8 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSize := fi.Size()
+
+	// A write that doesn't fit fails before anything is written
+	if _, err := entry.Write(fastrand.Bytes(8 * pageSize)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	if entry.Size() != 8*pageSize || len(entry.ep.pages) != 8 {
+		t.Fatalf("the entry shouldn't have changed: size %v, %v pages", entry.Size(), len(entry.ep.pages))
+	}
+	if fi, err := pm.file.Stat(); err != nil || fi.Size() != fileSize {
+		t.Fatalf("the file shouldn't have grown: %v %v", fi.Size(), err)
+	}
+
+	// Allocating pages directly fails too
+	for err == nil {
+		_, err = pm.managedAllocatePage(-1)
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	if fi, err := pm.file.Stat(); err != nil || fi.Size() > dataOff+16*pageSize {
+		t.Fatalf("the file exceeds MaxFileSize: %v %v", fi.Size(), err)
+	}
+}
+
+// TestFileQuotaExact tests if a write that needs exactly the available pages
+// for its data, pageTables and Merkle leaves succeeds
+func TestFileQuotaExact(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxFileSize := int64(dataOff + 4*leavesPerPage*pageSize)
+	pm, err := NewWithOptions(path, Options{MaxFileSize: maxFileSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Fill the first page of Merkle leaves
+	entry, _, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(leavesPerPage * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next write needs pages for the Merkle leaves in addition to its
+	// data. Find the largest write that fits.
+	fi, err := pm.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	available := int(pm.Stats().FreePages + (maxFileSize-fi.Size())/pageSize)
+	numPages := available
+	for numPages+(leavesPerPage+numPages-1)/leavesPerPage > available {
+		numPages--
+	}
+	if _, err := entry.Write(fastrand.Bytes((numPages + 1) * pageSize)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(numPages * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if s := pm.Stats(); s.FreePages != 0 {
+		t.Fatalf("there should be no free pages but there were %v", s.FreePages)
+	}
+	if _, err := entry.MerkleRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.managedAllocatePage(-1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+}
+
+// TestFileQuotaAppendOnly tests if free pages don't count towards the
+// available space of a PageManager whose Allocator never reuses them
+func TestFileQuotaAppendOnly(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewWithOptions(path, Options{Allocator: AllocateAppendOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create an entry and free most of its pages
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(16 * pageSize)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(4 * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Limit the file to its current size
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSize := fi.Size()
+	pm, err = NewWithOptions(path, Options{Allocator: AllocateAppendOnly, MaxFileSize: fileSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if pm.bitmap.Len() < 8 {
+		t.Fatalf("the truncated pages should be free but only %v are", pm.bitmap.Len())
+	}
+	if pm.fitsQuota(1) {
+		t.Fatal("free pages that are never reused shouldn't be available")
+	}
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A write that needs new pages fails before the last page is touched
+	if _, err := entry.WriteAt(fastrand.Bytes(4*pageSize), 4*pageSize-10); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v but was %v", ErrQuotaExceeded, err)
+	}
+	readData := make([]byte, 4*pageSize)
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, data[:4*pageSize]) || entry.Size() != 4*pageSize {
+		t.Fatal("the entry shouldn't have changed")
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != fileSize {
+		t.Fatalf("the file shouldn't have grown: %v %v", fi.Size(), err)
+	}
+}
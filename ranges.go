@@ -0,0 +1,212 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+)
+
+var (
+	// errWriteBeyondEnd is returned when trying to write a range that starts
+	// after the end of the entry
+	errWriteBeyondEnd = errors.New("can't write beyond the end of the entry")
+)
+
+type (
+	// Range is a range of an entry's data. For reads Data is the buffer the
+	// range is read into and for writes it is the data that is written.
+	Range struct {
+		Off  int64
+		Data []byte
+	}
+
+	// RangeResult is the result of reading or writing a single Range. N is
+	// the number of bytes that were read or written. Reads that reach the
+	// end of the entry return io.EOF.
+	RangeResult struct {
+		N   int
+		Err error
+	}
+
+	// rangeSegment is the part of a Range that is stored in a single page
+	rangeSegment struct {
+		// page is the page that contains the segment and off is the offset
+		// of the segment within the page
+		page *physicalPage
+		off  int64
+
+		// data is the part of the Range's buffer that belongs to the
+		// segment
+		data []byte
+
+		// index is the index of the Range within the request
+		index int
+	}
+)
+
+// segments splits a Range into the parts that are stored in individual
+// pages. It returns the number of bytes that can be read from the range.
+func (e *Entry) segments(r Range, index int) ([]rangeSegment, int) {
+	var segments []rangeSegment
+	off := r.Off
+	data := r.Data
+	n := 0
+	for len(data) > 0 && off < e.ep.usedSize {
+		page := e.ep.pages[off/pageSize]
+		pageOff := off % pageSize
+		if pageOff >= page.usedSize {
+			break
+		}
+		length := int64(len(data))
+		if length > page.usedSize-pageOff {
+			length = page.usedSize - pageOff
+		}
+		segments = append(segments, rangeSegment{
+			page:  page,
+			off:   pageOff,
+			data:  data[:length],
+			index: index,
+		})
+		data = data[length:]
+		off += length
+		n += int(length)
+	}
+	return segments, n
+}
+
+// ReadRanges reads multiple ranges of the entry. All the ranges are resolved
+// to pages at once and pages that are adjacent on disk are read with a single
+// read. The results are returned in the order of the ranges.
+func (e *Entry) ReadRanges(ranges []Range) []RangeResult {
+	results := make([]RangeResult, len(ranges))
+	if err := e.enter(); err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	defer e.pm.exit()
+	start := e.pm.metrics.start()
+	defer func() {
+		for _, result := range results {
+			e.pm.metrics.add(MetricBytesRead, int64(result.N))
+		}
+		e.pm.metrics.observe(MetricReadDuration, start)
+	}()
+	e.ep.rLock()
+	defer e.ep.mu.RUnlock()
+
+	// Split the ranges into segments
+	var segments []rangeSegment
+	for i, r := range ranges {
+		if r.Off < 0 {
+			results[i].Err = errors.New("Cannot read at negative offset")
+			continue
+		}
+		rangeSegments, n := e.segments(r, i)
+		segments = append(segments, rangeSegments...)
+		results[i].N = n
+		if n < len(r.Data) {
+			results[i].Err = io.EOF
+		}
+	}
+
+	// Pages that need to be decrypted or decompressed are read on their own.
+	// The others are sorted by their offset within the file to find
+	// adjacent ones.
+	var plain []rangeSegment
+	for _, s := range segments {
+		if !s.page.wholePageIO() {
+			plain = append(plain, s)
+			continue
+		}
+		if _, err := s.page.readAt(s.data, s.off); err != nil {
+			e.failRange(results, s.index, err)
+		}
+	}
+	sort.Slice(plain, func(i, j int) bool {
+		return plain[i].page.fileOff+plain[i].off < plain[j].page.fileOff+plain[j].off
+	})
+
+	// Coalesce the segments that are adjacent on disk into a single read
+	for start := 0; start < len(plain); {
+		end := start + 1
+		fileOff := plain[start].page.fileOff + plain[start].off
+		length := int64(len(plain[start].data))
+		for end < len(plain) && plain[end].page.fileOff+plain[end].off == fileOff+length {
+			length += int64(len(plain[end].data))
+			end++
+		}
+		if err := e.readSegments(plain[start:end], fileOff, length); err != nil {
+			for _, s := range plain[start:end] {
+				e.failRange(results, s.index, err)
+			}
+		}
+		start = end
+	}
+	return results
+}
+
+// readSegments reads adjacent segments with a single read
+func (e *Entry) readSegments(segments []rangeSegment, fileOff, length int64) error {
+	if len(segments) == 1 {
+		_, err := segments[0].page.readAt(segments[0].data, segments[0].off)
+		return err
+	}
+	buf := make([]byte, length)
+	if n, err := e.pm.file.ReadAt(buf, fileOff); int64(n) != length {
+		return ioError(err, fileOff)
+	}
+	for _, s := range segments {
+		buf = buf[copy(s.data, buf):]
+	}
+	return nil
+}
+
+// failRange marks a range as failed
+func (e *Entry) failRange(results []RangeResult, index int, err error) {
+	if results[index].Err == nil || results[index].Err == io.EOF {
+		results[index] = RangeResult{Err: withIdentifier(err, e.identifier())}
+	}
+}
+
+// WriteRanges writes multiple ranges to the entry in order while holding the
+// entry's lock only once. A range can't start after the end of the entry but
+// it can extend it. Depending on the SyncPolicy it only returns after the data
+// is durable.
+func (e *Entry) WriteRanges(ranges []Range) []RangeResult {
+	results := make([]RangeResult, len(ranges))
+	if err := e.enter(); err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	defer e.pm.exit()
+	var events []Event
+	e.ep.lock()
+	for i, r := range ranges {
+		if r.Off < 0 || r.Off > e.ep.usedSize {
+			results[i].Err = withIdentifier(errWriteBeyondEnd, e.identifier())
+			continue
+		}
+		cursorPage := r.Off / pageSize
+		cursorOff := r.Off % pageSize
+		n, err := e.write(context.Background(), r.Data, &cursorPage, &cursorOff, true, &events)
+		results[i] = RangeResult{N: n}
+		if err != nil {
+			results[i].Err = withIdentifier(err, e.identifier())
+		}
+	}
+	e.ep.mu.Unlock()
+
+	if err := e.commit(events); err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,122 @@
+package pages
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestReadRanges tests if multiple ranges can be read at once from plain and
+// compressed entries
+func TestReadRanges(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		path, err := newTestDataFilePath(t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm, err := NewWithOptions(path, Options{Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, _, err := pm.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Half the data is compressible
+		data := append(fastrand.Bytes(5*pageSize), make([]byte, 5*pageSize+100)...)
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		// Read scattered, overlapping and adjacent ranges
+		ranges := []Range{
+			{Off: 3 * pageSize, Data: make([]byte, 2*pageSize)},
+			{Off: 100, Data: make([]byte, 50)},
+			{Off: pageSize - 10, Data: make([]byte, 20)},
+			{Off: 150, Data: make([]byte, 3*pageSize)},
+			{Off: 4*pageSize + 1000, Data: make([]byte, 4*pageSize)},
+			{Off: int64(len(data)) - 10, Data: make([]byte, 20)},
+			{Off: int64(len(data)) + 10, Data: make([]byte, 20)},
+			{Off: -1, Data: make([]byte, 20)},
+		}
+		results := entry.ReadRanges(ranges)
+		for i, r := range ranges[:len(ranges)-1] {
+			expected := int64(len(r.Data))
+			if r.Off+expected > int64(len(data)) {
+				expected = int64(len(data)) - r.Off
+			}
+			if expected < 0 {
+				expected = 0
+			}
+			if results[i].N != int(expected) {
+				t.Fatalf("range %v: %v bytes should be read but %v were", i, expected, results[i].N)
+			}
+			if expected < int64(len(r.Data)) && results[i].Err != io.EOF {
+				t.Fatalf("range %v: err should be %v but was %v", i, io.EOF, results[i].Err)
+			}
+			if expected == int64(len(r.Data)) && results[i].Err != nil {
+				t.Fatalf("range %v: %v", i, results[i].Err)
+			}
+			if !bytes.Equal(r.Data[:expected], data[r.Off:r.Off+expected]) {
+				t.Fatalf("range %v: data doesn't match", i)
+			}
+		}
+		if results[len(results)-1].Err == nil {
+			t.Error("reading at a negative offset should fail")
+		}
+		if err := pm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWriteRanges tests if multiple ranges can be written at once
+func TestWriteRanges(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(3*pageSize + 100)
+	if _, err := entry.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite ranges, append to the entry and try to leave a gap
+	ranges := []Range{
+		{Off: 10, Data: fastrand.Bytes(100)},
+		{Off: pageSize - 50, Data: fastrand.Bytes(pageSize)},
+		{Off: int64(len(data)) - 10, Data: fastrand.Bytes(2 * pageSize)},
+		{Off: 10 * pageSize, Data: fastrand.Bytes(10)},
+	}
+	results := entry.WriteRanges(ranges)
+	for i, r := range ranges[:3] {
+		if results[i].Err != nil || results[i].N != len(r.Data) {
+			t.Fatalf("range %v: %v bytes should be written but %v were: %v", i, len(r.Data), results[i].N, results[i].Err)
+		}
+		if end := int(r.Off) + len(r.Data); end > len(data) {
+			data = append(data, make([]byte, end-len(data))...)
+		}
+		copy(data[r.Off:], r.Data)
+	}
+	if results[3].Err == nil {
+		t.Error("writing beyond the end should fail")
+	}
+
+	// Read the data again
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Size() != int64(len(data)) || !bytes.Equal(data, readData) {
+		t.Error("data doesn't match")
+	}
+}
@@ -0,0 +1,108 @@
+package pages
+
+import (
+	"errors"
+)
+
+var (
+	// errPageNotAllocated is returned when accessing a page that wasn't
+	// allocated
+	errPageNotAllocated = errors.New("page is not allocated")
+
+	// errWrongPageSize is returned if the buffer of a page doesn't have
+	// PageSize bytes
+	errWrongPageSize = errors.New("buffer needs to have PageSize bytes")
+)
+
+// AllocatePage allocates a page that doesn't belong to an entry and returns
+// its offset. The page is selected by the Allocator like the pages of entries
+// and counts towards the MaxFileSize. It stays allocated until it is freed
+// using FreePage, so the caller needs to store its offset to not leak it.
+func (p *PageManager) AllocatePage() (int64, error) {
+	if err := p.enter(); err != nil {
+		return 0, err
+	}
+	defer p.exit()
+	p.lock()
+	defer p.mu.Unlock()
+	pages, err := p.allocatePagesAfter(1, -1)
+	if err != nil {
+		return 0, err
+	}
+	return pages[0].fileOff, nil
+}
+
+// FreePage frees a page that was allocated using AllocatePage. Afterwards it
+// can be reused for other data.
+func (p *PageManager) FreePage(off int64) error {
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.exit()
+	p.lock()
+	defer p.mu.Unlock()
+	pp, err := p.rawPage(off)
+	if err != nil {
+		return err
+	}
+	return p.bitmap.free([]*physicalPage{pp})
+}
+
+// ReadPage reads the page at off that was allocated using AllocatePage into b
+// which needs to have PageSize bytes
+func (p *PageManager) ReadPage(off int64, b []byte) error {
+	if len(b) != pageSize {
+		return errWrongPageSize
+	}
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.exit()
+
+	// Holding mu makes sure that the page isn't rewritten by Rekey at the
+	// same time
+	p.lock()
+	defer p.mu.Unlock()
+	pp, err := p.rawPage(off)
+	if err != nil {
+		return err
+	}
+	pp.usedSize = pageSize
+	_, err = pp.readAt(b, 0)
+	return err
+}
+
+// WritePage writes b, which needs to have PageSize bytes, to the page at off
+// that was allocated using AllocatePage. Like the writes of entries it is
+// only durable after the file was synced.
+func (p *PageManager) WritePage(off int64, b []byte) error {
+	if len(b) != pageSize {
+		return errWrongPageSize
+	}
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.exit()
+	p.lock()
+	defer p.mu.Unlock()
+	pp, err := p.rawPage(off)
+	if err != nil {
+		return err
+	}
+	_, err = pp.writeAt(b, 0)
+	return err
+}
+
+// rawPage returns the allocated page at off. The PageManager's mu needs to
+// be acquired.
+func (p *PageManager) rawPage(off int64) (*physicalPage, error) {
+	index := p.bitmap.index(off)
+	if off < p.file.dataOff() || p.bitmap.offset(index) != off ||
+		index >= p.bitmap.numPages || !p.bitmap.isSet(index) {
+		return nil, pageError(errPageNotAllocated, off, "no allocated page at %v", off)
+	}
+	return &physicalPage{
+		file:    p.file,
+		fileOff: off,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package pages
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestRawPages tests allocating, accessing and freeing pages that don't
+// belong to an entry
+func TestRawPages(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allocate a few pages and write to them
+	data := make(map[int64][]byte)
+	for i := 0; i < 3; i++ {
+		off, err := pm.AllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, exists := data[off]; exists {
+			t.Fatalf("page %v was allocated twice", off)
+		}
+		data[off] = fastrand.Bytes(pageSize)
+		if err := pm.WritePage(off, data[off]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := pm.Stats(); s.TablePages != 3 || s.FreePages != 0 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+
+	// The pages are still allocated after reopening the file
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pm, err = New(path); err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	b := make([]byte, pageSize)
+	for off, d := range data {
+		if err := pm.ReadPage(off, b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, d) {
+			t.Fatalf("data of page %v doesn't match", off)
+		}
+	}
+
+	// Pages that weren't allocated and buffers of the wrong size are
+	// rejected
+	var off int64
+	for off = range data {
+		break
+	}
+	for _, invalid := range []int64{0, off + 1, off + 100*pageSize} {
+		if err := pm.ReadPage(invalid, b); !errors.Is(err, errPageNotAllocated) {
+			t.Errorf("err for offset %v should be %v but was %v", invalid, errPageNotAllocated, err)
+		}
+	}
+	if err := pm.WritePage(off, b[:10]); !errors.Is(err, errWrongPageSize) {
+		t.Errorf("err should be %v but was %v", errWrongPageSize, err)
+	}
+
+	// Freed pages can't be accessed and are reused
+	if err := pm.FreePage(off); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.ReadPage(off, b); !errors.Is(err, errPageNotAllocated) {
+		t.Errorf("err should be %v but was %v", errPageNotAllocated, err)
+	}
+	if err := pm.FreePage(off); !errors.Is(err, errPageNotAllocated) {
+		t.Errorf("err should be %v but was %v", errPageNotAllocated, err)
+	}
+	if s := pm.Stats(); s.TablePages != 2 || s.FreePages != 1 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+	reused, err := pm.AllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused != off {
+		t.Errorf("page %v should have been reused but %v was allocated", off, reused)
+	}
+}
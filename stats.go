@@ -0,0 +1,140 @@
+package pages
+
+import (
+	"sync/atomic"
+)
+
+// Stats contains statistics about the space used by a PageManager. All sizes
+// are numbers of pages.
+type Stats struct {
+	// FilePages is the number of pages of the file
+	FilePages int64
+
+	// DataPages is the number of pages that contain the data of entries
+	DataPages int64
+
+	// TablePages is the number of pageTables of entries, the pages that
+	// store the leaves of their Merkle trees and the pages that were
+	// allocated using AllocatePage
+	TablePages int64
+
+	// MetadataPages is the number of pages used by the file's metadata. It
+	// includes the first pages of the file, the pages and pageTables of the
+	// bitmap and the idTable and the entryPages.
+	MetadataPages int64
+
+	// FreePages is the number of pages that can be reused. Pages are freed
+	// as soon as they are no longer used.
+	FreePages int64
+
+	// Entries is the number of entries. Entries of version 1 files are only
+	// counted once they were opened. Until then their pages are counted as
+	// TablePages.
+	Entries int64
+
+	// OpenEntries is the number of entries that are currently open
+	OpenEntries int64
+
+	// OpenInstances is the number of open Entry instances. An entry can be
+	// opened multiple times.
+	OpenInstances int64
+
+	// FreeRuns is the number of runs of contiguous free pages. The free space
+	// is more fragmented the more runs there are.
+	FreeRuns int64
+
+	// LargestFreeRun is the number of pages of the largest run of
+	// contiguous free pages
+	LargestFreeRun int64
+}
+
+// Stats returns statistics about the space used by the PageManager. It only
+// uses information that is kept in memory which makes it cheap enough to be
+// called periodically. The fragmentation of the free space is computed from a
+// copy of the bitmap after the PageManager was unlocked.
+func (p *PageManager) Stats() Stats {
+	p.lock()
+	s := Stats{
+		FilePages:   p.file.dataPage + p.bitmap.numPages,
+		DataPages:   atomic.LoadInt64(&p.atomicDataPages),
+		FreePages:   int64(p.bitmap.Len()),
+		OpenEntries: int64(len(p.entryPages)),
+	}
+	for _, ep := range p.entryPages {
+		s.OpenInstances += int64(ep.instanceCounter)
+	}
+	for _, off := range p.ids.offsets {
+		if off != 0 {
+			s.Entries++
+		}
+	}
+
+	// The pageTables and Merkle leaves of entries are the allocated pages
+	// that aren't used for anything else. The idTable's entryPage is part of
+	// the data area of files that were migrated.
+	s.MetadataPages = p.file.dataPage + s.Entries
+	if p.file.idTablePage >= p.file.dataPage {
+		s.MetadataPages++
+	}
+	for _, tp := range []*tieredPage{p.bitmap.tieredPage, p.ids.tieredPage} {
+		s.MetadataPages += int64(len(tp.pages) + len(tablePages(tp.root)))
+	}
+	s.TablePages = s.FilePages - s.FreePages - s.MetadataPages - s.DataPages
+	words := append([]uint64(nil), p.bitmap.words...)
+	numPages := p.bitmap.numPages
+	p.mu.Unlock()
+
+	s.FreeRuns, s.LargestFreeRun = freeRuns(words, numPages)
+	return s
+}
+
+// countDataPages counts the data pages of all the entries. It reads the root
+// entry of every entryPage.
+func (p *PageManager) countDataPages() error {
+	var dataPages int64
+	for _, off := range p.ids.offsets {
+		if off == 0 {
+			continue
+		}
+		pp := &physicalPage{
+			file:     p.file,
+			fileOff:  off,
+			usedSize: pageSize,
+		}
+		usedSize, _, _, err := readTieredPageRoot(pp)
+		if err != nil {
+			return extendErr("failed to read entry", err)
+		}
+		dataPages += (usedSize + pageSize - 1) / pageSize
+	}
+	atomic.StoreInt64(&p.atomicDataPages, dataPages)
+	return nil
+}
+
+// freeRuns returns the number of runs of contiguous free pages and the length
+// of the longest run within the first numPages bits of words. Pages that
+// aren't covered by words are free.
+func freeRuns(words []uint64, numPages int64) (runs, longest int64) {
+	var length int64
+	for i := int64(0); i < numPages; i++ {
+		// Skip words without free pages
+		w := i / 64
+		if i%64 == 0 && w < int64(len(words)) && words[w] == ^uint64(0) {
+			i += 63
+			length = 0
+			continue
+		}
+		if w < int64(len(words)) && words[w]&(1<<uint(i%64)) != 0 {
+			length = 0
+			continue
+		}
+		if length == 0 {
+			runs++
+		}
+		length++
+		if length > longest {
+			longest = length
+		}
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package pages
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// TestStats tests if Stats accounts for all the pages of the file
+func TestStats(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checkStats is a helper function that compares the stats to the
+	// expected values and makes sure all the pages are accounted for
+	checkStats := func(pm *PageManager, dataPages, entries, openInstances int64) Stats {
+		t.Helper()
+		s := pm.Stats()
+		if s.DataPages != dataPages || s.Entries != entries || s.OpenInstances != openInstances {
+			t.Fatalf("wrong stats %+v", s)
+		}
+		stat, err := pm.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.FilePages*pageSize != stat.Size() {
+			t.Fatalf("file should have %v pages but had %v", stat.Size()/pageSize, s.FilePages)
+		}
+		if s.TablePages < 0 || s.FilePages != s.DataPages+s.TablePages+s.MetadataPages+s.FreePages {
+			t.Fatalf("pages aren't accounted for %+v", s)
+		}
+		return s
+	}
+
+	// A new file only contains metadata
+	s := checkStats(pt.pm, 0, 0, 0)
+	if s.FreePages != 0 || s.TablePages != 0 || s.FreeRuns != 0 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+
+	// Write to some entries and open one of them twice
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes((numPageEntries + 10) * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	entry2, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry2.Write(fastrand.Bytes(10)); err != nil {
+		t.Fatal(err)
+	}
+	entry3, err := pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = checkStats(pt.pm, numPageEntries+11, 2, 3)
+	if s.OpenEntries != 2 || s.TablePages == 0 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+
+	// Truncating frees pages
+	if err := entry.Truncate(5 * pageSize); err != nil {
+		t.Fatal(err)
+	}
+	s = checkStats(pt.pm, 6, 2, 3)
+	if s.FreePages < numPageEntries+5 || s.FreeRuns == 0 || s.LargestFreeRun == 0 || s.LargestFreeRun > s.FreePages {
+		t.Fatalf("wrong stats %+v", s)
+	}
+
+	// The stats should be the same after reopening the file
+	for _, e := range []*Entry{entry, entry2, entry3} {
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pt.pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := New(pt.pm.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	reopened := checkStats(pm, 6, 2, 0)
+	s.OpenEntries, s.OpenInstances = 0, 0
+	if reopened != s {
+		t.Fatalf("stats should be %+v but were %+v", s, reopened)
+	}
+}
@@ -3,13 +3,12 @@ package pages
 // TODO whenever usedSize changes update the entry on disk
 
 import (
+	"context"
 	"encoding/binary"
-	"errors"
 	"fmt"
-	"math"
+	"sort"
 	"sync"
-
-	"github.com/NebulousLabs/Sia/build"
+	"sync/atomic"
 )
 
 type (
@@ -28,9 +27,17 @@ type (
 		// pm is the pageManager
 		pm *PageManager
 
+		// id is the Identifier of the entry the tree belongs to. It is zero
+		// for the trees of the file's metadata.
+		id Identifier
+
 		// pages is a list of all the physical pages of the tree
 		pages []*physicalPage
 
+		// reservedTables are pages that were allocated in advance for the
+		// pageTables that adding pages to the tree creates
+		reservedTables []*physicalPage
+
 		// mu is used to lock all operations on the entries
 		mu *sync.RWMutex
 	}
@@ -43,17 +50,29 @@ type (
 		// atomicInstanceCounter counts the number of open references to the
 		// entryPage. It is increased in Open and decreased in Close
 		instanceCounter uint64
-	}
 
-	// recyclingPage is a tiered page that stores all the free pages
-	recyclingPage struct {
-		// recyclingPage is a tieredPage
-		*tieredPage
+		// attrs are the user-defined attributes of the entry
+		attrs map[string][]byte
+
+		// merkleTree is the Merkle tree over the entry's pages. It is only
+		// loaded when it is needed and nil until then.
+		merkleTree *merkleTree
 
-		// pagesToFree is a buffer that is filled with pageTables that are
-		// freed during the process of getting a free page from the
-		// recyclingPage.
-		pagesToFree []*physicalPage
+		// merkleDirty contains the indices of the leaves of merkleTree that
+		// changed since the tree was updated
+		merkleDirty map[int]struct{}
+
+		// merkleLeaves stores the leaves of the Merkle tree. It is nil until
+		// the root of the entry is requested for the first time.
+		merkleLeaves *merkleLeaves
+
+		// merkleRootValid indicates if the Merkle root stored in the
+		// entryPage is up to date
+		merkleRootValid bool
+
+		// merkleMu protects the Merkle tree. Writes that don't add pages only
+		// hold the read lock of mu.
+		merkleMu *sync.Mutex
 	}
 )
 
@@ -67,100 +86,254 @@ func (ep *entryPage) addPages(pages []*physicalPage, addedBytes int64) error {
 
 	// Sanity check length of ep.pages
 	if int(ep.nextIndex())+len(pages) != len(ep.pages) {
-		panic("ep.pages should already contain the updated number of pages")
+		return critical("ep.pages should already contain the updated number of pages")
 	}
 
 	// Add the pages to the entryPage
 	index := ep.nextIndex()
+	height := ep.root.height
 	for _, page := range pages {
-		root := ep.root
 		if err := ep.insertPage(index, page); err != nil {
-			return build.ExtendErr("failed to insert page", err)
-		}
-
-		// Check if root changed. If it did write down the entry for the last
-		// root with it's max value for usedBytes before changing ep.root.
-		if root != ep.root {
-			bytesUsed := int64(maxPages(root.height) * pageSize)
-			if err := writeTieredPageEntry(ep.pp, root.height, bytesUsed, root.pp.fileOff); err != nil {
-				return err
-			}
+			return extendErr("failed to insert page", err)
 		}
 		index++
 	}
 
 	// Increment the usedSize
 	ep.usedSize += addedBytes
+	atomic.AddInt64(&ep.pm.atomicDataPages, int64(len(pages)))
 
 	// Write the root
-	return writeTieredPageEntry(ep.pp, ep.root.height, ep.usedSize, ep.root.pp.fileOff)
+	return ep.writeRoot(height)
 }
 
-// AddPages adds multiple physical pages to the tree and increments the
-// usedSize of the entryPage. The ep.mu write lock needs to be acquired if
-// len(pages) > 0 otherwise the read lock will suffice
-func (rp *recyclingPage) addPages(pages []*physicalPage) error {
-	// Stop recycling while pages are added
-	rp.pm.recyclePages = false
-	defer func() {
-		rp.pm.recyclePages = true
-	}()
+// invalidateMerkleLeaves marks the leaves of the pages within the range
+// [start, end) as changed. It is called before the pages are written to which
+// makes sure that the stored root and leaves are never outdated after a
+// crash. The leaves of pages that were added to the entry are stored right
+// away using the pages that were reserved for them. The ep.mu read lock needs
+// to be acquired or the write lock if pages were added.
+func (ep *entryPage) invalidateMerkleLeaves(start, end int) error {
+	ep.merkleMu.Lock()
+	defer ep.merkleMu.Unlock()
+	if ep.merkleRootValid {
+		ep.merkleRootValid = false
+		if err := writeMerkleRoot(ep.pp, Hash{}, false); err != nil {
+			return err
+		}
+	}
+	if ep.merkleTree != nil {
+		for i := start; i < end; i++ {
+			ep.merkleDirty[i] = struct{}{}
+		}
+	}
+
+	// Leaves that aren't stored yet are hashed anyway
+	if ep.merkleLeaves == nil {
+		return nil
+	}
+	if n := ep.merkleLeaves.numLeaves(); end > n {
+		if start > n {
+			start = n
+		}
+		ep.pm.lock()
+		defer ep.pm.mu.Unlock()
+	}
+	if start >= end {
+		return nil
+	}
+	return ep.merkleLeaves.write(start, make([]Hash, end-start))
+}
 
-	// Add pages to rp.pages
-	rp.pages = append(rp.pages, pages...)
+// truncateMerkleLeaves removes the leaves of the pages that were removed from
+// the entry. The ep.mu write lock needs to be acquired.
+func (ep *entryPage) truncateMerkleLeaves() error {
+	ep.merkleMu.Lock()
+	defer ep.merkleMu.Unlock()
+	n := len(ep.pages)
+	if ep.merkleTree != nil && ep.merkleTree.numLeaves() > n {
+		if err := ep.merkleTree.truncate(n); err != nil {
+			return err
+		}
+	}
+	for i := range ep.merkleDirty {
+		if i >= n {
+			delete(ep.merkleDirty, i)
+		}
+	}
+	if ep.merkleLeaves == nil {
+		return nil
+	}
+	ep.pm.lock()
+	defer ep.pm.mu.Unlock()
+	return ep.merkleLeaves.truncate(n)
+}
 
-	// Otherwise add the pages to the entryPage
-	index := rp.nextIndex()
-	for _, page := range pages {
-		// free pages are treated as if they were full
-		page.usedSize = pageSize
+// createMerkleLeaves creates the merkleLeaves of the entry and stores their
+// offset in the entryPage. The ep.mu write lock and merkleMu need to be
+// acquired.
+func (ep *entryPage) createMerkleLeaves() error {
+	ep.pm.lock()
+	defer ep.pm.mu.Unlock()
+	ml, err := newMerkleLeaves(ep.pm)
+	if err != nil {
+		return err
+	}
+	if err := writeMerkleLeavesOff(ep.pp, ml.pp.fileOff); err != nil {
+		if err := ep.pm.bitmap.free(ml.allPages()); err != nil {
+			return extendErr("failed to free merkleLeaves", err)
+		}
+		return err
+	}
+	ep.merkleLeaves = ml
+	return nil
+}
 
-		root := rp.root
-		if err := rp.insertPage(index, page); err != nil {
-			return build.ExtendErr("failed to insert page", err)
+// updateMerkleTree brings the Merkle tree up to date and writes its root to
+// disk. Only the pages whose leaves changed since they were hashed are read.
+// If the tree isn't loaded yet, it is built from the stored leaves. The ep.mu
+// write lock and merkleMu need to be acquired.
+func (ep *entryPage) updateMerkleTree() error {
+	if ep.merkleLeaves == nil {
+		if err := ep.createMerkleLeaves(); err != nil {
+			return extendErr("failed to create merkleLeaves", err)
 		}
+	}
 
-		// Check if root changed. If it did write down the entry for the last
-		// root with it's max value for usedBytes before changing ep.root.
-		if root != rp.root {
-			bytesUsed := int64(maxPages(root.height) * pageSize)
-			if err := writeTieredPageEntry(rp.pp, root.height, bytesUsed, root.pp.fileOff); err != nil {
-				return err
+	// Find the leaves that need to be hashed
+	numPages := len(ep.pages)
+	var leaves []Hash
+	var changed []int
+	if ep.merkleTree == nil {
+		stored, err := ep.merkleLeaves.read()
+		if err != nil {
+			return extendErr("failed to read merkleLeaves", err)
+		}
+		if len(stored) > numPages {
+			stored = stored[:numPages]
+		}
+		leaves = append(stored, make([]Hash, numPages-len(stored))...)
+		for i, h := range leaves {
+			if h == (Hash{}) {
+				changed = append(changed, i)
 			}
 		}
-		index++
+	} else {
+		for i := range ep.merkleDirty {
+			if i < ep.merkleTree.numLeaves() {
+				changed = append(changed, i)
+			}
+		}
+		sort.Ints(changed)
+		for i := ep.merkleTree.numLeaves(); i < numPages; i++ {
+			changed = append(changed, i)
+		}
+		leaves = make([]Hash, numPages)
 	}
-	// Increment the usedSize
-	rp.usedSize += int64(len(pages)) * pageSize
 
-	// Write the root
-	return writeTieredPageEntry(rp.pp, rp.root.height, rp.usedSize, rp.root.pp.fileOff)
+	// Hash the changed pages
+	for _, i := range changed {
+		h, err := pageLeafHash(ep.pages[i])
+		if err != nil {
+			return extendErr("failed to hash page", err)
+		}
+		leaves[i] = h
+		if ep.merkleTree == nil {
+			continue
+		}
+		if err := ep.merkleTree.setLeaf(i, h); err != nil {
+			return err
+		}
+	}
+	if ep.merkleTree == nil {
+		ep.merkleTree = newMerkleTree(leaves)
+	}
+	ep.merkleDirty = make(map[int]struct{})
+
+	// Store the new leaves
+	ep.pm.lock()
+	err := ep.merkleLeaves.truncate(numPages)
+	for i := 0; i < len(changed) && err == nil; {
+		j := i + 1
+		for j < len(changed) && changed[j] == changed[j-1]+1 {
+			j++
+		}
+		err = ep.merkleLeaves.write(changed[i], leaves[changed[i]:changed[j-1]+1])
+		i = j
+	}
+	ep.pm.mu.Unlock()
+	if err != nil {
+		return extendErr("failed to write merkleLeaves", err)
+	}
+	ep.merkleRootValid = true
+	return writeMerkleRoot(ep.pp, ep.merkleTree.root(), true)
+}
+
+// pageLeafHash reads the used data of a page and returns its leaf hash
+func pageLeafHash(pp *physicalPage) (Hash, error) {
+	data := make([]byte, pp.usedSize)
+	if len(data) > 0 {
+		if _, err := pp.readAt(data, 0); err != nil {
+			return Hash{}, err
+		}
+	}
+	return leafHash(data), nil
+}
+
+// lock acquires the write lock of mu and records the time spent waiting for
+// it
+func (tp *tieredPage) lock() {
+	tp.pm.metrics.wait(MetricEntryLockWait, tp.mu.Lock)
+}
+
+// rLock acquires the read lock of mu and records the time spent waiting for
+// it
+func (tp *tieredPage) rLock() {
+	tp.pm.metrics.wait(MetricEntryLockWait, tp.mu.RLock)
+}
+
+// writeRoot writes the entry of the tree's root. If the tree grew since it
+// had the given height, the entries of the previous roots are cleared
+// afterwards. Until they are cleared the previous root is still used.
+func (tp *tieredPage) writeRoot(height int64) error {
+	if err := writeTieredPageRoot(tp.pp, tp.root.height, tp.usedSize, tp.root.pp.fileOff); err != nil {
+		return err
+	}
+	for ; height < tp.root.height; height++ {
+		if err := clearTieredPageEntry(tp.pp, height); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // defrag needs to be called after entry operation that possibly removes
 // pageTables from the tree. It writes the current usedSize to disk and reduces
 // the height of the tree if possible. Pages freed during defrag will be
 // returned.
-func (tp *tieredPage) defrag() ([]*physicalPage, error) {
+func (tp *tieredPage) defrag() (pagesToFree []*physicalPage, err error) {
+	span := tp.pm.startSpan(SpanDefrag, SpanInfo{ID: tp.id, PageOffset: tp.root.pp.fileOff, Bytes: tp.usedSize})
+	defer func() {
+		span.End(SpanInfo{ID: tp.id, PageOffset: tp.root.pp.fileOff, Bytes: tp.usedSize}, err)
+	}()
+
 	// Write current usedSize to disk
-	if err := writeTieredPageEntry(tp.pp, tp.root.height, tp.usedSize, tp.root.pp.fileOff); err != nil {
+	if err := writeTieredPageRoot(tp.pp, tp.root.height, tp.usedSize, tp.root.pp.fileOff); err != nil {
 		return nil, err
 	}
 
 	// Defrag until the root node has multiple children
-	var err error
-	var pagesToFree []*physicalPage
 	for tp.root.height > 0 && len(tp.root.childTables) == 1 {
 		child := tp.root.childTables[0]
 
 		// Write the previous pageEntry's entry
-		err = writeTieredPageEntry(tp.pp, child.height, tp.usedSize, child.pp.fileOff)
+		err = writeTieredPageRoot(tp.pp, child.height, tp.usedSize, child.pp.fileOff)
 		if err != nil {
 			return nil, err
 		}
 
-		// Zero out the current entry
-		err = writeTieredPageEntry(tp.pp, tp.root.height, 0, 0)
+		// Clear the current entry
+		err = clearTieredPageEntry(tp.pp, tp.root.height)
 		if err != nil {
 			return nil, err
 		}
@@ -178,150 +351,235 @@ func (tp *tieredPage) defrag() ([]*physicalPage, error) {
 	return pagesToFree, nil
 }
 
-// availablePages returns the amount of free pages in the recyclingPage
-func (rp *recyclingPage) availablePages() int {
-	return len(rp.pagesToFree) + len(rp.pages)
-}
-
 // nextIndex returns the next index that can be used to insert a page into the
-// tiered page
+// tiered page. The last page might not be full.
 func (tp *tieredPage) nextIndex() uint64 {
-	return uint64(tp.usedSize / pageSize)
+	return uint64((tp.usedSize + pageSize - 1) / pageSize)
 }
 
-// maxPages return the number of pages the tree can contain
-func (tp *tieredPage) maxPages() uint64 {
-	return maxPages(tp.root.height)
-}
-
-// cap returns the number of pages a tree with a certain height can contain.
-// The height starts at 0. This means a simple tree with 1 root node and
-// numPageEntries leaves would have height 1
-func maxPages(height int64) uint64 {
-	return uint64(math.Pow(numPageEntries, float64(height+1)))
-}
+// insertePage is a helper function that inserts a page at the end of the
+// pageTable tree. If the tree is full, it is extended first.
+func (tp *tieredPage) insertPage(index uint64, pp *physicalPage) (err error) {
+	span := tp.pm.startSpan(SpanInsertPage, SpanInfo{ID: tp.id, PageOffset: pp.fileOff, Offset: int64(index) * pageSize, Bytes: pp.usedSize})
+	defer func() {
+		span.End(SpanInfo{ID: tp.id, PageOffset: pp.fileOff, Offset: int64(index) * pageSize, Bytes: pp.usedSize}, err)
+	}()
 
-// insertePage is a helper function that inserts a page into the pageTable
-// tree. It returns an error to indicate if the root changed.
-func (tp *tieredPage) insertPage(index uint64, pp *physicalPage) error {
-	// Calculate the maximum number of pages the tree can contain at the moment
-	// If the index is too large we need to extend the tree before we can
-	// insert the page
-	for maxPages := tp.maxPages(); index >= maxPages; maxPages = tp.maxPages() {
-		newRoot, err := extendPageTableTree(tp.root, tp.pm)
+	for {
+		inserted, err := tp.appendPage(tp.root, index, pp)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			return nil
+		}
+		newRoot, err := extendPageTableTree(tp.root, tp)
 		if err != nil {
-			return build.ExtendErr("Failed to extend the pageTable tree", err)
+			return extendErr("Failed to extend the pageTable tree", err)
 		}
 		tp.root = newRoot
 	}
+}
 
-	// Search the tree for the correct pageTable to insert the page
-	pt := tp.root
-	var tableIndex uint64
-	var pageIndex = index
-	for pt.height > 0 {
-		tableIndex = pageIndex / maxPages(pt.height-1)
-		pageIndex /= numPageEntries
-
-		// Check if the pageTable exists. If it doesn't, we have to create it
-		_, exists := pt.childTables[tableIndex]
-		if !exists {
-			newPt, err := newPageTable(pt.height-1, pt, tp.pm)
-			if err != nil {
-				return build.ExtendErr("failed to create a new pageTable", err)
-			}
-			pt.childTables[tableIndex] = newPt
-			if err := pt.writeToDisk(); err != nil {
-				return build.ExtendErr("failed to write pageTable to disk", err)
-			}
+// appendPage appends a page to the last pageTable of pt's subtree, creating
+// new pageTables if necessary. It returns false if the subtree is full.
+func (tp *tieredPage) appendPage(pt *pageTable, index uint64, pp *physicalPage) (bool, error) {
+	if pt.height == 0 {
+		// Sanity check the index
+		if index != pt.firstPage+uint64(len(pt.childPages)) {
+			return false, critical("inserting shouldn't create a gap: index %v", index)
+		}
+		if pt.full(pp) {
+			return false, nil
 		}
-		pt = pt.childTables[tableIndex]
+		pt.appendPage(pp)
+		return true, pt.writeToDisk()
 	}
 
-	// Sanity check the child pages
-	if len(pt.childPages) == numPageEntries {
-		panic(fmt.Sprintf("We shouldn't insert if childPages is already full: index %v", index))
+	// Try to append the page to the last child
+	if numChildren := len(pt.childTables); numChildren > 0 {
+		inserted, err := tp.appendPage(pt.childTables[uint64(numChildren-1)], index, pp)
+		if err != nil || inserted {
+			return inserted, err
+		}
 	}
-	if len(pt.childPages) > 0 && pt.childPages[index%numPageEntries-1] == nil {
-		panic("Inserting shouldn't create a gap")
+	if len(pt.childTables) == numPageEntries {
+		return false, nil
 	}
 
-	// Insert page
-	pt.childPages[index%numPageEntries] = pp
+	// Create a new child for the page
+	newPt, err := tp.newPageTable(pt.height-1, pt)
+	if err != nil {
+		return false, extendErr("failed to create a new pageTable", err)
+	}
+	newPt.firstPage = index
+	if inserted, err := tp.appendPage(newPt, index, pp); err != nil {
+		return false, err
+	} else if !inserted {
+		return false, critical("page doesn't fit into a new pageTable: index %v", index)
+	}
+	pt.childTables[uint64(len(pt.childTables))] = newPt
 	if err := pt.writeToDisk(); err != nil {
-		return err
+		return false, extendErr("failed to write pageTable to disk", err)
 	}
-	return nil
+	return true, nil
 }
 
-// removePage removes a page at a given index from the tree and returns the
-// deleted page
-func (rp *recyclingPage) freePage() (page *physicalPage, err error) {
-	if rp.availablePages() == 0 {
-		return nil, errors.New("ran out of free pages")
+// newPageTable creates a pageTable for the tree. It uses the reserved pages
+// before allocating a new one.
+func (tp *tieredPage) newPageTable(height int64, parent *pageTable) (*pageTable, error) {
+	if n := len(tp.reservedTables); n > 0 {
+		pp := tp.reservedTables[n-1]
+		tp.reservedTables = tp.reservedTables[:n-1]
+		return newPageTableAt(height, parent, pp)
 	}
+	return newPageTable(height, parent, tp.pm)
+}
 
-	// Make sure that the usedSize of the returned page is always 0
-	defer func() {
-		if page != nil {
-			page.usedSize = 0
+// tablesNeeded returns the number of pageTables that appending pages to the
+// tree creates. It follows the steps of insertPage without modifying the
+// tree.
+func (tp *tieredPage) tablesNeeded(pages []*physicalPage) int {
+	// node is a pageTable on the rightmost path of the tree which is the
+	// only part of the tree that changes. leaf contains the extents of a
+	// node with height 0.
+	type node struct {
+		height   int64
+		children int
+		leaf     *pageTable
+	}
+	newNode := func(height int64, children int, extents []extent) *node {
+		n := &node{height: height, children: children}
+		if height == 0 {
+			n.leaf = &pageTable{extents: extents}
+		}
+		return n
+	}
+	var path []*node
+	for pt := tp.root; ; pt = pt.childTables[uint64(len(pt.childTables)-1)] {
+		extents := append([]extent(nil), pt.extents...)
+		path = append(path, newNode(pt.height, len(pt.childTables), extents))
+		if pt.height == 0 || len(pt.childTables) == 0 {
+			break
+		}
+	}
+
+	// appendPage returns true if pp was appended to the subtree of path[i]
+	// and the number of tables that were created for it
+	var appendPage func(i int, pp *physicalPage) (bool, int)
+	appendPage = func(i int, pp *physicalPage) (bool, int) {
+		n := path[i]
+		if n.height == 0 {
+			if n.leaf.full(pp) {
+				return false, 0
+			}
+			if n.leaf.canMerge(pp) {
+				n.leaf.extents[len(n.leaf.extents)-1].count++
+			} else {
+				n.leaf.extents = append(n.leaf.extents, extent{first: pp, count: 1})
+			}
+			return true, 0
+		}
+		if n.children > 0 {
+			if inserted, created := appendPage(i+1, pp); inserted {
+				return true, created
+			}
+		}
+		if n.children == numPageEntries {
+			return false, 0
 		}
-	}()
 
-	// Return a page from the buffer if possible
-	if len(rp.pagesToFree) > 0 {
-		p := rp.pagesToFree[len(rp.pagesToFree)-1]
-		rp.pagesToFree = rp.pagesToFree[:len(rp.pagesToFree)-1]
-		return p, nil
+		// Create a new child and the tables below it
+		n.children++
+		path = path[:i+1]
+		for height := n.height - 1; height > 0; height-- {
+			path = append(path, newNode(height, 1, nil))
+		}
+		path = append(path, newNode(0, 0, []extent{{first: pp, count: 1}}))
+		return true, int(n.height)
 	}
 
-	page = rp.pages[len(rp.pages)-1]
+	var tables int
+	for _, pp := range pages {
+		for {
+			inserted, created := appendPage(0, pp)
+			tables += created
+			if inserted {
+				break
+			}
 
-	// Truncate by 1 page
-	_, pagesToFree1, err := rp.recursiveTruncate(rp.root, rp.usedSize-pageSize)
-	if err != nil {
-		return nil, err
+			// Extend the tree by adding a new root
+			path = append([]*node{newNode(path[0].height+1, 1, nil)}, path...)
+			tables++
+		}
 	}
+	return tables
+}
 
-	// The first truncated page is the one we would like to return so we
-	// shouldn't add it to the buffer
-	if pagesToFree1[0] != page {
-		panic("sanity check failed. Truncated page doesn't match the page to return")
+// leafPageTable returns the pageTable at the bottom of the tree that points
+// to the page at a given index
+func (tp *tieredPage) leafPageTable(index uint64) *pageTable {
+	pt := tp.root
+	for pt.height > 0 {
+		i := sort.Search(len(pt.childTables), func(i int) bool {
+			return pt.childTables[uint64(i)].firstPage > index
+		})
+		if i > 0 {
+			i--
+		}
+		pt = pt.childTables[uint64(i)]
 	}
-	pagesToFree1 = pagesToFree1[1:]
+	return pt
+}
 
-	// Defrag tree
-	pagesToFree2, err := rp.defrag()
-	if err != nil {
-		return nil, err
+// compressPages marks the pages of the tree to be compressed when they are
+// written. Pages within extents of multiple pages stay uncompressed since
+// compressing them would split the extent.
+func (tp *tieredPage) compressPages(pt *pageTable) {
+	if pt.height > 0 {
+		for _, child := range pt.childTables {
+			tp.compressPages(child)
+		}
+		return
+	}
+	for _, e := range pt.extents {
+		if e.count == 1 {
+			e.first.compress = true
+		}
 	}
+}
 
-	// Append free pages to buffer
-	rp.pagesToFree = append(rp.pagesToFree, append(pagesToFree1, pagesToFree2...)...)
-	return page, nil
+// updatePageTables writes the pageTables that point to the pages at the given
+// indices to disk. It needs to be called after the storedSize of existing
+// pages changed.
+func (tp *tieredPage) updatePageTables(indices map[uint64]struct{}) error {
+	updated := make(map[*pageTable]struct{})
+	for index := range indices {
+		pt := tp.leafPageTable(index)
+		if _, exists := updated[pt]; exists {
+			continue
+		}
+		if err := pt.writeToDisk(); err != nil {
+			return extendErr("failed to update pageTable", err)
+		}
+		updated[pt] = struct{}{}
+	}
+	return nil
 }
 
 // readEntryPageEntry reads the usedBytes of a pageTable and a ptr to the
-// pageTable at a specific offset of a page from disk
-func readEntryPageEntry(pp *physicalPage, index int64) (usedBytes int64, pageOff int64, err error) {
+// pageTable at a specific offset of a page from disk. The ptr still contains
+// the entryRootFlag.
+func readEntryPageEntry(pp *physicalPage, index int64) (usedBytes int64, pageOff int64, valid bool, err error) {
 	// Read the data from disk
 	entryData := make([]byte, tieredPageEntrySize)
 	_, err = pp.readAt(entryData, index*tieredPageEntrySize)
 	if err != nil {
 		return
 	}
-
-	// Unmarshal the usedBytes
-	var bytesRead int
-	if usedBytes, bytesRead = binary.Varint(entryData[0:8]); usedBytes == 0 && bytesRead <= 0 {
-		err = errors.New("Failed to unmarshal usedBytes")
-		return
-	}
-
-	// Unmarshal the pageOff
-	if pageOff, bytesRead = binary.Varint(entryData[8:]); pageOff == 0 && bytesRead <= 0 {
-		err = errors.New("Failed to unmarshal entryData")
-		return
+	usedBytes, pageOff, valid, err = unmarshalTieredPageEntry(entryData)
+	if err != nil {
+		err = pageError(err, pp.fileOff, "failed to unmarshal entry %v", index)
 	}
 	return
 }
@@ -332,12 +590,22 @@ func readPageTable(pp *physicalPage) (entries []int64, err error) {
 	if _, err := pp.readAt(pageData, 0); err != nil {
 		return nil, err
 	}
-	return unmarshalPageTable(pageData)
+	// Tables of version 1 files aren't marked with pageTableFlag. They are
+	// read in both formats since migrating a tree rewrites its tables first.
+	if binary.LittleEndian.Uint64(pageData)&pageTableFlag == 0 {
+		entries, err = unmarshalPageTableV1(pageData)
+	} else {
+		entries, err = unmarshalPageTable(pageData, pp.file.slotSize())
+	}
+	if err != nil {
+		return nil, pageError(err, pp.fileOff, "failed to unmarshal pageTable")
+	}
+	return entries, nil
 }
 
 // recoverTree recovers the pageTable tree recursively starting at the offset
 // of a pageTable
-func (tp *tieredPage) recoverTree(rootOff int64, height int64) (err error) {
+func (tp *tieredPage) recoverTree(ctx context.Context, rootOff int64, height int64) (err error) {
 	// Get the physicalPage for the rootOff
 	pp := &physicalPage{
 		file:     tp.pp.file,
@@ -355,19 +623,34 @@ func (tp *tieredPage) recoverTree(rootOff int64, height int64) (err error) {
 	}
 
 	// Recover the tree recursively
+	span := tp.pm.startSpan(SpanRecoverTree, SpanInfo{ID: tp.id, PageOffset: rootOff, Bytes: tp.usedSize})
+	defer func() {
+		span.End(SpanInfo{ID: tp.id, PageOffset: rootOff, Bytes: tp.usedSize}, err)
+	}()
 	remainingBytes := tp.usedSize
-	tp.pages, err = recursiveRecovery(root, height, &remainingBytes)
+	tp.pages, err = recursiveRecovery(ctx, root, height, &remainingBytes)
 	if err != nil {
 		return
 	}
+	if remainingBytes > 0 {
+		return pageError(ErrCorrupted, rootOff, "tree is missing pages for %v bytes", remainingBytes)
+	}
 
 	tp.root = root
 	return
 }
 
 // recursiveRecovery is a helper function for recoverTree to recursively
-// recover pageTables starting from a specific parent
-func recursiveRecovery(parent *pageTable, height int64, remainingBytes *int64) (pages []*physicalPage, err error) {
+// recover pageTables starting from a specific parent. It stops if ctx is
+// cancelled.
+func recursiveRecovery(ctx context.Context, parent *pageTable, height int64, remainingBytes *int64) (pages []*physicalPage, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if height < 0 {
+		return nil, pageError(ErrCorrupted, parent.pp.fileOff, "pageTable has negative height %v", height)
+	}
+
 	// Get the type and children of the table
 	entries, err := readPageTable(parent.pp)
 	if err != nil {
@@ -375,62 +658,68 @@ func recursiveRecovery(parent *pageTable, height int64, remainingBytes *int64) (
 	}
 
 	// load children as pageTables
-	for _, offset := range entries {
+	for _, slot := range entries {
+		offset, storedSize := decodeSlot(slot)
 		pp := &physicalPage{
-			file:     parent.pp.file,
-			fileOff:  offset,
-			usedSize: pageSize,
+			file:       parent.pp.file,
+			fileOff:    offset,
+			usedSize:   pageSize,
+			storedSize: storedSize,
 		}
 
 		// Load children as pageTable
 		if height > 0 {
 			pt := &pageTable{
-				height:      height,
+				height:      height - 1,
 				parent:      parent,
 				childTables: make(map[uint64]*pageTable),
 				childPages:  make(map[uint64]*physicalPage),
 				pp:          pp,
+				firstPage:   parent.firstPage + uint64(len(pages)),
 			}
 
-			p, err := recursiveRecovery(pt, height-1, remainingBytes)
+			p, err := recursiveRecovery(ctx, pt, height-1, remainingBytes)
 			if err != nil {
 				return nil, err
 			}
 			pages = append(pages, p...)
 
 			// Set parent's fields
-			parent.childTables[uint64(len(parent.childTables)-1)] = pt
+			parent.childTables[uint64(len(parent.childTables))] = pt
 			continue
 		}
 
 		// Load children as pages
-		if height == 0 {
-			if *remainingBytes > pageSize {
-				pp.usedSize = pageSize
-				*remainingBytes -= pageSize
-			} else {
-				pp.usedSize = *remainingBytes
-				*remainingBytes = 0
-			}
-			// Set parent's fields
-			parent.childPages[uint64(len(parent.childPages)-1)] = pp
-			pages = append(pages, pp)
-			continue
-		}
-
-		// Sanity check
-		if height < 0 {
-			panic("Sanity check failed. Height cannot be a negative value")
+		if *remainingBytes > pageSize {
+			pp.usedSize = pageSize
+			*remainingBytes -= pageSize
+		} else {
+			pp.usedSize = *remainingBytes
+			*remainingBytes = 0
 		}
+		// Set parent's fields
+		parent.appendPage(pp)
+		pages = append(pages, pp)
 	}
 
 	return
 }
 
 // recursiveTruncate is a helper function that recursively walks over the
-// allocated pages and deletes them until a certain size is reached
-func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*physicalPage, error) {
-	var pagesToFree []*physicalPage
+// allocated pages and deletes them until a certain size is reached. If ctx is
+// cancelled it stops removing pages and returns ctx.Err(). The tree is
+// consistent on disk afterwards, so the caller can continue with a partially
+// truncated tree.
+func (tp *tieredPage) recursiveTruncate(ctx context.Context, pt *pageTable, size int64) (empty bool, pagesToFree []*physicalPage, err error) {
+	// Trace the truncation of the whole tree
+	if pt == tp.root {
+		oldSize := tp.usedSize
+		span := tp.pm.startSpan(SpanTruncate, SpanInfo{ID: tp.id, PageOffset: pt.pp.fileOff, Offset: size, Bytes: oldSize - size})
+		defer func() {
+			span.End(SpanInfo{ID: tp.id, PageOffset: pt.pp.fileOff, Offset: tp.usedSize, Bytes: oldSize - tp.usedSize}, err)
+		}()
+	}
+
 	// Call recursiveTruncate on child tables
 	if pt.height > 0 {
 		for i := uint64(len(pt.childTables)) - 1; i >= 0; i-- {
@@ -440,11 +729,11 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 			}
 
 			// Otherwise call truncate recursively
-			empty, freePages, err := tp.recursiveTruncate(pt.childTables[i], size)
+			empty, freePages, err := tp.recursiveTruncate(ctx, pt.childTables[i], size)
+			pagesToFree = append(pagesToFree, freePages...)
 			if err != nil {
 				return false, pagesToFree, err
 			}
-			pagesToFree = append(pagesToFree, freePages...)
 
 			// If the child is empty now we can remove it from the tree and
 			// free its page
@@ -471,10 +760,15 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 
 	// Start removing pages
 	if pt.height == 0 {
+		empty := false
+		var err error
 		for i := uint64(len(pt.childPages)) - 1; i >= 0; i-- {
-			// Stop if entry is small enough
+			// Stop if entry is small enough or if we were interrupted
 			if tp.usedSize <= size {
-				return false, pagesToFree, nil
+				break
+			}
+			if err = ctx.Err(); err != nil {
+				break
 			}
 			page := pt.childPages[i]
 
@@ -488,14 +782,14 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 			}
 
 			// Remove the page from the entry's pages and the pageTable
-			delete(pt.childPages, i)
+			pt.removeLastPage()
 			removed := tp.pages[len(tp.pages)-1]
 			tp.pages = tp.pages[:len(tp.pages)-1]
 
 			// Sanity check. Removed pages should be the same
 			if removed.fileOff != page.fileOff {
-				panic(fmt.Sprintf("removed pages weren't the same %v != %v",
-					removed.fileOff, page.fileOff))
+				return false, pagesToFree, critical("removed pages weren't the same %v != %v",
+					removed.fileOff, page.fileOff)
 			}
 
 			// add the page to pageToFree
@@ -504,23 +798,31 @@ func (tp *tieredPage) recursiveTruncate(pt *pageTable, size int64) (bool, []*phy
 			// Clear the removed page
 			tp.usedSize -= page.usedSize
 
-			// If the childTables are empty we can return right away
+			// If the childTables are empty we can stop right away
 			if len(pt.childPages) == 0 {
-				return true, pagesToFree, nil
+				empty = true
+				break
 			}
 		}
-		return false, pagesToFree, nil
+
+		// Update pt on disk
+		if err := pt.writeToDisk(); err != nil {
+			return false, pagesToFree, err
+		}
+		return empty, pagesToFree, err
 	}
 
 	// sanity check height
-	panic("sanity check failed. height can't be a negative value.")
+	return false, pagesToFree, critical("height can't be a negative value")
 }
 
-// unmarshalPageTable a pageTable
-func unmarshalPageTable(data []byte) (entries []int64, err error) {
+// unmarshalPageTable unmarshals a pageTable. The extents of pageTables that
+// store extents are expanded into the slots of their pages using the slotSize
+// of the file.
+func unmarshalPageTable(data []byte, slotSize int64) (entries []int64, err error) {
 	// The data should be at least 8 bytes long
 	if len(data) < 8 {
-		panic("input data is too shot")
+		return nil, fmt.Errorf("%w: pageTable is too short", ErrCorrupted)
 	}
 
 	// off is a offset used for unmarshaling the data
@@ -529,39 +831,59 @@ func unmarshalPageTable(data []byte) (entries []int64, err error) {
 	// Unmarshal the number of entries in the table
 	numEntries := binary.LittleEndian.Uint64(data[off:8])
 	off += 8
+	if numEntries&pageTableFlag == 0 {
+		return nil, fmt.Errorf("%w: pageTable isn't marked", ErrCorrupted)
+	}
+	isExtentTable := numEntries&extentTableFlag != 0
+	numEntries &^= extentTableFlag | pageTableFlag
 
 	// Sanity check numEntries
 	if numEntries > numPageEntries {
-		panic(fmt.Sprintf("Sanity check failed. numEntries(%v) > numPageEntries(%v)",
-			numEntries, numPageEntries))
+		return nil, fmt.Errorf("%w: numEntries(%v) > numPageEntries(%v)",
+			ErrCorrupted, numEntries, numPageEntries)
 	}
 
 	// Sanity check the remaining data length
 	if uint64(len(data[off:])) < numEntries*8 {
-		panic(fmt.Sprintf("Sanity check failed. %v < %v", len(data[off:]), numEntries*8))
+		return nil, fmt.Errorf("%w: %v < %v", ErrCorrupted, len(data[off:]), numEntries*8)
+	}
+
+	// Unmarshal the extents
+	if isExtentTable {
+		return unmarshalExtents(data[off:], numEntries, slotSize)
 	}
 
 	// Unmarshal the entries
 	for i := uint64(0); i < numEntries; i++ {
-		offset, bytesRead := binary.Varint(data[off : off+8])
-		if offset == 0 && bytesRead <= 0 {
-			err = errors.New("Failed to unmarshal offset")
-			return
+		slot := binary.LittleEndian.Uint64(data[off : off+8])
+		if slot&slotValidFlag == 0 || slot&^slotValidFlag >= maxFileSize {
+			return nil, fmt.Errorf("%w: failed to unmarshal offset %v", ErrCorrupted, i)
 		}
 		off += 8
-		entries = append(entries, offset)
+		entries = append(entries, int64(slot&^slotValidFlag))
 	}
 	return
 }
 
+// unmarshalExtents unmarshals the extents of a pageTable and expands them into
+// the slots of their pages
+func unmarshalExtents(data []byte, numExtents uint64, slotSize int64) (entries []int64, err error) {
+	for i := uint64(0); i < numExtents; i++ {
+		slot, count := decodeExtent(binary.LittleEndian.Uint64(data[8*i:]))
+		if _, storedSize := decodeSlot(slot); count > 1 && storedSize > 0 {
+			return nil, fmt.Errorf("%w: extent %v contains compressed pages", ErrCorrupted, i)
+		}
+		for j := int64(0); j < count; j++ {
+			entries = append(entries, slot+j*slotSize)
+		}
+	}
+	return entries, nil
+}
+
 // writeTieredPageEntry writes the usedBytes of a pageTable and a ptr to the
 // pageTable at a specific offset in the entryPage
 func writeTieredPageEntry(pp *physicalPage, index int64, usedBytes int64, pageOff int64) error {
-	data := make([]byte, tieredPageEntrySize)
-
-	// Marshal usedBytes and pageOff
-	binary.PutVarint(data[0:8], usedBytes)
-	binary.PutVarint(data[8:], pageOff)
+	data := marshalTieredPageEntry(usedBytes, pageOff)
 
 	// Write the data to disk
 	if _, err := pp.writeAt(data, index*tieredPageEntrySize); err != nil {
@@ -569,3 +891,16 @@ func writeTieredPageEntry(pp *physicalPage, index int64, usedBytes int64, pageOf
 	}
 	return nil
 }
+
+// clearTieredPageEntry clears the entry at a specific offset in the
+// entryPage
+func clearTieredPageEntry(pp *physicalPage, index int64) error {
+	_, err := pp.writeAt(make([]byte, tieredPageEntrySize), index*tieredPageEntrySize)
+	return err
+}
+
+// writeTieredPageRoot writes the entry of the current root of a tieredPage's
+// pageTable tree. The entry is marked with entryRootFlag.
+func writeTieredPageRoot(pp *physicalPage, height int64, usedBytes int64, rootOff int64) error {
+	return writeTieredPageEntry(pp, height, usedBytes, rootOff|entryRootFlag)
+}
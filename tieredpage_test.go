@@ -1,6 +1,10 @@
 package pages
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/NebulousLabs/fastrand"
@@ -26,8 +30,8 @@ func TestWriteReadPageEntryPageEntry(t *testing.T) {
 		t.Errorf("Failed to write entry: %v", err)
 	}
 
-	readUsedBytes, readPageOff, err := readEntryPageEntry(pp, offset)
-	if err != nil {
+	readUsedBytes, readPageOff, valid, err := readEntryPageEntry(pp, offset)
+	if err != nil || !valid {
 		t.Errorf("Failed to read entry: %v", err)
 	}
 	if readUsedBytes != usedBytes {
@@ -37,6 +41,13 @@ func TestWriteReadPageEntryPageEntry(t *testing.T) {
 		t.Errorf("Failed to read pageOff. Expected %v but was %v", pageOff, readPageOff)
 	}
 
+	// Cleared entries should be invalid
+	if err := clearTieredPageEntry(pp, offset); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, valid, err := readEntryPageEntry(pp, offset); err != nil || valid {
+		t.Errorf("entry should be invalid: %v", err)
+	}
 }
 
 // TestAddPage tests if entryPage addPages works as expected
@@ -52,40 +63,31 @@ func TestAddPage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Write more than numPageEntries page to the entry to force an extension
-	// of the tree
+	// Write more than numPageEntries non-adjacent pages to the entry to force
+	// an extension of the tree
 	bytesWritten := int(numPageEntries*pageSize + 1)
-	if _, err := entry.Write(fastrand.Bytes(bytesWritten)); err != nil {
+	if err := writeFragmented(pt.pm, entry, fastrand.Bytes(bytesWritten)); err != nil {
 		t.Errorf("Failed to write the data to disk: %v", err)
 	}
 
 	// Get the physical page on which the entryPage is stored
 	pp := entry.ep.pp
 
-	// Check if usedBytes and pageOff of the first entry are set correctly
-	usedBytes, pageOff, err := readEntryPageEntry(pp, 0)
-	if err != nil {
-		t.Errorf("Failed to read entry: %v", err)
-	}
-	if usedBytes != numPageEntries*pageSize {
-		t.Errorf("UsedBytes has wrong value. Expected %v, but was %v",
-			numPageEntries*pageSize, usedBytes)
-	}
-	expectedOff := entry.ep.root.childTables[0].pp.fileOff
-	if pageOff != expectedOff {
-		t.Errorf("pageOff has wrong value. Expected %v, but was %v", expectedOff, pageOff)
+	// The entry of the previous root should be cleared
+	if _, _, valid, err := readEntryPageEntry(pp, 0); err != nil || valid {
+		t.Errorf("the first entry should be cleared: %v", err)
 	}
 
 	// Check if usedBytes and pageOff of the second entry are set correctly
-	usedBytes, pageOff, err = readEntryPageEntry(pp, 1)
-	if err != nil {
+	usedBytes, pageOff, valid, err := readEntryPageEntry(pp, 1)
+	if err != nil || !valid {
 		t.Errorf("Failed to read entry: %v", err)
 	}
 	if usedBytes != int64(bytesWritten) {
 		t.Errorf("UsedBytes has wrong value. Expected %v, but was %v",
 			bytesWritten, usedBytes)
 	}
-	expectedOff = entry.ep.root.pp.fileOff
+	expectedOff := entry.ep.root.pp.fileOff | entryRootFlag
 	if pageOff != expectedOff {
 		t.Errorf("pageOff has wrong value. Expected %v, but was %v", expectedOff, pageOff)
 	}
@@ -132,58 +134,9 @@ func TestDefrag(t *testing.T) {
 	}
 
 	// There should be numPages + 2 (for the pagetables) free pages now
-	if len(pt.pm.freePages.pages) != numPages+2 {
+	if pt.pm.bitmap.Len() != numPages+2 {
 		t.Logf("expected free pages %v but was %v",
-			numPageEntries+2, len(pt.pm.freePages.pages))
-	}
-}
-
-// TestFreePage checks if getting free pages from the recyclingPage works as expected.
-func TestFreePage(t *testing.T) {
-	// Get a paging tester
-	pt, err := newPagingTester(t.Name())
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Get a new entry
-	entry, _, err := pt.pm.Create()
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Write numPageEntries + 1 pages to the entry
-	numPages := int(numPageEntries + 1)
-	if _, err := entry.Write(fastrand.Bytes(numPages * pageSize)); err != nil {
-		t.Errorf("Failed to write pages: %v", err)
-	}
-
-	// Free all the pages again
-	if err := entry.Truncate(0); err != nil {
-		t.Errorf("Failed to truncate entry: %v", err)
-	}
-
-	freePages := entry.pm.freePages
-	var expectedPage *physicalPage
-	for len(freePages.pagesToFree)+len(freePages.pages) > 0 {
-		// If buffer is not empty we expect a buffered page
-		if len(freePages.pagesToFree) > 0 {
-			expectedPage = freePages.pagesToFree[len(freePages.pagesToFree)-1]
-		} else {
-			expectedPage = freePages.pages[len(freePages.pages)-1]
-		}
-		newPage, err := entry.pm.freePages.freePage()
-		if err != nil {
-			t.Fatalf("Failed to free page: %v", err)
-		}
-		if expectedPage != newPage {
-			t.Errorf("Returned page didn't match expected page")
-		}
-	}
-
-	// Check if all free pages are used
-	if len(freePages.pagesToFree)+len(freePages.pages) != 0 {
-		t.Errorf("there should be no more free pages")
+			numPageEntries+2, pt.pm.bitmap.Len())
 	}
 }
 
@@ -201,8 +154,11 @@ func TestInsertPage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Insert numPageEntries + 1 pages into the entry
+	// Insert numPageEntries + 1 non-adjacent pages into the entry
 	for i := 0; i < numPageEntries+1; i++ {
+		if _, err := pt.pm.allocatePage(); err != nil {
+			t.Errorf("Failed to allocate page: %v", err)
+		}
 		pp, err := pt.pm.allocatePage()
 		if err != nil {
 			t.Errorf("Failed to allocate page: %v", err)
@@ -237,3 +193,203 @@ func TestInsertPage(t *testing.T) {
 		}
 	}
 }
+
+// TestTablesNeeded tests if tablesNeeded returns the number of pageTables that
+// inserting pages creates
+func TestTablesNeeded(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := entry.ep
+
+	// Insert batches of adjacent, non-adjacent and compressed pages. The
+	// pages are never read, so they don't need to exist.
+	fileOff := int64(1 << 40)
+	batches := []struct {
+		numPages int
+		adjacent bool
+		compress bool
+	}{
+		{1, false, false},
+		{numPageEntries - 1, false, false},
+		{2, false, false},
+		{100, true, false},
+		{numPageEntries, false, false},
+		{10, true, true},
+		{3 * numPageEntries, true, false},
+	}
+	for i, batch := range batches {
+		pages := make([]*physicalPage, batch.numPages)
+		for j := range pages {
+			if !batch.adjacent {
+				fileOff += pageSize
+			}
+			pages[j] = &physicalPage{
+				file:     pt.pm.file,
+				fileOff:  fileOff,
+				usedSize: pageSize,
+				compress: batch.compress,
+			}
+			fileOff += pageSize
+		}
+		expected := ep.tablesNeeded(pages)
+		before := len(tablePages(ep.root))
+		for _, pp := range pages {
+			if err := ep.insertPage(uint64(len(ep.pages)), pp); err != nil {
+				t.Fatal(err)
+			}
+			ep.pages = append(ep.pages, pp)
+		}
+		if created := len(tablePages(ep.root)) - before; created != expected {
+			t.Fatalf("batch %v should have created %v tables but created %v", i, expected, created)
+		}
+	}
+	if ep.root.height != 1 {
+		t.Fatalf("root should have height 1 but had %v", ep.root.height)
+	}
+}
+
+// compareTrees is a helper function that returns an error if two pageTable
+// trees don't have the same structure
+func compareTrees(a, b *pageTable) error {
+	if a.height != b.height || a.firstPage != b.firstPage || a.pp.fileOff != b.pp.fileOff {
+		return fmt.Errorf("pageTable at %v doesn't match: height %v/%v firstPage %v/%v",
+			a.pp.fileOff, a.height, b.height, a.firstPage, b.firstPage)
+	}
+	if len(a.childTables) != len(b.childTables) || len(a.childPages) != len(b.childPages) {
+		return fmt.Errorf("pageTable at %v has %v/%v childTables and %v/%v childPages", a.pp.fileOff,
+			len(a.childTables), len(b.childTables), len(a.childPages), len(b.childPages))
+	}
+	for i := uint64(0); i < uint64(len(a.childPages)); i++ {
+		if b.childPages[i] == nil || a.childPages[i].fileOff != b.childPages[i].fileOff {
+			return fmt.Errorf("childPage %v of pageTable at %v doesn't match", i, a.pp.fileOff)
+		}
+	}
+	for i := uint64(0); i < uint64(len(a.childTables)); i++ {
+		if b.childTables[i] == nil || b.childTables[i].parent != b {
+			return fmt.Errorf("childTable %v of pageTable at %v is missing", i, a.pp.fileOff)
+		}
+		if err := compareTrees(a.childTables[i], b.childTables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestInsertPageHeightTwo tests if pages are inserted into the right
+// pageTables of a tree with a height of 2 and if the tree is recovered
+// correctly
+func TestInsertPageHeightTwo(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	entry, _, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := entry.ep
+
+	// Insert non-adjacent pages until the tree has a height of 2. The pages
+	// are never read, so they don't need to exist.
+	numPages := int(numPageEntries*numPageEntries + numPageEntries + 1)
+	pages := make([]*physicalPage, numPages)
+	for i := range pages {
+		pages[i] = &physicalPage{
+			file:     pt.pm.file,
+			fileOff:  int64(1<<40 + 2*i*pageSize),
+			usedSize: pageSize,
+		}
+		if err := ep.insertPage(uint64(i), pages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ep.root.height != 2 {
+		t.Fatalf("root should have height 2 but had %v", ep.root.height)
+	}
+
+	// Every page should be found in the leaf that covers its index
+	for i, pp := range pages {
+		leaf := ep.leafPageTable(uint64(i))
+		if leaf.childPages[uint64(i)-leaf.firstPage] != pp {
+			t.Fatalf("page %v is in the wrong pageTable", i)
+		}
+	}
+
+	// Recover the tree from disk and compare it to the original one
+	ep.usedSize = int64(numPages) * pageSize
+	if err := ep.writeRoot(0); err != nil {
+		t.Fatal(err)
+	}
+	usedSize, rootOff, height, err := readTieredPageRoot(ep.pp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := &tieredPage{
+		pp:       ep.pp,
+		usedSize: usedSize,
+		pm:       pt.pm,
+		mu:       new(sync.RWMutex),
+	}
+	if err := tp.recoverTree(context.Background(), rootOff, height); err != nil {
+		t.Fatal(err)
+	}
+	if err := compareTrees(ep.root, tp.root); err != nil {
+		t.Fatal(err)
+	}
+	for i, pp := range tp.pages {
+		if pp.fileOff != pages[i].fileOff {
+			t.Fatalf("recovered page %v doesn't match", i)
+		}
+	}
+}
+
+// TestRecoverFragmentedEntry tests if the tree of an entry that consists of
+// multiple pageTables is recovered correctly when it is opened again
+func TestRecoverFragmentedEntry(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+	entry, id, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := fastrand.Bytes(2*numPageEntries*pageSize + 1)
+	if err := writeFragmented(pt.pm, entry, data); err != nil {
+		t.Fatal(err)
+	}
+	root := entry.ep.root
+	if root.height != 1 || len(root.childTables) != 3 {
+		t.Fatalf("tree should have height 1 and 3 leaves but had %v and %v", root.height, len(root.childTables))
+	}
+
+	// Open the entry again after closing it to recover the tree
+	entry.Close()
+	entry, err = pt.pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+	if err := compareTrees(root, entry.ep.root); err != nil {
+		t.Fatal(err)
+	}
+	readData := make([]byte, len(data))
+	if _, err := entry.ReadAt(readData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, readData) {
+		t.Fatal("data doesn't match")
+	}
+}
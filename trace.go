@@ -0,0 +1,85 @@
+package pages
+
+// The names of the spans passed to a Tracer
+const (
+	// SpanOpen covers loading an entry in PageManager.Open
+	SpanOpen = "pages.Open"
+
+	// SpanRecoverTree covers reading the pageTables of a tieredPage from disk
+	SpanRecoverTree = "pages.recoverTree"
+
+	// SpanRead covers reading from an entry
+	SpanRead = "pages.Entry.read"
+
+	// SpanWrite covers writing to an entry
+	SpanWrite = "pages.Entry.write"
+
+	// SpanInsertPage covers inserting a page into a pageTable tree
+	SpanInsertPage = "pages.insertPage"
+
+	// SpanTruncate covers removing pages from a pageTable tree
+	SpanTruncate = "pages.recursiveTruncate"
+
+	// SpanDefrag covers reducing the height of a pageTable tree
+	SpanDefrag = "pages.defrag"
+
+	// SpanAllocatePage covers allocating pages
+	SpanAllocatePage = "pages.allocatePage"
+)
+
+type (
+	// Tracer receives spans around the operations of a PageManager. It can
+	// be used to find out which part of an operation is slow. Its methods
+	// are called concurrently and shouldn't block.
+	Tracer interface {
+		// StartSpan is called when an operation starts. The returned Span
+		// is ended when the operation is done. Spans of operations that are
+		// part of another operation are started while the outer span is
+		// still active.
+		StartSpan(name string, info SpanInfo) Span
+	}
+
+	// Span is an operation that was started by a Tracer
+	Span interface {
+		// End is called when the operation is done. info contains the
+		// results of the operation and err is the error of the operation
+		// or nil.
+		End(info SpanInfo, err error)
+	}
+
+	// SpanInfo describes the operation of a span
+	SpanInfo struct {
+		// ID is the Identifier of the entry the operation belongs to. It is
+		// zero for operations that don't know their entry.
+		ID Identifier
+
+		// PageOffset is the offset of the page within the file the
+		// operation works on or -1 if there is none. The span of an
+		// allocation ends with the offset of the allocated page.
+		PageOffset int64
+
+		// Offset is the offset within the entry at which the operation
+		// starts
+		Offset int64
+
+		// Bytes is the number of bytes the operation reads, writes or
+		// removes. Spans of reads and writes end with the number of bytes
+		// that were actually read or written.
+		Bytes int64
+	}
+
+	// noopSpan is the Span used if there is no Tracer
+	noopSpan struct{}
+)
+
+// End does nothing
+func (noopSpan) End(SpanInfo, error) {}
+
+// startSpan starts a span using the Tracer of the PageManager. If there is
+// no Tracer, the returned Span does nothing.
+func (p *PageManager) startSpan(name string, info SpanInfo) Span {
+	if p == nil || p.opts.Tracer == nil {
+		return noopSpan{}
+	}
+	return p.opts.Tracer.StartSpan(name, info)
+}
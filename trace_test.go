@@ -0,0 +1,146 @@
+package pages
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+type (
+	// testTracer is a Tracer that records the spans
+	testTracer struct {
+		spans []*testSpan
+		mu    *sync.Mutex
+	}
+
+	// testSpan is a span recorded by a testTracer
+	testSpan struct {
+		name  string
+		start SpanInfo
+		end   SpanInfo
+		ended bool
+		err   error
+		mu    *sync.Mutex
+	}
+)
+
+// StartSpan records a new span
+func (tt *testTracer) StartSpan(name string, info SpanInfo) Span {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	span := &testSpan{
+		name:  name,
+		start: info,
+		mu:    tt.mu,
+	}
+	tt.spans = append(tt.spans, span)
+	return span
+}
+
+// End records the end of the span
+func (ts *testSpan) End(info SpanInfo, err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.end = info
+	ts.ended = true
+	ts.err = err
+}
+
+// spansByName returns the recorded spans with a certain name
+func (tt *testTracer) spansByName(name string) []*testSpan {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	var spans []*testSpan
+	for _, span := range tt.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// TestTracer tests if the operations of a PageManager create the right spans
+func TestTracer(t *testing.T) {
+	path, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &testTracer{mu: new(sync.Mutex)}
+	pm, err := NewWithOptions(path, Options{Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	// Write, read and truncate an entry
+	entry, id, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(fastrand.Bytes(3 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.ReadAt(make([]byte, pageSize), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+
+	// Open the entry again to recover its tree
+	entry, err = pm.Open(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Close()
+
+	// Every span should have been ended without an error
+	for _, span := range tracer.spans {
+		if !span.ended || span.err != nil {
+			t.Fatalf("span %v wasn't ended correctly: %v", span.name, span.err)
+		}
+	}
+
+	// Check the spans of the entry's operations
+	writes := tracer.spansByName(SpanWrite)
+	if len(writes) != 1 || writes[0].start.ID != id || writes[0].start.Bytes != 3*pageSize ||
+		writes[0].end.Bytes != 3*pageSize || writes[0].end.PageOffset != -1 {
+		t.Fatalf("wrong write spans %+v", writes)
+	}
+	reads := tracer.spansByName(SpanRead)
+	if len(reads) != 1 || reads[0].start.Offset != 10 || reads[0].end.Bytes != pageSize ||
+		reads[0].start.PageOffset != entry.ep.pages[0].fileOff {
+		t.Fatalf("wrong read spans %+v", reads)
+	}
+	truncates := tracer.spansByName(SpanTruncate)
+	if len(truncates) != 1 || truncates[0].start.ID != id || truncates[0].end.Bytes != 2*pageSize {
+		t.Fatalf("wrong truncate spans %+v", truncates)
+	}
+	if defrags := tracer.spansByName(SpanDefrag); len(defrags) != 1 || defrags[0].start.ID != id {
+		t.Fatalf("wrong defrag spans %+v", defrags)
+	}
+	if opens := tracer.spansByName(SpanOpen); len(opens) != 1 || opens[0].end.PageOffset != entry.ep.pp.fileOff {
+		t.Fatalf("wrong open spans %+v", opens)
+	}
+	if recoveries := tracer.spansByName(SpanRecoverTree); len(recoveries) != 1 || recoveries[0].end.Bytes != pageSize {
+		t.Fatalf("wrong recoverTree spans %+v", recoveries)
+	}
+
+	// Every data page was inserted and allocated
+	inserts := 0
+	for _, span := range tracer.spansByName(SpanInsertPage) {
+		if span.start.ID == id {
+			inserts++
+		}
+	}
+	if inserts != 3 {
+		t.Fatalf("3 pages should have been inserted but %v were", inserts)
+	}
+	for _, span := range tracer.spansByName(SpanAllocatePage) {
+		if span.end.PageOffset < dataOff || span.end.Bytes < pageSize {
+			t.Fatalf("wrong allocatePage span %+v", span.end)
+		}
+	}
+}
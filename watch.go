@@ -0,0 +1,231 @@
+package pages
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// Event describes a change of an entry. It is one of Appended,
+	// Overwritten, Truncated or Deleted.
+	Event interface {
+		event()
+	}
+
+	// Appended is emitted after data was appended to an entry
+	Appended struct {
+		// NewSize is the size of the entry after the append
+		NewSize int64
+	}
+
+	// Overwritten is emitted after existing data of an entry was overwritten
+	Overwritten struct {
+		// Off is the offset of the overwritten data
+		Off int64
+
+		// Len is the number of overwritten bytes
+		Len int64
+	}
+
+	// Truncated is emitted after an entry was truncated
+	Truncated struct {
+		// Size is the size of the entry after the truncation
+		Size int64
+	}
+
+	// Deleted is emitted after an entry was deleted. It is the last event of
+	// a watcher.
+	Deleted struct{}
+
+	// watcher delivers the events of an entry to a single subscriber. Events
+	// are queued so that writers never block on slow subscribers. The queue
+	// is coalesced once it reaches maxPendingEvents.
+	watcher struct {
+		// events is the channel returned to the subscriber
+		events chan Event
+
+		// pending are the events that weren't delivered yet
+		pending []Event
+
+		// closed indicates that no more events are queued
+		closed bool
+
+		// notify signals the delivering thread that pending changed
+		notify chan struct{}
+
+		// mu protects pending and closed
+		mu *sync.Mutex
+	}
+)
+
+// maxPendingEvents is the number of events that are queued for a watcher
+// before they are coalesced
+const maxPendingEvents = 1024
+
+func (Appended) event()    {}
+func (Overwritten) event() {}
+func (Truncated) event()   {}
+func (Deleted) event()     {}
+
+// newWatcher creates a watcher
+func newWatcher() *watcher {
+	return &watcher{
+		events: make(chan Event),
+		notify: make(chan struct{}, 1),
+		mu:     new(sync.Mutex),
+	}
+}
+
+// push queues an event. If last is true, the watcher is closed after the
+// event was delivered.
+func (w *watcher) push(ev Event, last bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if ev != nil {
+		w.pending = append(w.pending, ev)
+	}
+	if len(w.pending) >= maxPendingEvents && !last {
+		w.pending = coalesceEvents(w.pending)
+	}
+	w.closed = last
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// coalesceEvents replaces a sequence of Appended, Overwritten and Truncated
+// events with at most three events that cover all of their changes: the
+// smallest size the entry was truncated to, the final size if it grew again
+// and a single Overwritten event that spans all the overwritten data which is
+// still part of the entry.
+func coalesceEvents(events []Event) []Event {
+	size, minSize := int64(-1), int64(-1)
+	start, end := int64(-1), int64(-1)
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case Appended:
+			size = ev.NewSize
+		case Truncated:
+			size = ev.Size
+			if minSize < 0 || ev.Size < minSize {
+				minSize = ev.Size
+			}
+		case Overwritten:
+			if start < 0 || ev.Off < start {
+				start = ev.Off
+			}
+			if ev.Off+ev.Len > end {
+				end = ev.Off + ev.Len
+			}
+		}
+	}
+
+	var coalesced []Event
+	if minSize >= 0 {
+		coalesced = append(coalesced, Truncated{Size: minSize})
+	}
+	if size > minSize {
+		coalesced = append(coalesced, Appended{NewSize: size})
+	}
+	if size >= 0 && end > size {
+		end = size
+	}
+	if start >= 0 && end > start {
+		coalesced = append(coalesced, Overwritten{Off: start, Len: end - start})
+	}
+	return coalesced
+}
+
+// threadedDeliver sends the queued events to the subscriber until the
+// watcher is closed or ctx is cancelled. remove is called when the watcher
+// stops because of ctx.
+func (w *watcher) threadedDeliver(ctx context.Context, remove func()) {
+	defer close(w.events)
+	for {
+		w.mu.Lock()
+		events, closed := w.pending, w.closed
+		w.pending = nil
+		w.mu.Unlock()
+
+		for _, ev := range events {
+			select {
+			case w.events <- ev:
+			case <-ctx.Done():
+				remove()
+				return
+			}
+		}
+		if closed {
+			return
+		}
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			remove()
+			return
+		}
+	}
+}
+
+// Watch subscribes to the changes of an entry. The returned channel receives
+// the events of all writes and truncations after they were committed. It is
+// closed after the entry was deleted, the PageManager was closed or ctx was
+// cancelled. Events are queued until they are received. If more than
+// maxPendingEvents events are queued, they are coalesced into at most one
+// Truncated, Appended and Overwritten event that cover all the queued changes. Changes
+// that fail or are cancelled don't emit events.
+func (p *PageManager) Watch(ctx context.Context, id Identifier) (<-chan Event, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.exit()
+
+	// Register the watcher while holding p.mu to make sure the entry isn't
+	// deleted in the meantime
+	p.lock()
+	defer p.mu.Unlock()
+	if _, err := p.entryPageOffset(id); err != nil {
+		return nil, withIdentifier(err, id)
+	}
+	w := newWatcher()
+	p.watchMu.Lock()
+	if p.watchers[id] == nil {
+		p.watchers[id] = make(map[*watcher]struct{})
+	}
+	p.watchers[id][w] = struct{}{}
+	p.watchMu.Unlock()
+
+	go w.threadedDeliver(ctx, func() {
+		p.watchMu.Lock()
+		defer p.watchMu.Unlock()
+		delete(p.watchers[id], w)
+		if len(p.watchers[id]) == 0 {
+			delete(p.watchers, id)
+		}
+	})
+	return w.events, nil
+}
+
+// notify sends an event to all the watchers of an entry
+func (p *PageManager) notify(id Identifier, ev Event) {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+	for w := range p.watchers[id] {
+		w.push(ev, false)
+	}
+}
+
+// closeWatchers sends a final event to all the watchers of an entry and closes
+// them. If ev is nil, the watchers are closed without an event.
+func (p *PageManager) closeWatchers(id Identifier, ev Event) {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+	for w := range p.watchers[id] {
+		w.push(ev, true)
+	}
+	delete(p.watchers, id)
+}
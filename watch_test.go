@@ -0,0 +1,198 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NebulousLabs/fastrand"
+)
+
+// nextEvent is a helper function that receives the next event from a watcher
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("events channel was closed")
+		}
+		return ev
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return nil
+}
+
+// TestWatch tests if watchers receive the events of writes, truncations and
+// deletions in order
+func TestWatch(t *testing.T) {
+	pt, err := newPagingTester(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pt.Close()
+
+	entry, identifier, err := pt.pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Watching a non-existent entry should fail
+	if _, err := pt.pm.Watch(context.Background(), newIdentifier(identifier.index()+1, 0)); err == nil {
+		t.Fatal("Watching a non-existent entry should fail")
+	}
+
+	// Subscribe twice and cancel the second subscription right away
+	events, err := pt.pm.Watch(context.Background(), identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelledEvents, err := pt.pm.Watch(ctx, identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, ok := <-cancelledEvents; ok {
+		t.Error("channel of cancelled watcher should be closed")
+	}
+
+	// Append, overwrite, overwrite and append at the same time and truncate
+	if _, err := entry.Write(fastrand.Bytes(2 * pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.WriteAt(fastrand.Bytes(50), 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.WriteAt(fastrand.Bytes(3000), 2*pageSize-1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Truncate(pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := entry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.pm.Delete(identifier); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Event{
+		Appended{NewSize: 2 * pageSize},
+		Overwritten{Off: 100, Len: 50},
+		Overwritten{Off: 2*pageSize - 1000, Len: 1000},
+		Appended{NewSize: 2*pageSize + 2000},
+		Truncated{Size: pageSize},
+		Deleted{},
+	}
+	for i, ev := range expected {
+		if received := nextEvent(t, events); received != ev {
+			t.Errorf("event %v should be %#v but was %#v", i, ev, received)
+		}
+	}
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed after the entry was deleted")
+	}
+
+	// All watchers should be removed
+	pt.pm.watchMu.Lock()
+	if len(pt.pm.watchers) != 0 {
+		t.Errorf("there should be no watchers but there were %v", len(pt.pm.watchers))
+	}
+	pt.pm.watchMu.Unlock()
+}
+
+// TestWatchSyncError tests if changes whose sync failed don't emit events
+func TestWatchSyncError(t *testing.T) {
+	dataFilePath, err := newTestDataFilePath(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewWithOptions(dataFilePath, Options{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, identifier, err := pm.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := pm.Watch(context.Background(), identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the next sync fail
+	syncErr := errors.New("sync failed")
+	pm.syncer.syncFile = func() error {
+		return syncErr
+	}
+	if _, err := entry.Write(fastrand.Bytes(pageSize)); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+	if err := entry.Truncate(0); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+	if err := pm.Close(); !errors.Is(err, syncErr) {
+		t.Fatalf("err should be %v but was %v", syncErr, err)
+	}
+	if ev, ok := <-events; ok {
+		t.Fatalf("there should be no events but there was %#v", ev)
+	}
+}
+
+// TestCoalesceEvents tests if the events of a watcher are coalesced once too
+// many of them are queued
+func TestCoalesceEvents(t *testing.T) {
+	tests := []struct {
+		events   []Event
+		expected []Event
+	}{
+		{
+			[]Event{Appended{NewSize: 10}, Appended{NewSize: 20}},
+			[]Event{Appended{NewSize: 20}},
+		},
+		{
+			[]Event{Overwritten{Off: 5, Len: 5}, Overwritten{Off: 20, Len: 10}},
+			[]Event{Overwritten{Off: 5, Len: 25}},
+		},
+		{
+			[]Event{
+				Overwritten{Off: 50, Len: 50},
+				Truncated{Size: 10},
+				Appended{NewSize: 60},
+				Truncated{Size: 30},
+				Overwritten{Off: 0, Len: 5},
+			},
+			[]Event{
+				Truncated{Size: 10},
+				Appended{NewSize: 30},
+				Overwritten{Off: 0, Len: 30},
+			},
+		},
+		{
+			[]Event{Appended{NewSize: 60}, Truncated{Size: 10}},
+			[]Event{Truncated{Size: 10}},
+		},
+	}
+	for i, test := range tests {
+		coalesced := coalesceEvents(test.events)
+		if len(coalesced) != len(test.expected) {
+			t.Fatalf("%v: events should be %v but were %v", i, test.expected, coalesced)
+		}
+		for j := range coalesced {
+			if coalesced[j] != test.expected[j] {
+				t.Errorf("%v: events should be %v but were %v", i, test.expected, coalesced)
+			}
+		}
+	}
+
+	// The queue of a watcher doesn't grow beyond maxPendingEvents
+	w := newWatcher()
+	for i := 0; i < 10*maxPendingEvents; i++ {
+		w.push(Appended{NewSize: int64(i + 1)}, false)
+		w.push(Overwritten{Off: int64(i), Len: 1}, false)
+	}
+	if len(w.pending) >= maxPendingEvents {
+		t.Fatalf("%v events are queued", len(w.pending))
+	}
+}